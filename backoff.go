@@ -0,0 +1,66 @@
+package stadiacontroller
+
+import (
+	"sync"
+	"time"
+)
+
+// Default parameters of the Backoff used by a StadiaController.
+const (
+	DefaultBackoffBase = 1 * time.Second
+	DefaultBackoffMax  = 30 * time.Second
+)
+
+// A Backoff computes exponentially increasing delays between attempts, from
+// Base up to Max. It is safe for concurrent use.
+type Backoff struct {
+	Base time.Duration
+	Max  time.Duration
+
+	mu   sync.Mutex
+	next time.Duration
+}
+
+// NewBackoff returns a Backoff whose delays start at base and are doubled
+// after each attempt, up to max.
+func NewBackoff(base, max time.Duration) *Backoff {
+	return &Backoff{Base: base, Max: max}
+}
+
+// Next returns the delay to wait before the next attempt, and doubles the
+// delay for the following one.
+func (b *Backoff) Next() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.next < b.Base {
+		b.next = b.Base
+	}
+
+	delay := b.next
+
+	if b.next *= 2; b.next > b.Max {
+		b.next = b.Max
+	}
+
+	return delay
+}
+
+// Reset makes the next delay Base again, e.g. after a successful attempt.
+func (b *Backoff) Reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.next = b.Base
+}
+
+// WithBackoff sets the delays between attempts to find a device after an
+// enumeration failed or a device was lost, which start at base and are
+// doubled up to max until a report is read. They default to
+// DefaultBackoffBase and DefaultBackoffMax.
+func WithBackoff(base, max time.Duration) Option {
+	return func(o *options) {
+		o.backoffBase = base
+		o.backoffMax = max
+	}
+}
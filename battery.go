@@ -0,0 +1,87 @@
+package stadiacontroller
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrBatteryUnavailable is returned by BatteryLevel when the controller does
+// not report the state of its battery.
+var ErrBatteryUnavailable = errors.New("battery level unavailable")
+
+// Usages of the battery values defined by the HID Usage Tables.
+const (
+	usagePageGenericDeviceControls = 0x06
+	usageBatteryStrength           = 0x20
+
+	usagePageBatterySystem = 0x85
+	usageCharging          = 0x44
+)
+
+// BatteryLevel returns the charge of the battery of the controller, between
+// 0 and 100, and whether it is charging.
+//
+// The 0x03 input report carries no battery information, so the charge is
+// looked up in the report descriptor of the controller, as the Battery
+// Strength usage of the Generic Device Controls page, and read from the
+// feature or input report which holds it. Charging is only reported if the
+// descriptor also declares the Charging usage of the Battery System page.
+//
+// Controllers which do not declare a battery, which is usually the case over
+// USB, return an error wrapping ErrBatteryUnavailable rather than a guess.
+func (c *StadiaController) BatteryLevel() (percent int, charging bool, err error) {
+	// Keep the device from being closed by a concurrent disconnect or Close
+	// while reading from it, without holding mu, which would stall GetReport
+	// and Vibrate for as long as the read blocks.
+	c.deviceMu.RLock()
+	defer c.deviceMu.RUnlock()
+
+	device, err := c.state()
+
+	if device == nil {
+		if err != nil {
+			return 0, false, err
+		}
+		return 0, false, ErrBatteryUnavailable
+	}
+
+	reader, ok := device.(UsageReader)
+
+	if !ok {
+		return 0, false, ErrBatteryUnavailable
+	}
+
+	strength, err := reader.ReadUsageValue(usagePageGenericDeviceControls, usageBatteryStrength)
+
+	if err != nil {
+		return 0, false, fmt.Errorf("%w: %v", ErrBatteryUnavailable, err)
+	}
+
+	percent, err = batteryPercent(strength)
+
+	if err != nil {
+		return 0, false, err
+	}
+
+	if value, err := reader.ReadUsageValue(usagePageBatterySystem, usageCharging); err == nil {
+		charging = value.Value != 0
+	}
+
+	return percent, charging, nil
+}
+
+// batteryPercent converts the given Battery Strength value into a percentage
+// of its logical range.
+func batteryPercent(strength UsageValue) (int, error) {
+	min, max := int64(strength.LogicalMin), int64(strength.LogicalMax)
+	value := int64(strength.Value)
+
+	if max <= min {
+		return 0, fmt.Errorf("%w: invalid logical range [%d, %d]", ErrBatteryUnavailable, min, max)
+	}
+	if value < min || value > max {
+		return 0, fmt.Errorf("%w: value %d out of logical range [%d, %d]", ErrBatteryUnavailable, value, min, max)
+	}
+
+	return int(((value-min)*100 + (max-min)/2) / (max - min)), nil
+}
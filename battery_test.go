@@ -0,0 +1,224 @@
+package stadiacontroller
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBatteryPercent(t *testing.T) {
+	for _, test := range []struct {
+		strength UsageValue
+		want     int
+		wantErr  bool
+	}{
+		{UsageValue{Value: 0, LogicalMin: 0, LogicalMax: 100}, 0, false},
+		{UsageValue{Value: 42, LogicalMin: 0, LogicalMax: 100}, 42, false},
+		{UsageValue{Value: 100, LogicalMin: 0, LogicalMax: 100}, 100, false},
+		{UsageValue{Value: 0, LogicalMin: 0, LogicalMax: 255}, 0, false},
+		{UsageValue{Value: 128, LogicalMin: 0, LogicalMax: 255}, 50, false},
+		{UsageValue{Value: 255, LogicalMin: 0, LogicalMax: 255}, 100, false},
+		{UsageValue{Value: 3, LogicalMin: 1, LogicalMax: 5}, 50, false},
+		{UsageValue{Value: -1, LogicalMin: -2, LogicalMax: 2}, 25, false},
+		{UsageValue{Value: 101, LogicalMin: 0, LogicalMax: 100}, 0, true},
+		{UsageValue{Value: -1, LogicalMin: 0, LogicalMax: 100}, 0, true},
+		{UsageValue{Value: 0, LogicalMin: 0, LogicalMax: 0}, 0, true},
+	} {
+		got, err := batteryPercent(test.strength)
+
+		if test.wantErr {
+			if !errors.Is(err, ErrBatteryUnavailable) {
+				t.Errorf("batteryPercent(%+v) error = %v, want ErrBatteryUnavailable", test.strength, err)
+			}
+			continue
+		}
+		if err != nil || got != test.want {
+			t.Errorf("batteryPercent(%+v) = %d, %v, want %d", test.strength, got, err, test.want)
+		}
+	}
+}
+
+// batteryDevice is a MockDevice which declares the given usage values.
+type batteryDevice struct {
+	*MockDevice
+
+	values map[[2]uint16]UsageValue
+}
+
+func (d *batteryDevice) ReadUsageValue(usagePage, usage uint16) (UsageValue, error) {
+	select {
+	case <-d.closed:
+		return UsageValue{}, ErrClosed
+	default:
+	}
+
+	value, ok := d.values[[2]uint16{usagePage, usage}]
+
+	if !ok {
+		return UsageValue{}, ErrUsageNotFound
+	}
+
+	return value, nil
+}
+
+func TestBatteryLevel(t *testing.T) {
+	strength := [2]uint16{usagePageGenericDeviceControls, usageBatteryStrength}
+	charging := [2]uint16{usagePageBatterySystem, usageCharging}
+
+	for _, test := range []struct {
+		name         string
+		values       map[[2]uint16]UsageValue
+		wantPercent  int
+		wantCharging bool
+		wantErr      bool
+	}{
+		{
+			name:    "no battery",
+			wantErr: true,
+		},
+		{
+			name:        "strength only",
+			values:      map[[2]uint16]UsageValue{strength: {Value: 64, LogicalMin: 0, LogicalMax: 255}},
+			wantPercent: 25,
+		},
+		{
+			name: "charging",
+			values: map[[2]uint16]UsageValue{
+				strength: {Value: 80, LogicalMin: 0, LogicalMax: 100},
+				charging: {Value: 1, LogicalMin: 0, LogicalMax: 1},
+			},
+			wantPercent:  80,
+			wantCharging: true,
+		},
+		{
+			name:    "out of range",
+			values:  map[[2]uint16]UsageValue{strength: {Value: 200, LogicalMin: 0, LogicalMax: 100}},
+			wantErr: true,
+		},
+	} {
+		device := &batteryDevice{NewMockDevice(nil, 0), test.values}
+		controller := NewStadiaControllerWithDevice(device)
+
+		percent, charging, err := controller.BatteryLevel()
+		controller.Close()
+
+		if test.wantErr {
+			if !errors.Is(err, ErrBatteryUnavailable) {
+				t.Errorf("%s: error = %v, want ErrBatteryUnavailable", test.name, err)
+			}
+			continue
+		}
+		if err != nil || percent != test.wantPercent || charging != test.wantCharging {
+			t.Errorf("%s: BatteryLevel() = %d, %v, %v, want %d, %v", test.name, percent, charging, err, test.wantPercent, test.wantCharging)
+		}
+	}
+
+	// A device which cannot look up usages has no battery level.
+	controller := NewStadiaControllerWithDevice(NewMockDevice(nil, 0))
+	defer controller.Close()
+
+	if _, _, err := controller.BatteryLevel(); !errors.Is(err, ErrBatteryUnavailable) {
+		t.Errorf("BatteryLevel() of MockDevice error = %v, want ErrBatteryUnavailable", err)
+	}
+}
+
+// TestBatteryLevelClose checks that BatteryLevel never reads from a device
+// which was closed concurrently, which is what watchBattery in cmd does while
+// the controller disconnects.
+func TestBatteryLevelClose(t *testing.T) {
+	strength := [2]uint16{usagePageGenericDeviceControls, usageBatteryStrength}
+
+	for i := 0; i < 100; i++ {
+		device := &batteryDevice{
+			MockDevice: NewMockDevice(nil, 0),
+			values:     map[[2]uint16]UsageValue{strength: {Value: 50, LogicalMin: 0, LogicalMax: 100}},
+		}
+		controller := NewStadiaControllerWithDevice(device)
+
+		var wg sync.WaitGroup
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for j := 0; j < 10; j++ {
+				if _, _, err := controller.BatteryLevel(); errors.Is(err, ErrClosed) {
+					t.Error("BatteryLevel read from a closed device")
+					return
+				}
+			}
+		}()
+
+		controller.Close()
+		wg.Wait()
+	}
+}
+
+// slowBatteryDevice is a batteryDevice whose reads block until unblock is
+// closed, like a controller slow to answer over Bluetooth.
+type slowBatteryDevice struct {
+	*batteryDevice
+
+	reading chan struct{}
+	unblock chan struct{}
+}
+
+func (d *slowBatteryDevice) ReadUsageValue(usagePage, usage uint16) (UsageValue, error) {
+	d.reading <- struct{}{}
+	<-d.unblock
+
+	return d.batteryDevice.ReadUsageValue(usagePage, usage)
+}
+
+func TestBatteryLevelDoesNotBlockReports(t *testing.T) {
+	strength := [2]uint16{usagePageGenericDeviceControls, usageBatteryStrength}
+	reports := make([][]byte, 100)
+
+	for i := range reports {
+		reports[i] = []byte{stadiaInputReportID, 8, 0, 0, 0x80, 0x80, 0x80, 0x80, 0, 0}
+	}
+
+	device := &slowBatteryDevice{
+		batteryDevice: &batteryDevice{
+			MockDevice: NewMockDevice(reports, 10*time.Millisecond),
+			values:     map[[2]uint16]UsageValue{strength: {Value: 50, LogicalMin: 0, LogicalMax: 100}},
+		},
+		reading: make(chan struct{}, 2),
+		unblock: make(chan struct{}),
+	}
+	controller := NewStadiaControllerWithDevice(device, WithLogger(nil))
+	defer controller.Close()
+
+	done := make(chan error, 1)
+
+	go func() {
+		_, _, err := controller.BatteryLevel()
+		done <- err
+	}()
+
+	<-device.reading
+
+	// Reports are still parsed and sent while the battery is read.
+	timeout := time.After(5 * time.Second)
+
+	for received := false; !received; {
+		select {
+		case event := <-controller.Events():
+			_, received = event.(ReportEvent)
+
+		case <-timeout:
+			t.Fatal("no report was sent while the battery level was read")
+		}
+	}
+
+	if controller.Paused() {
+		t.Error("controller is paused")
+	}
+
+	close(device.unblock)
+
+	if err := <-done; err != nil {
+		t.Errorf("BatteryLevel() error = %v", err)
+	}
+}
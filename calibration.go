@@ -0,0 +1,318 @@
+package stadiacontroller
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// AxisCalibration maps the raw values of a stick axis so that its resting
+// position is centered and its extents reach the full range of the axis. Its
+// zero value leaves the axis unchanged.
+type AxisCalibration struct {
+	Center byte `json:"center"`
+	Min    byte `json:"min"`
+	Max    byte `json:"max"`
+}
+
+// apply maps the given raw value so that Center becomes 0x80, Min 0x00 and
+// Max 0xFF, clamping values outside of Min and Max.
+func (a AxisCalibration) apply(value byte) byte {
+	if a.Min >= a.Center || a.Center >= a.Max {
+		return value
+	}
+
+	switch {
+	case value <= a.Min:
+		return 0x00
+	case value >= a.Max:
+		return 0xff
+	case value < a.Center:
+		return byte(int(value-a.Min) * 0x80 / int(a.Center-a.Min))
+	default:
+		return byte(0x80 + int(value-a.Center)*0x7f/int(a.Max-a.Center))
+	}
+}
+
+// A Calibration holds the calibration of the sticks of a controller,
+// identified by its serial number.
+type Calibration struct {
+	SerialNumber string `json:"serialNumber"`
+
+	LeftX  AxisCalibration `json:"leftX"`
+	LeftY  AxisCalibration `json:"leftY"`
+	RightX AxisCalibration `json:"rightX"`
+	RightY AxisCalibration `json:"rightY"`
+}
+
+// Apply calibrates the sticks of the given report. It is applied to raw
+// reports, before deadzones and response curves.
+func (c *Calibration) Apply(report *StadiaReport) {
+	if c == nil {
+		return
+	}
+
+	report.LeftX = c.LeftX.apply(report.LeftX)
+	report.LeftY = c.LeftY.apply(report.LeftY)
+	report.RightX = c.RightX.apply(report.RightX)
+	report.RightY = c.RightY.apply(report.RightY)
+}
+
+// ErrCalibrationMismatch is returned by LoadCalibration when the saved
+// calibration belongs to another controller.
+var ErrCalibrationMismatch = errors.New("calibration belongs to another controller")
+
+// CalibrationPath returns the path of the file in which the calibration of
+// the controller with the given serial number is saved, in
+// %APPDATA%\stadiacontroller.
+func CalibrationPath(serialNumber string) (string, error) {
+	dir, err := os.UserConfigDir()
+
+	if err != nil {
+		return "", err
+	}
+
+	name := "default"
+
+	if serialNumber != "" {
+		// Keep the name a valid file name whatever the serial number.
+		name = strings.Map(func(r rune) rune {
+			if strings.ContainsRune(`<>:"/\|?*`, r) || r < 0x20 {
+				return '_'
+			}
+			return r
+		}, serialNumber)
+	}
+
+	return filepath.Join(dir, "stadiacontroller", "calibration-"+name+".json"), nil
+}
+
+// LoadCalibration loads the calibration saved by SaveCalibration for the
+// controller with the given serial number. It returns an error matching
+// os.ErrNotExist if there is none.
+func LoadCalibration(serialNumber string) (*Calibration, error) {
+	path, err := CalibrationPath(serialNumber)
+
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := ioutil.ReadFile(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	calibration := &Calibration{}
+
+	if err := json.Unmarshal(data, calibration); err != nil {
+		return nil, fmt.Errorf("invalid calibration %s: %w", path, err)
+	}
+	if calibration.SerialNumber != serialNumber {
+		return nil, fmt.Errorf("%w: %s is for controller '%s', not '%s'", ErrCalibrationMismatch, path, calibration.SerialNumber, serialNumber)
+	}
+
+	return calibration, nil
+}
+
+// SaveCalibration saves the given calibration, so that it can be loaded by
+// LoadCalibration with its serial number.
+func SaveCalibration(calibration *Calibration) error {
+	path, err := CalibrationPath(calibration.SerialNumber)
+
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(calibration, "", "  ")
+
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+// A Calibrator computes the calibration of a controller from raw reports:
+// first reports with the sticks at rest, which give their centers, and then
+// reports with the sticks moved all around, which give their extents.
+type Calibrator struct {
+	rest  [4]axisSamples
+	moved [4]axisSamples
+}
+
+type axisSamples struct {
+	count    int
+	sum      int
+	min, max byte
+}
+
+func (s *axisSamples) add(value byte) {
+	if s.count == 0 || value < s.min {
+		s.min = value
+	}
+	if s.count == 0 || value > s.max {
+		s.max = value
+	}
+
+	s.count++
+	s.sum += int(value)
+}
+
+func axes(report *StadiaReport) [4]byte {
+	return [4]byte{report.LeftX, report.LeftY, report.RightX, report.RightY}
+}
+
+// AddRest adds a raw report sent while the sticks are at rest.
+func (c *Calibrator) AddRest(report *StadiaReport) {
+	for i, value := range axes(report) {
+		c.rest[i].add(value)
+	}
+}
+
+// AddMoved adds a raw report sent while the sticks are moved around.
+func (c *Calibrator) AddMoved(report *StadiaReport) {
+	for i, value := range axes(report) {
+		c.moved[i].add(value)
+	}
+}
+
+// Calibration returns the calibration computed from the reports added so
+// far. It fails if no report was added at rest, or if the sticks were not
+// moved on both sides of their center.
+func (c *Calibrator) Calibration(serialNumber string) (*Calibration, error) {
+	var calibrations [4]AxisCalibration
+
+	for i := range calibrations {
+		rest, moved := c.rest[i], c.moved[i]
+
+		if rest.count == 0 {
+			return nil, errors.New("no report received with the sticks at rest")
+		}
+
+		center := byte((rest.sum + rest.count/2) / rest.count)
+
+		if moved.count == 0 || moved.min >= center || moved.max <= center {
+			return nil, errors.New("the sticks were not moved all around")
+		}
+
+		calibrations[i] = AxisCalibration{Center: center, Min: moved.min, Max: moved.max}
+	}
+
+	return &Calibration{
+		SerialNumber: serialNumber,
+		LeftX:        calibrations[0],
+		LeftY:        calibrations[1],
+		RightX:       calibrations[2],
+		RightY:       calibrations[3],
+	}, nil
+}
+
+// StickOffsets are the positions at which the stick axes rest, in the range
+// of Xbox 360 axes. They are subtracted from the axes before deadzones, so
+// that sticks resting off-center are centered.
+type StickOffsets struct {
+	LeftX  int16 `json:"leftX"`
+	LeftY  int16 `json:"leftY"`
+	RightX int16 `json:"rightX"`
+	RightY int16 `json:"rightY"`
+}
+
+// add returns the sum of both offsets, clamped to the range of an axis.
+func (o StickOffsets) add(other StickOffsets) StickOffsets {
+	sum := func(a, b int16) int16 { return int16(clampAxisValue(float64(a) + float64(b))) }
+
+	return StickOffsets{
+		LeftX:  sum(o.LeftX, other.LeftX),
+		LeftY:  sum(o.LeftY, other.LeftY),
+		RightX: sum(o.RightX, other.RightX),
+		RightY: sum(o.RightY, other.RightY),
+	}
+}
+
+// subtractOffset subtracts offset from the given axis value, clamping the
+// result to the range of an axis.
+func subtractOffset(value int32, offset int16) int32 {
+	return clampAxisValue(float64(value) - float64(offset))
+}
+
+// CenterOffsets returns the offsets of the sticks computed from the reports
+// added at rest with AddRest. It fails if no report was added.
+func (c *Calibrator) CenterOffsets() (StickOffsets, error) {
+	var offsets [4]int16
+
+	for i, rest := range c.rest {
+		if rest.count == 0 {
+			return StickOffsets{}, errors.New("no report received with the sticks at rest")
+		}
+
+		// Y axes are inverted by ToXbox360Report.
+		center := byte((rest.sum + rest.count/2) / rest.count)
+		scaled := scaleAxisByte(center)
+
+		if i%2 == 1 {
+			scaled = scaleInvertedAxisByte(center)
+		}
+
+		offsets[i] = int16(scaled)
+	}
+
+	return StickOffsets{offsets[0], offsets[1], offsets[2], offsets[3]}, nil
+}
+
+// autoCenter samples the resting position of the sticks of a controller
+// until a deadline, for WithAutoCenter.
+type autoCenter struct {
+	until      time.Time
+	sampling   bool
+	calibrator Calibrator
+	offsets    StickOffsets
+}
+
+// apply samples the given calibrated report if still sampling, and returns
+// a copy of config with the offsets found added to its center offsets.
+func (a *autoCenter) apply(logger *logLimiter, report *StadiaReport, config *ParseConfig) *ParseConfig {
+	if a.sampling {
+		a.calibrator.AddRest(report)
+
+		if time.Now().Before(a.until) {
+			return config
+		}
+
+		a.sampling = false
+		offsets, err := a.calibrator.CenterOffsets()
+
+		if err != nil {
+			return config
+		}
+
+		for _, offset := range []int16{offsets.LeftX, offsets.LeftY, offsets.RightX, offsets.RightY} {
+			if offset > MaxAutoCenterOffset || offset < -MaxAutoCenterOffset {
+				logger.Printf("calibration", "sticks moved while centering them, ignoring offsets %+v", offsets)
+				return config
+			}
+		}
+
+		a.offsets = offsets
+		logger.Printf("calibration", "centered sticks with offsets %+v", offsets)
+	}
+
+	centered := ParseConfig{}
+
+	if config != nil {
+		centered = *config
+	}
+
+	centered.CenterOffset = centered.CenterOffset.add(a.offsets)
+
+	return &centered
+}
@@ -0,0 +1,98 @@
+package stadiacontroller
+
+import (
+	"os"
+	"testing"
+)
+
+// useTempConfigDir makes os.UserConfigDir return a temporary directory until
+// the end of the test, so that calibrations can be saved.
+func useTempConfigDir(t *testing.T) {
+	t.Helper()
+
+	dir := t.TempDir()
+
+	for _, key := range []string{"APPDATA", "XDG_CONFIG_HOME"} {
+		previous, ok := os.LookupEnv(key)
+		os.Setenv(key, dir)
+
+		key := key
+		t.Cleanup(func() {
+			if ok {
+				os.Setenv(key, previous)
+			} else {
+				os.Unsetenv(key)
+			}
+		})
+	}
+}
+
+func TestCenterOffsetsWithSavedCalibration(t *testing.T) {
+	useTempConfigDir(t)
+
+	const serial = "calibrated"
+
+	// The left stick now rests a bit to the right of where it rested when
+	// it was calibrated.
+	calibration := &Calibration{SerialNumber: serial, LeftX: AxisCalibration{Center: 0x88, Min: 0x10, Max: 0xf0}}
+	rest := []byte{stadiaInputReportID, 8, 0, 0, 0x90, 0x80, 0x80, 0x80, 0, 0}
+
+	if err := SaveCalibration(calibration); err != nil {
+		t.Fatal(err)
+	}
+
+	open := func(config *ParseConfig) *StadiaController {
+		c := newStadiaController(newOptions([]Option{WithLogger(nil), WithSavedCalibration()}))
+
+		if config != nil {
+			c.SetConfig(config)
+		}
+
+		c.connect(DeviceInfo{SerialNumber: serial}, NewMockDevice([][]byte{rest, rest, rest}, 0))
+
+		return c
+	}
+
+	// Measure the offsets like the "calibrate center" command.
+	c := open(nil)
+	calibrator := Calibrator{}
+
+	for _, event := range collectEvents(t, c) {
+		if event, ok := event.(ReportEvent); ok {
+			if x, _ := event.Report.GetLeftThumb(); x <= 0 {
+				t.Errorf("without center offsets, the left stick rests at %d, want it to the right", x)
+			}
+
+			calibrator.AddRest(&event.Calibrated)
+		}
+	}
+
+	c.Close()
+
+	offsets, err := calibrator.CenterOffsets()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The offsets are subtracted once the calibration is applied, and
+	// center the sticks exactly.
+	c = open(&ParseConfig{CenterOffset: offsets})
+	defer c.Close()
+
+	reports := 0
+
+	for _, event := range collectEvents(t, c) {
+		if event, ok := event.(ReportEvent); ok {
+			reports++
+
+			if x, y := event.Report.GetLeftThumb(); x != 0 || y != 0 {
+				t.Errorf("with center offsets %+v, the left stick rests at (%d, %d), want (0, 0)", offsets, x, y)
+			}
+		}
+	}
+
+	if reports != 3 {
+		t.Errorf("got %d reports, want 3", reports)
+	}
+}
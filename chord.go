@@ -0,0 +1,351 @@
+package stadiacontroller
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// A ChordAction is what happens when a Chord is pressed.
+type ChordAction string
+
+// Built-in chord actions.
+const (
+	// ChordCommand runs the command given as the argument of the chord.
+	ChordCommand ChordAction = "command"
+
+	// ChordPause toggles whether input is sent to the emulated controller.
+	ChordPause ChordAction = "pause"
+
+	// ChordExit stops the program.
+	ChordExit ChordAction = "exit"
+
+	// ChordProfile switches to the profile given as the argument of the
+	// chord.
+	ChordProfile ChordAction = "profile"
+
+	// ChordRestart reopens the controller and the emulated controller.
+	ChordRestart ChordAction = "restart"
+
+	// ChordNextProfile switches to the profile which follows the active one
+	// in alphabetical order, wrapping around after the last one.
+	ChordNextProfile ChordAction = "next-profile"
+)
+
+// A Chord is a combination of physical buttons pressed together to perform
+// an action instead of being sent to the emulated controller.
+type Chord struct {
+	// Buttons are the physical buttons of the chord, as named in a button
+	// map, e.g. Xbox360ControllerButtonGuide for the Stadia button and
+	// ButtonCapture for the Capture button.
+	Buttons []int
+
+	Action ChordAction
+
+	// Argument is the command of a ChordCommand, or the profile of a
+	// ChordProfile.
+	Argument string
+
+	// Hold is how long the buttons must be held together before the action
+	// is performed, e.g. so that exiting is not done by mistake.
+	Hold time.Duration
+}
+
+// ParseChordButtons parses the physical buttons of a chord separated by '+',
+// e.g. "Stadia+Menu+Options".
+func ParseChordButtons(s string) ([]int, error) {
+	var buttons []int
+
+	for _, name := range strings.Split(s, "+") {
+		button, err := parsePhysicalButton(strings.TrimSpace(name))
+
+		if err != nil {
+			return nil, err
+		}
+
+		buttons = append(buttons, button)
+	}
+
+	if len(buttons) < 2 {
+		return nil, errors.New("a chord needs at least two buttons")
+	}
+
+	return buttons, nil
+}
+
+// DefaultChordWindow is the time within which all the buttons of a chord
+// must be pressed for it to be recognized, unless set in the config file.
+const DefaultChordWindow = 150 * time.Millisecond
+
+// A ChordEngine recognizes chords in successive Stadia reports. Since it
+// remembers when buttons were pressed, a ChordEngine must only be used for a
+// single stream of reports.
+type ChordEngine struct {
+	Chords []Chord
+
+	// Window is the time within which all the buttons of a chord must be
+	// pressed for it to be recognized, so that a button held for a while
+	// does not start a chord when the other buttons are pressed.
+	Window time.Duration
+
+	pressedAt map[int]time.Time
+	active    []bool
+
+	// completedAt is when each active chord was completed, or the zero time
+	// once its action was performed.
+	completedAt []time.Time
+}
+
+// NewChordEngine returns an engine recognizing the given chords within
+// DefaultChordWindow.
+func NewChordEngine(chords []Chord) *ChordEngine {
+	return &ChordEngine{Chords: chords, Window: DefaultChordWindow}
+}
+
+// Apply returns the chords completed by the given report at the given time,
+// and releases in the report the buttons of the chords which are active, so
+// that they do not reach the emulated controller.
+//
+// A chord is active from the moment all its buttons are held until they are
+// all released, so that releasing its buttons one by one does not press the
+// buttons still held. A chord with a Hold is only returned once held for
+// that long, by Apply or Poll, and not at all if released before. However,
+// the buttons of a chord pressed before the last one are not held back, so
+// they reach the emulated controller until the chord is completed.
+func (e *ChordEngine) Apply(report *StadiaReport, now time.Time) []Chord {
+	if e.pressedAt == nil {
+		e.pressedAt = map[int]time.Time{}
+	}
+	if len(e.active) != len(e.Chords) {
+		e.active = make([]bool, len(e.Chords))
+		e.completedAt = make([]time.Time, len(e.Chords))
+	}
+
+	buttons := physicalButtons(report)
+
+	for _, button := range buttons {
+		if *button.pressed {
+			if _, ok := e.pressedAt[button.bit]; !ok {
+				e.pressedAt[button.bit] = now
+			}
+		} else {
+			delete(e.pressedAt, button.bit)
+		}
+	}
+
+	var completed []Chord
+
+	for i, chord := range e.Chords {
+		held, first, last := 0, now, time.Time{}
+
+		for _, button := range chord.Buttons {
+			if pressedAt, ok := e.pressedAt[button]; ok {
+				held++
+
+				if pressedAt.Before(first) {
+					first = pressedAt
+				}
+				if pressedAt.After(last) {
+					last = pressedAt
+				}
+			}
+		}
+
+		switch {
+		case e.active[i] && held < len(chord.Buttons) && !e.completedAt[i].IsZero():
+			// Released before being held long enough.
+			e.completedAt[i] = time.Time{}
+			e.active[i] = held > 0
+		case e.active[i] && held == 0:
+			e.active[i] = false
+		case !e.active[i] && held == len(chord.Buttons) && last.Sub(first) <= e.Window:
+			e.active[i] = true
+			e.completedAt[i] = now
+		}
+	}
+
+	completed = append(completed, e.Poll(now)...)
+
+	for i, chord := range e.Chords {
+		if !e.active[i] {
+			continue
+		}
+
+		for _, button := range buttons {
+			for _, bit := range chord.Buttons {
+				if bit == button.bit {
+					*button.pressed = false
+				}
+			}
+		}
+	}
+
+	return completed
+}
+
+// Poll returns the chords which have been held long enough at the given
+// time. It must be called at the time returned by Deadline, since reports
+// may not be sent while buttons are held.
+func (e *ChordEngine) Poll(now time.Time) []Chord {
+	var completed []Chord
+
+	for i, completedAt := range e.completedAt {
+		if !completedAt.IsZero() && now.Sub(completedAt) >= e.Chords[i].Hold {
+			e.completedAt[i] = time.Time{}
+			completed = append(completed, e.Chords[i])
+		}
+	}
+
+	return completed
+}
+
+// Deadline returns when Poll must be called next for a held chord to be
+// performed in time, if it must.
+func (e *ChordEngine) Deadline() (time.Time, bool) {
+	var deadline time.Time
+
+	for i, completedAt := range e.completedAt {
+		if completedAt.IsZero() {
+			continue
+		}
+		if at := completedAt.Add(e.Chords[i].Hold); deadline.IsZero() || at.Before(deadline) {
+			deadline = at
+		}
+	}
+
+	return deadline, !deadline.IsZero()
+}
+
+// Reset forgets the buttons which are held, e.g. after the controller was
+// reconnected.
+func (e *ChordEngine) Reset() {
+	e.pressedAt = nil
+	e.active = nil
+	e.completedAt = nil
+}
+
+// physicalButton is a button of a StadiaReport, identified by the bit of the
+// Xbox 360 controller button it is reported as by default.
+type physicalButton struct {
+	bit     int
+	pressed *bool
+}
+
+func physicalButtons(r *StadiaReport) []physicalButton {
+	return []physicalButton{
+		{Xbox360ControllerButtonUp, &r.DpadUp},
+		{Xbox360ControllerButtonDown, &r.DpadDown},
+		{Xbox360ControllerButtonLeft, &r.DpadLeft},
+		{Xbox360ControllerButtonRight, &r.DpadRight},
+		{Xbox360ControllerButtonA, &r.A},
+		{Xbox360ControllerButtonB, &r.B},
+		{Xbox360ControllerButtonX, &r.X},
+		{Xbox360ControllerButtonY, &r.Y},
+		{Xbox360ControllerButtonLeftShoulder, &r.L1},
+		{Xbox360ControllerButtonRightShoulder, &r.R1},
+		{Xbox360ControllerButtonLeftThumb, &r.L3},
+		{Xbox360ControllerButtonRightThumb, &r.R3},
+		{Xbox360ControllerButtonStart, &r.Menu},
+		{Xbox360ControllerButtonBack, &r.Options},
+		{Xbox360ControllerButtonGuide, &r.StadiaButton},
+		{ButtonCapture, &r.Capture},
+		{ButtonAssistant, &r.Assistant},
+	}
+}
+
+// ChordConfig is a chord in a JSON config file, e.g.
+//
+//	{"buttons": ["Stadia", "Up"], "action": "command", "command": "notepad"}
+type ChordConfig struct {
+	Buttons ButtonTargets `json:"buttons"`
+
+	// Action is "command", "pause", "exit", "restart", "profile" or
+	// "next-profile".
+	Action  string `json:"action"`
+	Command string `json:"command"`
+	Profile string `json:"profile"`
+
+	// Hold is how long the buttons must be held, in milliseconds.
+	Hold int `json:"hold"`
+}
+
+// chord returns the Chord described by c, checking that its profile exists
+// in the given profiles.
+func (c ChordConfig) chord(profiles map[string]ProfileConfig) (Chord, error) {
+	chord := Chord{Action: ChordAction(strings.ToLower(c.Action)), Hold: time.Duration(c.Hold) * time.Millisecond}
+
+	if c.Hold < 0 {
+		return chord, errors.New("hold: must be positive")
+	}
+
+	if len(c.Buttons) < 2 {
+		return chord, errors.New("buttons: a chord needs at least two buttons")
+	}
+
+	for _, name := range c.Buttons {
+		button, err := parsePhysicalButton(name)
+
+		if err != nil {
+			return chord, fmt.Errorf("buttons: %w", err)
+		}
+
+		chord.Buttons = append(chord.Buttons, button)
+	}
+
+	switch chord.Action {
+	case ChordCommand:
+		if c.Command == "" {
+			return chord, errors.New("command: required by the command action")
+		}
+
+		chord.Argument = c.Command
+
+	case ChordProfile:
+		if _, ok := profiles[c.Profile]; !ok {
+			return chord, fmt.Errorf("profile: unknown profile '%s'", c.Profile)
+		}
+
+		chord.Argument = c.Profile
+
+	case ChordNextProfile:
+		if len(profiles) == 0 {
+			return chord, errors.New("action: next-profile requires profiles")
+		}
+
+	case ChordPause, ChordExit, ChordRestart:
+
+	default:
+		return chord, fmt.Errorf("action: unknown action '%s'", c.Action)
+	}
+
+	return chord, nil
+}
+
+// LoadChords reads the "chords" of a JSON config file, and the time within
+// which their buttons must be pressed, from "chordWindow" in milliseconds.
+func LoadChords(path string) (*ChordEngine, error) {
+	config, err := readValidConfig(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	engine := NewChordEngine(nil)
+
+	for i, chordConfig := range config.Chords {
+		chord, err := chordConfig.chord(config.Profiles)
+
+		if err != nil {
+			return nil, fmt.Errorf("invalid config %s: chords[%d].%w", path, i, err)
+		}
+
+		engine.Chords = append(engine.Chords, chord)
+	}
+
+	if config.ChordWindow != nil {
+		engine.Window = time.Duration(*config.ChordWindow) * time.Millisecond
+	}
+
+	return engine, nil
+}
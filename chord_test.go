@@ -0,0 +1,284 @@
+package stadiacontroller
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// stadiaReportWith returns a report in which the given physical buttons are
+// pressed.
+func stadiaReportWith(buttons ...int) StadiaReport {
+	var report StadiaReport
+
+	for _, button := range physicalButtons(&report) {
+		for _, bit := range buttons {
+			if bit == button.bit {
+				*button.pressed = true
+			}
+		}
+	}
+
+	return report
+}
+
+// pressedButtons returns the physical buttons pressed in the given report.
+func pressedButtons(report *StadiaReport) []int {
+	var pressed []int
+
+	for _, button := range physicalButtons(report) {
+		if *button.pressed {
+			pressed = append(pressed, button.bit)
+		}
+	}
+
+	return pressed
+}
+
+// A chordStep advances the clock, and then applies a report in which the
+// given buttons are pressed, expecting the given actions to be performed and
+// the given buttons to remain pressed.
+type chordStep struct {
+	after       time.Duration
+	pressed     []int
+	wantActions []ChordAction
+	wantPressed []int
+}
+
+func TestChordEngine(t *testing.T) {
+	const (
+		a         = Xbox360ControllerButtonA
+		up        = Xbox360ControllerButtonUp
+		menu      = Xbox360ControllerButtonStart
+		options   = Xbox360ControllerButtonBack
+		stadia    = Xbox360ControllerButtonGuide
+		capture   = ButtonCapture
+		assistant = ButtonAssistant
+	)
+
+	chords := []Chord{
+		{Buttons: []int{capture, assistant}, Action: ChordPause},
+		{Buttons: []int{stadia, up}, Action: ChordCommand, Argument: "notepad"},
+		{Buttons: []int{stadia, menu, options}, Action: ChordExit, Hold: time.Second},
+	}
+
+	for _, test := range []struct {
+		name  string
+		steps []chordStep
+	}{
+		{
+			name: "pressed together",
+			steps: []chordStep{
+				{0, []int{capture, assistant}, []ChordAction{ChordPause}, nil},
+				{time.Second, []int{capture, assistant}, nil, nil},
+				{0, nil, nil, nil},
+			},
+		},
+		{
+			name: "pressed one after the other within the window",
+			steps: []chordStep{
+				{0, []int{stadia}, nil, []int{stadia}},
+				{DefaultChordWindow, []int{stadia, up}, []ChordAction{ChordCommand}, nil},
+			},
+		},
+		{
+			name: "pressed one after the other after the window",
+			steps: []chordStep{
+				{0, []int{capture}, nil, []int{capture}},
+				{DefaultChordWindow + time.Millisecond, []int{capture, assistant}, nil, []int{capture, assistant}},
+			},
+		},
+		{
+			name: "other buttons are not suppressed",
+			steps: []chordStep{
+				{0, []int{a, capture, assistant}, []ChordAction{ChordPause}, []int{a}},
+			},
+		},
+		{
+			name: "released one by one",
+			steps: []chordStep{
+				{0, []int{capture, assistant}, []ChordAction{ChordPause}, nil},
+				{100 * time.Millisecond, []int{assistant}, nil, nil},
+				{100 * time.Millisecond, nil, nil, nil},
+				{100 * time.Millisecond, []int{assistant}, nil, []int{assistant}},
+			},
+		},
+		{
+			name: "pressed again",
+			steps: []chordStep{
+				{0, []int{capture, assistant}, []ChordAction{ChordPause}, nil},
+				{100 * time.Millisecond, nil, nil, nil},
+				{100 * time.Millisecond, []int{capture, assistant}, []ChordAction{ChordPause}, nil},
+			},
+		},
+		{
+			name: "held long enough",
+			steps: []chordStep{
+				{0, []int{stadia, menu, options}, nil, nil},
+				{time.Second - time.Millisecond, []int{stadia, menu, options}, nil, nil},
+				{time.Millisecond, []int{stadia, menu, options}, []ChordAction{ChordExit}, nil},
+				{time.Second, []int{stadia, menu, options}, nil, nil},
+			},
+		},
+		{
+			name: "released before being held long enough",
+			steps: []chordStep{
+				{0, []int{stadia, menu, options}, nil, nil},
+				{500 * time.Millisecond, []int{stadia, menu}, nil, nil},
+				{time.Second, []int{stadia, menu}, nil, nil},
+				{0, nil, nil, nil},
+			},
+		},
+	} {
+		clock := &fakeClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+		engine := NewChordEngine(chords)
+
+		for i, step := range test.steps {
+			clock.Advance(step.after)
+
+			report := stadiaReportWith(step.pressed...)
+
+			var actions []ChordAction
+
+			for _, chord := range engine.Apply(&report, clock.Now()) {
+				actions = append(actions, chord.Action)
+			}
+
+			if !reflect.DeepEqual(actions, step.wantActions) {
+				t.Errorf("%s: step %d: performed %v, want %v", test.name, i, actions, step.wantActions)
+			}
+			if got, want := pressedButtons(&report), stadiaReportWith(step.wantPressed...); !reflect.DeepEqual(got, pressedButtons(&want)) {
+				t.Errorf("%s: step %d: pressed buttons are %v, want %v", test.name, i, got, pressedButtons(&want))
+			}
+		}
+	}
+}
+
+func TestChordEnginePoll(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+	engine := NewChordEngine([]Chord{
+		{Buttons: []int{Xbox360ControllerButtonA, Xbox360ControllerButtonB}, Action: ChordExit, Hold: time.Second},
+		{Buttons: []int{Xbox360ControllerButtonX, Xbox360ControllerButtonY}, Action: ChordRestart, Hold: 2 * time.Second},
+	})
+
+	if _, ok := engine.Deadline(); ok {
+		t.Error("idle engine has a deadline")
+	}
+
+	report := stadiaReportWith(Xbox360ControllerButtonA, Xbox360ControllerButtonB, Xbox360ControllerButtonX, Xbox360ControllerButtonY)
+	engine.Apply(&report, clock.Now())
+
+	// Reports may not be sent while the buttons are held, so chords are
+	// performed by Poll at the earliest deadline.
+	deadline, ok := engine.Deadline()
+
+	if !ok || !deadline.Equal(clock.Now().Add(time.Second)) {
+		t.Errorf("deadline = %v, %v, want in a second", deadline, ok)
+	}
+	if chords := engine.Poll(deadline.Add(-time.Millisecond)); len(chords) != 0 {
+		t.Errorf("Poll before the deadline returned %v", chords)
+	}
+	if chords := engine.Poll(deadline); len(chords) != 1 || chords[0].Action != ChordExit {
+		t.Errorf("Poll at the deadline returned %v, want the exit chord", chords)
+	}
+
+	deadline, ok = engine.Deadline()
+
+	if !ok || !deadline.Equal(clock.Now().Add(2*time.Second)) {
+		t.Errorf("deadline = %v, %v, want in two seconds", deadline, ok)
+	}
+	if chords := engine.Poll(deadline); len(chords) != 1 || chords[0].Action != ChordRestart {
+		t.Errorf("Poll at the deadline returned %v, want the restart chord", chords)
+	}
+	if _, ok := engine.Deadline(); ok {
+		t.Error("engine has a deadline once all chords were performed")
+	}
+
+	// After a reset, e.g. on reconnection, held buttons are forgotten.
+	engine.Reset()
+	clock.Advance(10 * time.Second)
+
+	report = stadiaReportWith(Xbox360ControllerButtonA, Xbox360ControllerButtonB)
+	engine.Apply(&report, clock.Now())
+
+	if deadline, ok := engine.Deadline(); !ok || !deadline.Equal(clock.Now().Add(time.Second)) {
+		t.Errorf("after Reset, deadline = %v, %v, want in a second", deadline, ok)
+	}
+}
+
+func TestParseChordButtons(t *testing.T) {
+	for _, test := range []struct {
+		s       string
+		want    []int
+		wantErr bool
+	}{
+		{"Stadia+Menu+Options", []int{Xbox360ControllerButtonGuide, Xbox360ControllerButtonStart, Xbox360ControllerButtonBack}, false},
+		{"capture + assistant", []int{ButtonCapture, ButtonAssistant}, false},
+		{"A+Up", []int{Xbox360ControllerButtonA, Xbox360ControllerButtonUp}, false},
+		{"Stadia", nil, true},
+		{"Stadia+Turbo", nil, true},
+		{"", nil, true},
+	} {
+		buttons, err := ParseChordButtons(test.s)
+
+		if (err != nil) != test.wantErr {
+			t.Errorf("ParseChordButtons(%q) returned error %v, want error %v", test.s, err, test.wantErr)
+		} else if !reflect.DeepEqual(buttons, test.want) {
+			t.Errorf("ParseChordButtons(%q) = %v, want %v", test.s, buttons, test.want)
+		}
+	}
+}
+
+func TestChordConfig(t *testing.T) {
+	profiles := map[string]ProfileConfig{"racing": {}}
+
+	for _, test := range []struct {
+		name    string
+		config  ChordConfig
+		want    Chord
+		wantErr bool
+	}{
+		{
+			"command",
+			ChordConfig{Buttons: ButtonTargets{"Stadia", "Up"}, Action: "Command", Command: "notepad", Hold: 500},
+			Chord{Buttons: []int{Xbox360ControllerButtonGuide, Xbox360ControllerButtonUp}, Action: ChordCommand, Argument: "notepad", Hold: 500 * time.Millisecond},
+			false,
+		},
+		{
+			"profile",
+			ChordConfig{Buttons: ButtonTargets{"Stadia", "A"}, Action: "profile", Profile: "racing"},
+			Chord{Buttons: []int{Xbox360ControllerButtonGuide, Xbox360ControllerButtonA}, Action: ChordProfile, Argument: "racing"},
+			false,
+		},
+		{"single button", ChordConfig{Buttons: ButtonTargets{"Stadia"}, Action: "pause"}, Chord{}, true},
+		{"unknown button", ChordConfig{Buttons: ButtonTargets{"Stadia", "Turbo"}, Action: "pause"}, Chord{}, true},
+		{"missing command", ChordConfig{Buttons: ButtonTargets{"Stadia", "Up"}, Action: "command"}, Chord{}, true},
+		{"unknown profile", ChordConfig{Buttons: ButtonTargets{"Stadia", "A"}, Action: "profile", Profile: "drifting"}, Chord{}, true},
+		{"unknown action", ChordConfig{Buttons: ButtonTargets{"Stadia", "A"}, Action: "dance"}, Chord{}, true},
+		{"negative hold", ChordConfig{Buttons: ButtonTargets{"Stadia", "A"}, Action: "exit", Hold: -1}, Chord{}, true},
+	} {
+		chord, err := test.config.chord(profiles)
+
+		if (err != nil) != test.wantErr {
+			t.Errorf("%s: chord() returned error %v, want error %v", test.name, err, test.wantErr)
+		} else if !test.wantErr && !reflect.DeepEqual(chord, test.want) {
+			t.Errorf("%s: chord() = %+v, want %+v", test.name, chord, test.want)
+		}
+	}
+}
+
+func TestChordsWithoutHandler(t *testing.T) {
+	engine := NewChordEngine([]Chord{{Buttons: []int{ButtonCapture, ButtonAssistant}, Action: ChordPause}})
+	c := newStadiaController(newOptions([]Option{WithLogger(nil), WithChords(engine, nil)}))
+	defer c.Close()
+
+	// Capture and Assistant pressed together.
+	pause := centeredReport(func(payload []byte) { payload[1] = 0b0000_0011 })
+
+	c.connect(DeviceInfo{}, NewMockDevice([][]byte{pause}, 0))
+	collectEvents(t, c)
+
+	if !c.Paused() {
+		t.Error("pause chord did not pause the controller")
+	}
+}
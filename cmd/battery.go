@@ -0,0 +1,51 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	"github.com/71/stadiacontroller"
+)
+
+// batteryPollInterval is how often the battery level is checked.
+const batteryPollInterval = 1 * time.Minute
+
+// watchBattery warns once each time the battery level of the controller
+// drops under -battery-warn, until stopped is closed. Controllers which do not
+// report their battery level are silently ignored.
+func watchBattery(controller *stadiacontroller.StadiaController, stopped <-chan struct{}) {
+	ticker := time.NewTicker(batteryPollInterval)
+	defer ticker.Stop()
+
+	warned := false
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-stopped:
+			return
+		}
+
+		percent, charging, err := controller.BatteryLevel()
+
+		if err != nil {
+			continue
+		}
+		if charging || percent >= *batteryWarn {
+			warned = false
+			continue
+		}
+		if warned {
+			continue
+		}
+
+		warned = true
+		log.Printf("controller battery is low: %d%%", percent)
+
+		if *onBatteryLow != "" {
+			if err := runCommand(*onBatteryLow); err != nil {
+				log.Printf("cannot run -battery-low command: %v", err)
+			}
+		}
+	}
+}
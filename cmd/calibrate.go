@@ -0,0 +1,181 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/71/stadiacontroller"
+)
+
+// Durations of the steps of the calibration.
+const (
+	calibrationRestDuration   = 2 * time.Second
+	calibrationMoveDuration   = 5 * time.Second
+	calibrationStartDuration  = 30 * time.Second
+	calibrationCenterDuration = 1 * time.Second
+)
+
+// runCalibrate runs the calibrate command with the given arguments:
+// "calibrate" saves the calibration of the controller, and "calibrate center"
+// prints the centerOffset to paste into the -config file instead.
+func runCalibrate(args []string) error {
+	switch {
+	case len(args) == 0:
+		return calibrate()
+	case len(args) == 1 && args[0] == "center":
+		return printCenterOffsets()
+	default:
+		return configError(fmt.Errorf("unknown calibrate command '%s', expected 'calibrate' or 'calibrate center'", strings.Join(args, " ")))
+	}
+}
+
+// calibrate computes the calibration of the sticks of the controller and
+// saves it for its serial number, so that it is used whenever the controller
+// is opened afterwards.
+func calibrate() error {
+	// The new calibration replaces the saved one, so it is computed from raw
+	// reports.
+	controller, info, err := openForCalibration(false)
+
+	if err != nil {
+		return err
+	}
+
+	defer controller.Close()
+
+	events := controller.Events()
+	calibrator := stadiacontroller.Calibrator{}
+
+	log.Printf("leave both sticks at rest")
+
+	if err := sampleReports(events, calibrationRestDuration, calibrator.AddRest); err != nil {
+		return err
+	}
+
+	log.Printf("now move both sticks all around, until they reach their edges")
+
+	if err := sampleReports(events, calibrationMoveDuration, calibrator.AddMoved); err != nil {
+		return err
+	}
+
+	calibration, err := calibrator.Calibration(info.SerialNumber)
+
+	if err != nil {
+		return fmt.Errorf("calibration failed: %w", err)
+	}
+	if err := stadiacontroller.SaveCalibration(calibration); err != nil {
+		return fmt.Errorf("unable to save calibration: %w", err)
+	}
+
+	path, _ := stadiacontroller.CalibrationPath(info.SerialNumber)
+	log.Printf("saved calibration to %s", path)
+
+	return nil
+}
+
+// printCenterOffsets samples the resting position of the sticks, and prints
+// the offsets to paste into the -config file to center them.
+func printCenterOffsets() error {
+	// Center offsets are subtracted from calibrated reports, so they are
+	// computed from reports calibrated like when running.
+	controller, _, err := openForCalibration(true)
+
+	if err != nil {
+		return err
+	}
+
+	defer controller.Close()
+
+	calibrator := stadiacontroller.Calibrator{}
+
+	log.Printf("leave both sticks at rest")
+
+	if err := sampleReports(controller.Events(), calibrationCenterDuration, calibrator.AddRest); err != nil {
+		return err
+	}
+
+	offsets, err := calibrator.CenterOffsets()
+
+	if err != nil {
+		return fmt.Errorf("calibration failed: %w", err)
+	}
+
+	data, err := json.Marshal(struct {
+		CenterOffset stadiacontroller.StickOffsets `json:"centerOffset"`
+	}{offsets})
+
+	if err != nil {
+		return err
+	}
+
+	log.Printf("add the following to the -config file to center the sticks:")
+	fmt.Println(string(data))
+
+	return nil
+}
+
+// openForCalibration opens the controller selected by the flags, waiting
+// for it to be connected. If savedCalibration is true, its reports are
+// calibrated with its saved calibration, if any.
+func openForCalibration(savedCalibration bool) (*stadiacontroller.StadiaController, stadiacontroller.DeviceInfo, error) {
+	options, err := deviceOptions()
+
+	if err != nil {
+		return nil, stadiacontroller.DeviceInfo{}, err
+	}
+	if savedCalibration {
+		options = append(options, stadiacontroller.WithSavedCalibration())
+	}
+
+	controller := stadiacontroller.NewStadiaController(options...)
+	events := controller.Events()
+
+	log.Printf("waiting for controller")
+
+	timeout := time.After(calibrationStartDuration)
+
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return nil, stadiacontroller.DeviceInfo{}, stadiacontroller.ErrClosed
+			}
+			if event, ok := event.(stadiacontroller.ConnectedEvent); ok {
+				return controller, event.Info, nil
+			}
+
+		case <-timeout:
+			controller.Close()
+			return nil, stadiacontroller.DeviceInfo{}, errors.New("no controller found")
+		}
+	}
+}
+
+// sampleReports gives the calibrated reports received during the given
+// duration to add, failing if the controller is lost in the meantime.
+func sampleReports(events <-chan stadiacontroller.Event, duration time.Duration, add func(report *stadiacontroller.StadiaReport)) error {
+	timeout := time.After(duration)
+
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return stadiacontroller.ErrClosed
+			}
+
+			switch event := event.(type) {
+			case stadiacontroller.ReportEvent:
+				add(&event.Calibrated)
+			case stadiacontroller.DisconnectedEvent:
+				return fmt.Errorf("lost controller during calibration: %w", event.Err)
+			}
+
+		case <-timeout:
+			return nil
+		}
+	}
+}
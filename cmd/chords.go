@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/71/stadiacontroller"
+)
+
+// chordOptions returns the options recognizing -exit-chord, -restart-chord
+// and the chords of the -config file, which are sent on the returned channel
+// once pressed.
+func chordOptions() ([]stadiacontroller.Option, <-chan stadiacontroller.Chord, error) {
+	engine := stadiacontroller.NewChordEngine(nil)
+
+	if *configPath != "" {
+		var err error
+
+		if engine, err = stadiacontroller.LoadChords(*configPath); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	for _, flagChord := range []struct {
+		name    string
+		buttons string
+		action  stadiacontroller.ChordAction
+	}{
+		{"exit-chord", *exitChord, stadiacontroller.ChordExit},
+		{"restart-chord", *restartChord, stadiacontroller.ChordRestart},
+	} {
+		if flagChord.buttons == "" {
+			continue
+		}
+
+		buttons, err := stadiacontroller.ParseChordButtons(flagChord.buttons)
+
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid -%s: %w", flagChord.name, err)
+		}
+
+		engine.Chords = append(engine.Chords, stadiacontroller.Chord{Buttons: buttons, Action: flagChord.action, Hold: *chordHold})
+	}
+
+	if len(engine.Chords) == 0 {
+		return nil, nil, nil
+	}
+
+	chords := make(chan stadiacontroller.Chord, 8)
+
+	onChord := func(chord stadiacontroller.Chord) {
+		select {
+		case chords <- chord:
+		default:
+			log.Printf("ignoring chord pressed too quickly")
+		}
+	}
+
+	return []stadiacontroller.Option{stadiacontroller.WithChords(engine, onChord)}, chords, nil
+}
+
+// handleChords performs the actions of the chords received on the given
+// channel until stopped is closed. ChordPause chords are handled by the
+// controller itself.
+//
+// For ChordRestart chords, restart is signaled before the controller is
+// reopened, so that the emulated controller is rebuilt once the controller
+// is reported as disconnected. Profiles are switched by handing their
+// pipeline to the main loop.
+func handleChords(controller *stadiacontroller.StadiaController, chords <-chan stadiacontroller.Chord, stopped <-chan struct{}, stop func(), restart chan<- struct{}, pipelines chan *pipeline) {
+	for {
+		var chord stadiacontroller.Chord
+
+		select {
+		case chord = <-chords:
+		case <-stopped:
+			return
+		}
+
+		switch chord.Action {
+		case stadiacontroller.ChordExit:
+			log.Printf("exit chord pressed")
+			stop()
+			return
+
+		case stadiacontroller.ChordPause:
+			logPaused(controller.Paused())
+
+		case stadiacontroller.ChordRestart:
+			log.Printf("restart chord pressed")
+
+			select {
+			case restart <- struct{}{}:
+			default:
+			}
+
+			controller.Reopen()
+
+		default:
+			if err := runChord(chord, pipelines); err != nil {
+				log.Printf("cannot perform chord action: %v", err)
+			}
+		}
+	}
+}
+
+// registerPauseHotkey registers -pause-hotkey to pause and resume the
+// controller.
+func registerPauseHotkey(controller *stadiacontroller.StadiaController) (*stadiacontroller.Hotkey, error) {
+	chord, err := stadiacontroller.ParseKeyChord(*pauseHotkey)
+
+	if err != nil {
+		return nil, err
+	}
+
+	hotkey, err := stadiacontroller.RegisterHotkey(chord, func() {
+		logPaused(controller.TogglePaused())
+	})
+
+	if err != nil {
+		return nil, fmt.Errorf("cannot register -pause-hotkey %s: %w", *pauseHotkey, err)
+	}
+
+	return hotkey, nil
+}
+
+func logPaused(paused bool) {
+	if paused {
+		log.Printf("paused, input is not sent until resumed")
+	} else {
+		log.Printf("resumed")
+	}
+}
+
+// runChord performs the action of a ChordCommand, ChordProfile or
+// ChordNextProfile chord.
+func runChord(chord stadiacontroller.Chord, pipelines chan *pipeline) error {
+	switch chord.Action {
+	case stadiacontroller.ChordCommand:
+		return runCommand(chord.Argument)
+
+	case stadiacontroller.ChordProfile, stadiacontroller.ChordNextProfile:
+		configMu.Lock()
+		defer configMu.Unlock()
+
+		name := chord.Argument
+
+		if chord.Action == stadiacontroller.ChordNextProfile {
+			var err error
+
+			if name, err = nextProfile(); err != nil {
+				return fmt.Errorf("cannot switch profiles: %w", err)
+			}
+		}
+
+		if err := switchProfile(pipelines, name); err != nil {
+			return fmt.Errorf("cannot switch to profile %s: %w", name, err)
+		}
+
+		return nil
+
+	default:
+		return fmt.Errorf("unexpected chord action '%s'", chord.Action)
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+// Exit codes of the program, so that supervisors and scripts can tell why
+// it stopped. Other errors exit with 1.
+const (
+	exitConfig         = 2
+	exitVigem          = 3
+	exitDevice         = 4
+	exitAlreadyRunning = 5
+)
+
+// An exitError is an error which makes the program exit with a specific
+// code.
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string {
+	return e.err.Error()
+}
+
+func (e *exitError) Unwrap() error {
+	return e.err
+}
+
+func withExitCode(code int, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	return &exitError{code, err}
+}
+
+// configError marks an invalid flag or config file.
+func configError(err error) error {
+	return withExitCode(exitConfig, err)
+}
+
+// vigemError marks a failure to set up or use the emulated controller.
+func vigemError(err error) error {
+	return withExitCode(exitVigem, err)
+}
+
+// deviceError marks an unrecoverable failure to read from the controller.
+func deviceError(err error) error {
+	return withExitCode(exitDevice, err)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"time"
+
+	"github.com/71/stadiacontroller"
+)
+
+// buttonGestures runs the -*-short-pressed, -*-long-pressed and
+// -*-double-pressed commands of a button.
+type buttonGestures struct {
+	button                    string
+	detector                  stadiacontroller.GestureDetector
+	onShort, onLong, onDouble string
+}
+
+// newButtonGestures returns the gestures of the given button. Long and
+// double presses are only detected if they have a command, so that short
+// presses are not delayed for nothing.
+func newButtonGestures(button, onShort, onLong, onDouble string) *buttonGestures {
+	g := &buttonGestures{button: button, onShort: onShort, onLong: onLong, onDouble: onDouble}
+
+	if onLong != "" {
+		g.detector.LongPress = *longPressDelay
+	}
+	if onDouble != "" {
+		g.detector.DoublePress = *doublePressTimeout
+	}
+
+	return g
+}
+
+// update runs the command of the gesture recognized given whether the button
+// is pressed at the given time, if any.
+func (g *buttonGestures) update(pressed bool, buttons uint16, now time.Time) error {
+	var command string
+
+	gesture := g.detector.Update(pressed, now)
+
+	switch gesture {
+	case stadiacontroller.ShortPress:
+		command = g.onShort
+	case stadiacontroller.LongPress:
+		command = g.onLong
+	case stadiacontroller.DoublePress:
+		command = g.onDouble
+	}
+
+	if command == "" {
+		return nil
+	}
+
+	return runCommand(command, buttonEnv(g.button, gesture.String(), buttons)...)
+}
+
+// wakeUpIn returns how soon update must be called for a gesture to be
+// recognized in time, if it must.
+func (g *buttonGestures) wakeUpIn(now time.Time) (time.Duration, bool) {
+	deadline, ok := g.detector.Deadline()
+
+	if !ok {
+		return 0, false
+	}
+	if deadline.Before(now) {
+		return 0, true
+	}
+
+	return deadline.Sub(now), true
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/71/stadiacontroller"
+)
+
+// hiddenDevices keeps track of the devices hidden with HidHide, so that they
+// can be revealed again on shutdown.
+type hiddenDevices struct {
+	mu     sync.Mutex
+	unhide map[string]func() error
+}
+
+// hide hides the device with the given path with HidHide, logging a warning
+// if it cannot be hidden.
+func (d *hiddenDevices) hide(path string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	key := strings.ToLower(path)
+
+	if _, ok := d.unhide[key]; ok {
+		return
+	}
+
+	unhide, err := stadiacontroller.HideDevice(path)
+
+	if errors.Is(err, stadiacontroller.ErrHidHideNotInstalled) {
+		log.Printf("warning: cannot hide device, HidHide is not installed")
+		return
+	}
+	if err != nil {
+		log.Printf("warning: cannot hide device: %v", err)
+		return
+	}
+
+	if d.unhide == nil {
+		d.unhide = map[string]func() error{}
+	}
+
+	d.unhide[key] = unhide
+}
+
+// revealAll reveals all the devices hidden by hide.
+func (d *hiddenDevices) revealAll() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for key, unhide := range d.unhide {
+		if err := unhide(); err != nil {
+			log.Printf("warning: cannot reveal device hidden with HidHide: %v", err)
+		}
+
+		delete(d.unhide, key)
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"errors"
+
+	"golang.org/x/sys/windows"
+)
+
+// instanceMutexName is the name of the mutex held by the running instance.
+const instanceMutexName = `Local\stadiacontroller`
+
+var errAlreadyRunning = errors.New("stadiacontroller is already running")
+
+// acquireInstanceMutex creates the mutex which marks the running instance,
+// returning errAlreadyRunning if another instance holds it. The returned
+// function releases it; the mutex is also released by Windows when the
+// process exits.
+func acquireInstanceMutex() (release func(), err error) {
+	name, err := windows.UTF16PtrFromString(instanceMutexName)
+
+	if err != nil {
+		return nil, err
+	}
+
+	handle, err := windows.CreateMutex(nil, false, name)
+
+	if errors.Is(err, windows.ERROR_ALREADY_EXISTS) {
+		windows.CloseHandle(handle)
+		return nil, errAlreadyRunning
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return func() { windows.CloseHandle(handle) }, nil
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/71/stadiacontroller"
+)
+
+// listDevices prints all HID devices, marking the ones recognized as Stadia
+// controllers with a star.
+func listDevices() error {
+	ids, err := deviceIDs()
+
+	if err != nil {
+		return err
+	}
+
+	devices, err := stadiacontroller.Devices()
+
+	if err != nil {
+		return fmt.Errorf("unable to enumerate devices: %w", err)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+
+	fmt.Fprintln(w, "\tVID\tPID\tMANUFACTURER\tPRODUCT\tSERIAL\tUSAGE PAGE\tUSAGE\tINPUT\tOUTPUT\tFEATURE\tPATH")
+
+	for _, device := range devices {
+		marker := ""
+
+		for _, id := range ids {
+			if device.VendorID == id.Vendor && device.ProductID == id.Product {
+				marker = "*"
+			}
+		}
+
+		fmt.Fprintf(w, "%s\t%04x\t%04x\t%s\t%s\t%s\t%04x\t%04x\t%d\t%d\t%d\t%s\n",
+			marker, device.VendorID, device.ProductID, device.Manufacturer, device.Product, device.SerialNumber, device.UsagePage, device.Usage,
+			device.InputReportLength, device.OutputReportLength, device.FeatureReportLength, device.Path)
+	}
+
+	return w.Flush()
+}
@@ -1,85 +1,499 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"flag"
 	"fmt"
 	"log"
+	"os"
 	"os/exec"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/71/stadiacontroller"
 )
 
 var (
-	shell = flag.String("shell", "pwsh", "a path to the shell to execute for commands")
+	shell         = flag.String("shell", "pwsh", "a path to the shell to execute for commands")
+	cmdTimeout    = flag.Duration("command-timeout", 0, "the duration after which a command is killed, e.g. 30s, or 0 to let commands run forever")
+	syncCommands  = flag.Bool("sync-commands", false, "wait for each command to exit before handling further input, so that commands run in order")
+	mode          = flag.String("mode", "x360", "the type of controller to emulate (x360 or ds4), or kbm to send keyboard and mouse input instead")
+	allowMultiple = flag.Bool("allow-multiple", false, "allow running several instances at once, e.g. one per controller")
+	vigemDLL      = flag.String("vigem-dll", "", "a path to the ViGEmClient.dll to use instead of the embedded or installed one")
+	noEmulator    = flag.Bool("no-emulator", false, "log the reports of the controller instead of sending them to an emulated controller, e.g. to check that presses are seen without ViGEm")
+	vigemRetries  = flag.Int("vigem-retries", 5, "the number of attempts made to reconnect the emulated controller if it is lost")
+	poll          = flag.Duration("poll", stadiacontroller.DefaultPollInterval, "the interval at which devices are looked for when device notifications are unavailable, and between retries")
+	vendorID      = flag.Uint("vid", 0x18d1, "the USB vendor ID of the controller given by -pid, e.g. 0x18d1")
+	productID     = flag.Uint("pid", 0, "the USB product ID of the controller, if it is not recognized, e.g. 0x9400")
+	bluetoothFW   = flag.String("bluetooth-firmware", "", "the first firmware version with the Bluetooth update, e.g. 1.05; controllers with an older firmware are logged as needing the update")
+	hideDevice    = flag.Bool("hide-device", false, "hide the Stadia controller from other programs with HidHide, which must be installed")
+	devicePath    = flag.String("device-path", "", "the path of the device to open, instead of the first Stadia controller found (see -list)")
+	serial        = flag.String("serial", "", "the serial number of the controller to use, e.g. when several are connected (see -list)")
+	resetOnLoss   = flag.Bool("reset-on-disconnect", true, "release all buttons and center the sticks of the emulated controller when the controller is lost")
+	autoCenter    = flag.Bool("auto-center", false, "sample the resting position of the sticks for a second whenever the controller is connected, and center them")
+	list          = flag.Bool("list", false, "list the HID devices seen by Windows and exit, to check whether the controller is detected")
+	record        = flag.String("record", "", "a path to a file to which raw controller reports are appended, for debugging")
+	batteryWarn   = flag.Int("battery-warn", 15, "the battery percentage under which a warning is logged and -battery-low is run, or 0 to disable it")
+	onBatteryLow  = flag.String("battery-low", "", "a command to run when the battery level drops under -battery-warn")
+
+	profileName   = flag.String("profile", "", "the name of a profile of the -config file whose settings override the flags")
+	configPath    = flag.String("config", "", "a path to a JSON config file, e.g. {\"buttons\": {\"L3\": [\"Back\", \"LeftThumb\"]}}")
+	remap         = flag.String("remap", "", "a path to a JSON file that remaps buttons, e.g. {\"A\": \"B\", \"B\": \"A\"}")
+	turbo         = flag.String("turbo", "", "buttons toggled rapidly while held, with their frequency in Hz (15 by default), e.g. A:15,B:10,RT")
+	leftDeadzone  = flag.Float64("left-deadzone", 0, "the radial deadzone of the left stick, between 0 and 1")
+	rightDeadzone = flag.Float64("right-deadzone", 0, "the radial deadzone of the right stick, between 0 and 1")
+	leftAntiDz    = flag.Float64("left-anti-deadzone", 0, "the smallest distance from the center reported for the left stick when it is moved, between 0 and 1, to counter the deadzone of games")
+	rightAntiDz   = flag.Float64("right-anti-deadzone", 0, "the smallest distance from the center reported for the right stick when it is moved, between 0 and 1")
+	leftCurve     = flag.String("left-stick-curve", "linear", "the response curve of the left stick: linear, squared, cubed or an exponent such as 1.5")
+	rightCurve    = flag.String("right-stick-curve", "linear", "the response curve of the right stick: linear, squared, cubed or an exponent such as 1.5")
+	lTriggerMin   = flag.Uint("ltrigger-min", 0, "the raw value of the left trigger reported as released, between 0 and 255")
+	lTriggerMax   = flag.Uint("ltrigger-max", 255, "the raw value of the left trigger reported as fully pressed, between 0 and 255")
+	rTriggerMin   = flag.Uint("rtrigger-min", 0, "the raw value of the right trigger reported as released, between 0 and 255")
+	rTriggerMax   = flag.Uint("rtrigger-max", 255, "the raw value of the right trigger reported as fully pressed, between 0 and 255")
+	lTriggerPress = flag.Uint("ltrigger-digital", 0, "if not 0, the value from which the left trigger is reported as fully pressed, and released below it")
+	rTriggerPress = flag.Uint("rtrigger-digital", 0, "if not 0, the value from which the right trigger is reported as fully pressed, and released below it")
+	smoothing     = flag.Float64("stick-smoothing", 0, "how much the sticks are smoothed to hide their noise, between 0 (disabled) and 1, e.g. 0.5")
+	leftCircle    = flag.Bool("left-stick-circle", false, "limit the left stick to a circle, so that it is not faster on diagonals")
+	rightCircle   = flag.Bool("right-stick-circle", false, "limit the right stick to a circle, so that it is not faster on diagonals")
+	legacyAxes    = flag.Bool("legacy-axis-scaling", false, "scale the stick axes like older versions did")
+	digitalTrig   = flag.Uint("digital-triggers", 0, "if not 0, the value from which both triggers are reported as fully pressed, and released below it")
+	invertLX      = flag.Bool("invert-lx", false, "invert the X axis of the left stick")
+	invertLY      = flag.Bool("invert-ly", false, "invert the Y axis of the left stick")
+	invertRX      = flag.Bool("invert-rx", false, "invert the X axis of the right stick")
+	invertRY      = flag.Bool("invert-ry", false, "invert the Y axis of the right stick")
+	rumbleScale   = flag.Float64("rumble-scale", 1, "the factor between 0 and 1 by which vibrations are scaled, e.g. 0.5 to halve them or 0 to disable them")
+	rumbleScaleL  = flag.Float64("rumble-scale-large", 1, "the factor between 0 and 2 by which the large motor is scaled")
+	rumbleScaleS  = flag.Float64("rumble-scale-small", 1, "the factor between 0 and 2 by which the small motor is scaled")
+	swapRumble    = flag.Bool("swap-rumble-motors", false, "swap the large and small vibration motors")
+	rumbleOff     = flag.Bool("rumble-off", false, "disable vibrations")
+	swapSticks    = flag.Bool("swap-sticks", false, "swap the left and right sticks")
+
+	kbmKeys        = flag.String("kbm-keys", "", "in -mode kbm, the keys pressed by each button instead of the defaults, e.g. A:space,B:escape")
+	kbmSensitivity = flag.Float64("kbm-sensitivity", stadiacontroller.DefaultKeyboardMouseSensitivity, "in -mode kbm, the distance in pixels the cursor moves per report while the right stick is fully deflected")
+
+	exitChord    = flag.String("exit-chord", "Stadia+Menu+Options", "the buttons held for -chord-hold to exit, or an empty string to disable it")
+	restartChord = flag.String("restart-chord", "", "the buttons held for -chord-hold to reopen the controller and the emulated controller, e.g. Stadia+Options+Y")
+	chordHold    = flag.Duration("chord-hold", 2*time.Second, "how long -exit-chord and -restart-chord must be held")
+	pauseHotkey  = flag.String("pause-hotkey", "", "a key chord which pauses or resumes sending input to the emulated controller from any program, e.g. ctrl+alt+p")
+	captureKey   = flag.String("capture-key", "", "a key chord held while the Capture button is held, e.g. win+alt+printscreen")
+	assistantKey = flag.String("assistant-key", "", "a key chord held while the Assistant button is held")
 
 	onCapturePressed    = flag.String("capture-pressed", "", "a command to run when the Capture button is pressed")
 	onCaptureReleased   = flag.String("capture-released", "", "a command to run when the Capture button is released")
 	onAssistantPressed  = flag.String("assistant-pressed", "", "a command to run when the Assistant button is pressed")
 	onAssistantReleased = flag.String("assistant-released", "", "a command to run when the Assistant button is released")
+
+	onCaptureShort     = flag.String("capture-short-pressed", "", "a command to run when the Capture button is pressed briefly, once it is neither a long nor a double press")
+	onCaptureLong      = flag.String("capture-long-pressed", "", "a command to run when the Capture button is held for -long-press")
+	onCaptureDouble    = flag.String("capture-double-pressed", "", "a command to run when the Capture button is pressed twice within -double-press")
+	onAssistantShort   = flag.String("assistant-short-pressed", "", "a command to run when the Assistant button is pressed briefly, once it is neither a long nor a double press")
+	onAssistantLong    = flag.String("assistant-long-pressed", "", "a command to run when the Assistant button is held for -long-press")
+	onAssistantDouble  = flag.String("assistant-double-pressed", "", "a command to run when the Assistant button is pressed twice within -double-press")
+	longPressDelay     = flag.Duration("long-press", stadiacontroller.DefaultLongPress, "how long a button must be held for a long press")
+	doublePressTimeout = flag.Duration("double-press", stadiacontroller.DefaultDoublePress, "how soon a button must be pressed again for a double press, which delays short presses by as much")
 )
 
+// turboResendInterval is how often the last report is sent again while a
+// turbo button is held.
+const turboResendInterval = 10 * time.Millisecond
+
+// configPollInterval is how often the -config file is checked for changes.
+const configPollInterval = 1 * time.Second
+
+// configMu serializes the calls to loadPipeline made once running, since
+// they may change -profile.
+var configMu sync.Mutex
+
+func init() {
+	flag.StringVar(mode, "emulate", "x360", "alias for -mode")
+	flag.BoolVar(invertLY, "invert-left-y", false, "alias for -invert-ly")
+	flag.BoolVar(invertRY, "invert-right-y", false, "alias for -invert-ry")
+}
+
 func main() {
 	flag.Parse()
 
 	err := run()
 
+	if errors.Is(err, errAlreadyRunning) {
+		log.Print(err)
+		os.Exit(exitAlreadyRunning)
+	}
+
+	var exitErr *exitError
+
+	if errors.As(err, &exitErr) {
+		log.Print(err)
+		os.Exit(exitErr.code)
+	}
 	if err != nil {
 		log.Fatal(err)
 	}
 }
 
 func run() error {
-	controller := stadiacontroller.NewStadiaController()
+	if *list || flag.Arg(0) == "list-devices" {
+		return listDevices()
+	}
+	if flag.Arg(0) == "calibrate" {
+		return runCalibrate(flag.Args()[1:])
+	}
+	if flag.NArg() > 0 {
+		return configError(fmt.Errorf("unknown command '%s'", flag.Arg(0)))
+	}
 
-	defer controller.Close()
+	if !*allowMultiple {
+		release, err := acquireInstanceMutex()
 
-	emulator, err := stadiacontroller.NewEmulator(func(vibration stadiacontroller.Vibration) {
-		controller.Vibrate(vibration.LargeMotor, vibration.SmallMotor)
-	})
+		if err != nil {
+			return err
+		}
+
+		defer release()
+	}
+
+	// ViGEm is not needed to send keyboard and mouse input, or to log reports.
+	if *mode != "kbm" && !*noEmulator {
+		if *vigemDLL != "" {
+			stadiacontroller.SetVigemClientPath(*vigemDLL)
+		} else if err := stadiacontroller.UseEmbeddedVigemClient(); err != nil {
+			return vigemError(err)
+		}
+
+		if err := checkRequirements(); err != nil {
+			return vigemError(err)
+		}
+	}
+
+	if *rumbleScaleL < 0 || *rumbleScaleL > 2 || *rumbleScaleS < 0 || *rumbleScaleS > 2 {
+		return configError(errors.New("motor rumble scales must be between 0 and 2"))
+	}
+
+	// A missing or invalid -profile is reported now rather than when
+	// switching to it.
+	active, err := loadPipeline(*profileName)
 
 	if err != nil {
-		return fmt.Errorf("unable to start ViGEm client: %w", err)
+		return configError(err)
 	}
 
-	defer emulator.Close()
+	// activePipeline is read by onVibration, which is called by ViGEm from
+	// another goroutine.
+	var activePipeline atomic.Value
+	activePipeline.Store(active)
+
+	pipelines := make(chan *pipeline, 1)
 
-	x360, err := emulator.CreateXbox360Controller()
+	var captureChord, assistantChord stadiacontroller.KeyChord
+
+	if *captureKey != "" {
+		if captureChord, err = stadiacontroller.ParseKeyChord(*captureKey); err != nil {
+			return configError(err)
+		}
+	}
+	if *assistantKey != "" {
+		if assistantChord, err = stadiacontroller.ParseKeyChord(*assistantKey); err != nil {
+			return configError(err)
+		}
+	}
+
+	if *poll <= 0 {
+		return configError(errors.New("poll interval must be positive"))
+	}
+
+	var hidden hiddenDevices
+	defer hidden.revealAll()
+
+	controllerOptions := []stadiacontroller.Option{
+		stadiacontroller.WithPollInterval(*poll),
+		stadiacontroller.WithBackoff(*poll, stadiacontroller.DefaultBackoffMax),
+		stadiacontroller.WithConnectHandler(func(info stadiacontroller.DeviceInfo) {
+			log.Printf("opened device %s", info.Path)
+
+			if *hideDevice {
+				hidden.hide(info.Path)
+			}
+		}),
+		stadiacontroller.WithDisconnectHandler(func(err error) {
+			log.Printf("lost controller: %v", err)
+			log.Printf("waiting for new controller")
+		}),
+	}
+
+	selection, err := deviceOptions()
+
+	if err != nil {
+		return configError(err)
+	}
+
+	controllerOptions = append(controllerOptions, selection...)
+	controllerOptions = append(controllerOptions, stadiacontroller.WithSavedCalibration())
+
+	if *bluetoothFW != "" {
+		version, err := stadiacontroller.ParseFirmwareVersion(*bluetoothFW)
+
+		if err != nil {
+			return configError(fmt.Errorf("-bluetooth-firmware: %w", err))
+		}
+
+		controllerOptions = append(controllerOptions, stadiacontroller.WithBluetoothFirmware(version))
+	}
+
+	if *autoCenter {
+		controllerOptions = append(controllerOptions, stadiacontroller.WithAutoCenter(time.Second))
+	}
+
+	chordOpts, chords, err := chordOptions()
 
 	if err != nil {
-		return fmt.Errorf("unable to create emulated Xbox 360 controller: %w", err)
+		return configError(err)
 	}
 
-	defer x360.Close()
+	controllerOptions = append(controllerOptions, chordOpts...)
 
-	if err = x360.Connect(); err != nil {
-		return fmt.Errorf("unable to connect to emulated Xbox 360 controller: %w", err)
+	if *record != "" {
+		recording, err := os.OpenFile(*record, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+
+		if err != nil {
+			return configError(fmt.Errorf("unable to open recording: %w", err))
+		}
+
+		defer recording.Close()
+
+		controllerOptions = append(controllerOptions, stadiacontroller.WithRecorder(recording))
+	}
+
+	controller, err := stadiacontroller.OpenStadiaController(controllerOptions...)
+
+	if err != nil {
+		return deviceError(err)
+	}
+
+	controller.SetProfile(active.profile, active.config)
+
+	defer controller.Close()
+
+	if active.profile != "" {
+		log.Printf("active profile: %s", active.profile)
+	}
+
+	onVibration := func(vibration stadiacontroller.Vibration) {
+		vibration = activePipeline.Load().(*pipeline).rumble.Apply(vibration)
+		controller.VibrateWith(vibration)
+	}
+
+	pad, err := openVirtualPad(*mode, onVibration)
+
+	if err != nil {
+		return vigemError(err)
+	}
+
+	// pad is replaced if the emulated controller is lost, so make sure to
+	// close the latest one.
+	defer func() {
+		if pad != nil {
+			pad.Close()
+		}
+	}()
+
+	// Stop on Ctrl+C, closing the controller to unblock a pending read. The
+	// deferred calls above then disconnect and free the emulated controller.
+	//
+	// SIGTERM is received when the console window is closed or the user logs
+	// off (CTRL_CLOSE_EVENT, CTRL_LOGOFF_EVENT and CTRL_SHUTDOWN_EVENT); the
+	// Go runtime then keeps the process alive until main returns, so that
+	// this cleanup still happens.
+	signals := make(chan os.Signal, 1)
+	stopped := make(chan struct{})
+
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	var stopOnce sync.Once
+
+	stop := func() {
+		stopOnce.Do(func() {
+			log.Printf("shutting down")
+			close(stopped)
+			controller.Close()
+		})
+	}
+
+	go func() {
+		<-signals
+		stop()
+	}()
+
+	restart := make(chan struct{}, 1)
+
+	if chords != nil {
+		go handleChords(controller, chords, stopped, stop, restart, pipelines)
+	}
+
+	if *pauseHotkey != "" {
+		hotkey, err := registerPauseHotkey(controller)
+
+		if err != nil {
+			return configError(err)
+		}
+
+		defer hotkey.Close()
+	}
+
+	if *configPath != "" {
+		go watchConfig(pipelines, stopped)
+	}
+	if *batteryWarn > 0 {
+		go watchBattery(controller, stopped)
 	}
 
 	assistantPressed, capturePressed := false, false
 
+	// Do not leave keys pressed if we stop while a button is held.
+	defer func() {
+		if assistantPressed {
+			assistantChord.Release()
+		}
+		if capturePressed {
+			captureChord.Release()
+		}
+	}()
+
+	lastReport := stadiacontroller.NewXbox360ControllerReport()
+	isNeutral := true
+
+	captureGestures := newButtonGestures("capture", *onCaptureShort, *onCaptureLong, *onCaptureDouble)
+	assistantGestures := newButtonGestures("assistant", *onAssistantShort, *onAssistantLong, *onAssistantDouble)
+
+	// report is reused by GetReportInto to avoid copies in the hot loop.
+	var report stadiacontroller.Xbox360ControllerReport
+
 	for {
-		report, err := controller.GetReport()
+		// Switch profiles between two reports, so that each report is
+		// handled entirely by a single profile.
+		select {
+		case p := <-pipelines:
+			controller.SetProfile(p.profile, p.config)
+			activePipeline.Store(p)
+
+			if p.profile != active.profile {
+				log.Printf("switched to profile %s", p.profile)
+			} else {
+				log.Printf("reloaded config %s", *configPath)
+			}
+
+			active = p
+		default:
+		}
+
+		// Resend the last report periodically so that turbo buttons keep
+		// toggling while no new report is received, and process it again
+		// when a gesture is due, e.g. a long press while the button is held.
+		timeout, wakeUp := turboResendInterval, active.turbo.Active()
+
+		for _, gestures := range []*buttonGestures{captureGestures, assistantGestures} {
+			if delay, ok := gestures.wakeUpIn(time.Now()); ok && (!wakeUp || delay < timeout) {
+				timeout, wakeUp = delay, true
+			}
+		}
+
+		if wakeUp {
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			err = controller.GetReportInto(ctx, &report)
+			cancel()
+
+			if errors.Is(err, context.DeadlineExceeded) {
+				report, err = lastReport, nil
+			}
+		} else {
+			err = controller.GetReportInto(context.Background(), &report)
+		}
+
+		select {
+		case <-stopped:
+			return nil
+		default:
+		}
 
 		if err != nil {
 			if errors.Is(err, stadiacontroller.RetryError) {
-				time.Sleep(1 * time.Second)
+				// Turbo buttons start over once the controller is back,
+				// and are not toggled until then.
+				if errors.Is(err, stadiacontroller.ErrDisconnected) {
+					active.turbo.Reset()
+					captureGestures.detector.Reset()
+					assistantGestures.detector.Reset()
+				}
+
+				// The controller is disconnected on purpose by the restart
+				// chord, so rebuild the emulated controller as well.
+				select {
+				case <-restart:
+					pad.Close()
+
+					if pad, err = openVirtualPad(*mode, onVibration); err != nil {
+						return vigemError(err)
+					}
+
+					log.Printf("restarted emulated controller")
+					isNeutral = true
+				default:
+				}
+
+				// Do not leave the emulated controller holding the last
+				// input received while waiting for the controller.
+				if *resetOnLoss && !isNeutral && errors.Is(err, stadiacontroller.ErrDisconnected) {
+					neutral := stadiacontroller.NewXbox360ControllerReport()
+
+					if err := pad.send(&neutral); err != nil {
+						log.Printf("cannot reset emulated controller: %v", err)
+					}
+
+					lastReport, isNeutral = neutral, true
+				}
+
+				// The controller backs off its own attempts to find a
+				// device, and GetReportInto blocks until its next event,
+				// so reports are read again as soon as they arrive.
 				continue
 			}
-			return err
+			return deviceError(err)
 		}
 
-		err = x360.Send(&report)
+		lastReport, isNeutral = report, false
+
+		output := report
+		active.turbo.Apply(&output, time.Now())
+		stadiacontroller.MutateReport(&output, active.mutators...)
+
+		err = pad.send(&output)
 
 		if err != nil {
-			return err
+			if !isVigemConnectionLost(err) {
+				return vigemError(err)
+			}
+
+			log.Printf("lost emulated controller: %v", err)
+			pad.Close()
+
+			if pad, err = reopenVirtualPad(onVibration, stopped); pad == nil {
+				return vigemError(err)
+			}
+
+			log.Printf("reconnected emulated controller")
 		}
 
 		if report.Assistant != assistantPressed {
 			assistantPressed = report.Assistant
 
-			if err := runButtonPress(assistantPressed, *onAssistantPressed, *onAssistantReleased); err != nil {
+			if err := runButtonPress("assistant", assistantPressed, report.GetButtons(), active.assistantPressed, active.assistantReleased); err != nil {
+				return err
+			}
+			if err := pressChord(assistantPressed, assistantChord); err != nil {
 				return err
 			}
 		}
@@ -87,37 +501,343 @@ func run() error {
 		if report.Capture != capturePressed {
 			capturePressed = report.Capture
 
-			if err := runButtonPress(capturePressed, *onCapturePressed, *onCaptureReleased); err != nil {
+			if err := runButtonPress("capture", capturePressed, report.GetButtons(), active.capturePressed, active.captureReleased); err != nil {
+				return err
+			}
+			if err := pressChord(capturePressed, captureChord); err != nil {
 				return err
 			}
 		}
+
+		now := time.Now()
+
+		if err := captureGestures.update(report.Capture, report.GetButtons(), now); err != nil {
+			return err
+		}
+		if err := assistantGestures.update(report.Assistant, report.GetButtons(), now); err != nil {
+			return err
+		}
 	}
 }
 
-func runButtonPress(pressed bool, ifPressed, ifReleased string) error {
+// loadConfig builds the ParseConfig given by the flags, the -config file and
+// the given profile, if any, along with that profile.
+func loadConfig(profileName string) (*stadiacontroller.ParseConfig, *stadiacontroller.Profile, error) {
+	if *leftDeadzone < 0 || *leftDeadzone > 1 || *rightDeadzone < 0 || *rightDeadzone > 1 {
+		return nil, nil, errors.New("deadzones must be between 0 and 1")
+	}
+	if *smoothing < 0 || *smoothing >= 1 {
+		return nil, nil, errors.New("stick smoothing must be between 0 and 1")
+	}
+	if *leftAntiDz < 0 || *leftAntiDz >= 1 || *rightAntiDz < 0 || *rightAntiDz >= 1 {
+		return nil, nil, errors.New("anti-deadzones must be between 0 and 1")
+	}
+
+	if *digitalTrig != 0 {
+		if *lTriggerPress == 0 {
+			*lTriggerPress = *digitalTrig
+		}
+		if *rTriggerPress == 0 {
+			*rTriggerPress = *digitalTrig
+		}
+	}
+
+	for _, value := range []uint{*lTriggerMin, *lTriggerMax, *rTriggerMin, *rTriggerMax, *lTriggerPress, *rTriggerPress} {
+		if value > 255 {
+			return nil, nil, errors.New("trigger values must be between 0 and 255")
+		}
+	}
+	if *lTriggerMin >= *lTriggerMax || *rTriggerMin >= *rTriggerMax {
+		return nil, nil, errors.New("the minimum of a trigger must be lower than its maximum")
+	}
+
+	leftStickCurve, err := stadiacontroller.ParseResponseCurve(*leftCurve)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	rightStickCurve, err := stadiacontroller.ParseResponseCurve(*rightCurve)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	config := &stadiacontroller.ParseConfig{
+		Deadzone: stadiacontroller.DeadzoneConfig{
+			Left:  *leftDeadzone,
+			Right: *rightDeadzone,
+		},
+		LeftCurve:         leftStickCurve,
+		RightCurve:        rightStickCurve,
+		LeftAntiDeadzone:  *leftAntiDz,
+		RightAntiDeadzone: *rightAntiDz,
+		LeftCircular:      *leftCircle,
+		RightCircular:     *rightCircle,
+		LegacyAxisScaling: *legacyAxes,
+		StickSmoothing:    *smoothing,
+		InvertLeftX:       *invertLX,
+		InvertLeftY:       *invertLY,
+		InvertRightX:      *invertRX,
+		InvertRightY:      *invertRY,
+		LeftTrigger: stadiacontroller.TriggerCalibration{
+			Min:              byte(*lTriggerMin),
+			Max:              byte(*lTriggerMax),
+			DigitalThreshold: byte(*lTriggerPress),
+		},
+		RightTrigger: stadiacontroller.TriggerCalibration{
+			Min:              byte(*rTriggerMin),
+			Max:              byte(*rTriggerMax),
+			DigitalThreshold: byte(*rTriggerPress),
+		},
+	}
+
+	if *configPath != "" {
+		fileConfig, err := stadiacontroller.LoadConfig(*configPath)
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		config.Buttons = fileConfig.Buttons
+		config.Layers = fileConfig.Layers
+		config.CenterOffset = fileConfig.CenterOffset
+		mergeTrigger(&config.LeftTrigger, fileConfig.LeftTrigger)
+		mergeTrigger(&config.RightTrigger, fileConfig.RightTrigger)
+	}
+
+	if *remap != "" {
+		buttons, err := stadiacontroller.LoadButtonMap(*remap)
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		config.Buttons = buttons
+	}
+
+	var profile *stadiacontroller.Profile
+
+	if profileName != "" {
+		if *configPath == "" {
+			return nil, nil, errors.New("-profile requires -config")
+		}
+
+		if profile, err = stadiacontroller.LoadProfile(*configPath, profileName); err != nil {
+			return nil, nil, err
+		}
+
+		profile.Apply(config)
+	}
+
+	if *swapSticks {
+		// Settings given for a stick apply to the stick it is swapped to.
+		config = config.SwapSticks()
+	}
+
+	return config, profile, nil
+}
+
+// watchConfig reloads the pipeline of the active profile whenever the
+// -config file is modified, until stopped is closed. Settings which are not
+// part of the pipeline, e.g. chords, are not reloaded.
+func watchConfig(pipelines chan *pipeline, stopped <-chan struct{}) {
+	var modTime time.Time
+
+	if info, err := os.Stat(*configPath); err == nil {
+		modTime = info.ModTime()
+	}
+
+	ticker := time.NewTicker(configPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-stopped:
+			return
+		}
+
+		info, err := os.Stat(*configPath)
+
+		if err != nil || info.ModTime().Equal(modTime) {
+			continue
+		}
+
+		modTime = info.ModTime()
+
+		configMu.Lock()
+		p, err := loadPipeline(*profileName)
+		configMu.Unlock()
+
+		if err != nil {
+			log.Printf("cannot reload config, keeping the previous one: %v", err)
+			continue
+		}
+
+		requestPipeline(pipelines, p)
+	}
+}
+
+// mergeTrigger overrides the calibration of a trigger given by flags with
+// the non-default settings of the config file.
+func mergeTrigger(flags *stadiacontroller.TriggerCalibration, file stadiacontroller.TriggerCalibration) {
+	if file.Min != 0 {
+		flags.Min = file.Min
+	}
+	if file.Max != 0 {
+		flags.Max = file.Max
+	}
+	if file.DigitalThreshold != 0 {
+		flags.DigitalThreshold = file.DigitalThreshold
+	}
+}
+
+// deviceOptions returns the options which select the device to open, as
+// given by -device-path, -serial, -vid and -pid.
+func deviceOptions() ([]stadiacontroller.Option, error) {
+	var options []stadiacontroller.Option
+
+	if *devicePath != "" {
+		options = append(options, stadiacontroller.WithDevicePath(*devicePath))
+	}
+	if *serial != "" {
+		options = append(options, stadiacontroller.WithSerialNumber(*serial))
+	}
+
+	if ids, err := deviceIDs(); err != nil {
+		return nil, err
+	} else if *productID != 0 {
+		options = append(options, stadiacontroller.WithDeviceIDs(ids...))
+	}
+
+	return options, nil
+}
+
+// deviceIDs returns the IDs of the devices recognized as Stadia controllers,
+// including the one given by -vid and -pid, if any.
+func deviceIDs() ([]stadiacontroller.DeviceID, error) {
+	ids := stadiacontroller.DefaultDeviceIDs
+
+	if *productID == 0 {
+		// -vid alone would silently be ignored, since it only applies to
+		// the product given by -pid.
+		vidSet := false
+
+		flag.Visit(func(f *flag.Flag) {
+			if f.Name == "vid" {
+				vidSet = true
+			}
+		})
+
+		if vidSet {
+			return nil, errors.New("-vid requires -pid")
+		}
+
+		return ids, nil
+	}
+	if *vendorID > 0xffff || *productID > 0xffff {
+		return nil, errors.New("vendor and product IDs must be between 0 and 0xffff")
+	}
+
+	return append(ids[:len(ids):len(ids)], stadiacontroller.DeviceID{Vendor: uint16(*vendorID), Product: uint16(*productID)}), nil
+}
+
+// checkRequirements checks that ViGEm can be used, explaining how to fix it
+// otherwise.
+func checkRequirements() error {
+	err := stadiacontroller.CheckRequirements()
+
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, stadiacontroller.ErrVigemClientNotFound):
+		return fmt.Errorf("%w\nPut ViGEmClient.dll next to this executable; it is included in the releases at https://github.com/71/stadiacontroller/releases", err)
+	case errors.Is(err, stadiacontroller.ErrVigemClientOutdated):
+		return fmt.Errorf("%w\nReplace ViGEmClient.dll with the one included in the releases at https://github.com/71/stadiacontroller/releases", err)
+	case errors.Is(err, stadiacontroller.ErrBusNotFound):
+		return fmt.Errorf("ViGEmBus is not installed: %w\nInstall it from https://github.com/ViGEm/ViGEmBus/releases", err)
+	default:
+		return fmt.Errorf("unable to use ViGEm: %w", err)
+	}
+}
+
+// runButtonPress runs the command for the given button and state, if any.
+// The command receives the button in STADIA_BUTTON, its state in STADIA_STATE
+// ("pressed" or "released", or "short-pressed", "long-pressed" and
+// "double-pressed" for gestures), and the Xbox 360 buttons held at the time as a
+// bitmask in STADIA_BUTTONS, so that a single script can handle all buttons.
+func runButtonPress(button string, pressed bool, buttons uint16, ifPressed, ifReleased string) error {
+	state := "released"
+
+	if pressed {
+		state = "pressed"
+	}
+
+	env := buttonEnv(button, state, buttons)
+
 	if pressed && ifPressed != "" {
-		return runCommand(ifPressed)
+		return runCommand(ifPressed, env...)
 	}
 	if !pressed && ifReleased != "" {
-		return runCommand(ifReleased)
+		return runCommand(ifReleased, env...)
 	}
 	return nil
 }
 
-func runCommand(cmd string) error {
-	command := exec.Command(*shell, "/C", cmd)
+func pressChord(pressed bool, chord stadiacontroller.KeyChord) error {
+	if pressed {
+		return chord.Press()
+	}
+	return chord.Release()
+}
+
+// buttonEnv returns the environment variables given to the commands of a
+// button, described by runButtonPress.
+func buttonEnv(button, state string, buttons uint16) []string {
+	return []string{
+		"STADIA_BUTTON=" + button,
+		"STADIA_STATE=" + state,
+		fmt.Sprintf("STADIA_BUTTONS=0x%04x", buttons),
+	}
+}
+
+// runCommand starts the given command, killing it after -command-timeout if
+// set. The given "KEY=value" variables are added to its environment. With
+// -sync-commands, it also waits for the command to exit. Failures of the
+// command itself are logged rather than returned.
+func runCommand(cmd string, env ...string) error {
+	ctx, cancel := context.Background(), context.CancelFunc(func() {})
+
+	if *cmdTimeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, *cmdTimeout)
+	}
+
+	command := exec.CommandContext(ctx, *shell, "/C", cmd)
+	command.Env = append(os.Environ(), env...)
 
 	if err := command.Start(); err != nil {
+		cancel()
 		return err
 	}
 
-	go func() {
+	wait := func() {
+		defer cancel()
+
 		err := command.Wait()
 
-		if err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			log.Printf("command '%s' killed after %v", cmd, *cmdTimeout)
+		} else if err != nil {
 			log.Printf("command '%s' failed: %v", cmd, err)
 		}
-	}()
+	}
+
+	if *syncCommands {
+		wait()
+	} else {
+		go wait()
+	}
 
 	return nil
 }
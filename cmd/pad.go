@@ -0,0 +1,191 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/71/stadiacontroller"
+)
+
+// maxReconnectDelay is the maximum delay between two attempts to reconnect
+// the emulated controller.
+const maxReconnectDelay = 8 * time.Second
+
+// A virtualPad is an emulated controller, along with the ViGEm client it is
+// connected to.
+type virtualPad struct {
+	send func(report *stadiacontroller.Xbox360ControllerReport) error
+
+	// closers free the resources of the pad, in reverse order.
+	closers []func() error
+}
+
+// openVirtualPad connects to ViGEm and creates an emulated controller of the
+// given type, i.e. "x360" or "ds4". For "kbm", reports are sent as keyboard
+// and mouse input instead, and with -no-emulator they are logged; ViGEm is
+// not used in both cases.
+func openVirtualPad(mode string, onVibration func(vibration stadiacontroller.Vibration)) (*virtualPad, error) {
+	if *noEmulator {
+		return openLoggingPad(), nil
+	}
+	if mode == "kbm" {
+		return openKeyboardMousePad()
+	}
+	if mode != "x360" && mode != "ds4" {
+		return nil, fmt.Errorf("unknown emulation mode '%s'", mode)
+	}
+
+	emulator, err := stadiacontroller.NewEmulator(onVibration)
+
+	if errors.Is(err, stadiacontroller.ErrBusNotFound) {
+		return nil, fmt.Errorf("unable to start ViGEm client, is ViGEmBus installed? %w", err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to start ViGEm client: %w", err)
+	}
+
+	pad := &virtualPad{closers: []func() error{emulator.Close}}
+
+	switch mode {
+	case "x360":
+		x360, err := emulator.CreateXbox360Controller()
+
+		if err != nil {
+			pad.Close()
+			return nil, fmt.Errorf("unable to create emulated Xbox 360 controller: %w", err)
+		}
+
+		pad.closers = append(pad.closers, x360.Close)
+
+		if err = x360.Connect(); err != nil {
+			pad.Close()
+			return nil, fmt.Errorf("unable to connect to emulated Xbox 360 controller: %w", err)
+		}
+
+		pad.closers = append(pad.closers, x360.Disconnect)
+		pad.send = x360.Send
+
+		if userIndex, err := x360.UserIndex(); err == nil {
+			log.Printf("virtual controller connected as player %d", userIndex+1)
+		} else {
+			log.Printf("virtual controller connected, but its player number is unknown: %v", err)
+		}
+
+	case "ds4":
+		ds4, err := emulator.CreateDualShock4Controller(nil)
+
+		if err != nil {
+			pad.Close()
+			return nil, fmt.Errorf("unable to create emulated DualShock 4 controller: %w", err)
+		}
+
+		pad.closers = append(pad.closers, ds4.Close)
+
+		if err = ds4.Connect(); err != nil {
+			pad.Close()
+			return nil, fmt.Errorf("unable to connect to emulated DualShock 4 controller: %w", err)
+		}
+
+		pad.closers = append(pad.closers, ds4.Disconnect)
+		pad.send = func(report *stadiacontroller.Xbox360ControllerReport) error {
+			ds4Report := stadiacontroller.DS4ReportFromXbox360(report)
+
+			return ds4.Send(&ds4Report)
+		}
+	}
+
+	return pad, nil
+}
+
+// openKeyboardMousePad returns a pad which sends keyboard and mouse input
+// configured by -kbm-keys and -kbm-sensitivity.
+func openKeyboardMousePad() (*virtualPad, error) {
+	var keys map[int]uint16
+
+	if *kbmKeys != "" {
+		var err error
+
+		if keys, err = stadiacontroller.ParseKeyboardMapping(*kbmKeys); err != nil {
+			return nil, err
+		}
+	}
+	if *kbmSensitivity <= 0 {
+		return nil, errors.New("-kbm-sensitivity must be positive")
+	}
+
+	sink := stadiacontroller.NewKeyboardMouseSink(keys, *kbmSensitivity)
+
+	return &virtualPad{send: sink.Send, closers: []func() error{sink.Close}}, nil
+}
+
+// openLoggingPad returns a pad which logs the reports it receives in a
+// human-readable form, skipping reports identical to the previous one.
+func openLoggingPad() *virtualPad {
+	var last *stadiacontroller.Xbox360ControllerReport
+
+	send := func(report *stadiacontroller.Xbox360ControllerReport) error {
+		if last != nil && *last == *report {
+			return nil
+		}
+
+		copied := *report
+		last = &copied
+
+		log.Printf("report: %s", report)
+
+		return nil
+	}
+
+	return &virtualPad{send: send}
+}
+
+// Close disconnects and frees the emulated controller, and then the ViGEm
+// client.
+func (p *virtualPad) Close() {
+	for i := len(p.closers) - 1; i >= 0; i-- {
+		p.closers[i]()
+	}
+
+	p.closers = nil
+}
+
+// reopenVirtualPad opens a new emulated controller after the previous one
+// was lost, retrying with exponential backoff up to -vigem-retries times. It
+// returns a nil pad if stopped is closed in the meantime.
+func reopenVirtualPad(onVibration func(vibration stadiacontroller.Vibration), stopped <-chan struct{}) (*virtualPad, error) {
+	delay := 500 * time.Millisecond
+
+	for attempt := 1; ; attempt++ {
+		select {
+		case <-time.After(delay):
+		case <-stopped:
+			return nil, nil
+		}
+
+		pad, err := openVirtualPad(*mode, onVibration)
+
+		if err == nil {
+			return pad, nil
+		}
+		if attempt >= *vigemRetries {
+			return nil, fmt.Errorf("unable to reconnect emulated controller after %d attempts: %w", attempt, err)
+		}
+
+		log.Printf("cannot reconnect emulated controller, retrying: %v", err)
+
+		if delay *= 2; delay > maxReconnectDelay {
+			delay = maxReconnectDelay
+		}
+	}
+}
+
+// isVigemConnectionLost returns whether the given error means that the
+// emulated controller was lost, e.g. because ViGEmBus was restarted.
+func isVigemConnectionLost(err error) bool {
+	return errors.Is(err, stadiacontroller.ErrBusNotFound) ||
+		errors.Is(err, stadiacontroller.ErrTargetNotPluggedIn) ||
+		errors.Is(err, stadiacontroller.ErrBusAccessFailed) ||
+		errors.Is(err, stadiacontroller.ErrBusInvalidHandle)
+}
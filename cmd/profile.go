@@ -0,0 +1,176 @@
+package main
+
+import (
+	"errors"
+
+	"github.com/71/stadiacontroller"
+)
+
+// A pipeline holds the settings which a profile may override, so that they
+// are swapped all at once when switching profiles.
+type pipeline struct {
+	profile string
+	config  *stadiacontroller.ParseConfig
+	turbo   *stadiacontroller.Turbo
+	rumble  stadiacontroller.RumbleTransform
+
+	// mutators are applied to reports after turbo, e.g. to map triggers to
+	// buttons or the left stick to the dpad.
+	mutators []stadiacontroller.ReportMutator
+
+	capturePressed    string
+	captureReleased   string
+	assistantPressed  string
+	assistantReleased string
+}
+
+// loadPipeline builds the pipeline given by the flags, the -config file and
+// the given profile, if any.
+func loadPipeline(profileName string) (*pipeline, error) {
+	config, profile, err := loadConfig(profileName)
+
+	if err != nil {
+		return nil, err
+	}
+
+	turboConfig, err := stadiacontroller.ParseTurboConfig(*turbo)
+
+	if err != nil {
+		return nil, err
+	}
+	if *configPath != "" {
+		fileTurbo, err := stadiacontroller.LoadTurboConfig(*configPath)
+
+		if err != nil {
+			return nil, err
+		}
+		for button, frequency := range fileTurbo {
+			turboConfig[button] = frequency
+		}
+	}
+
+	scale := *rumbleScale
+
+	p := &pipeline{
+		profile:           profileName,
+		config:            config,
+		capturePressed:    *onCapturePressed,
+		captureReleased:   *onCaptureReleased,
+		assistantPressed:  *onAssistantPressed,
+		assistantReleased: *onAssistantReleased,
+	}
+
+	if profile != nil {
+		if profile.Turbo != nil {
+			turboConfig = profile.Turbo
+		}
+		if profile.RumbleScale != nil {
+			scale = *profile.RumbleScale
+		}
+
+		for _, command := range []struct {
+			value *string
+			out   *string
+		}{
+			{profile.CapturePressed, &p.capturePressed},
+			{profile.CaptureReleased, &p.captureReleased},
+			{profile.AssistantPressed, &p.assistantPressed},
+			{profile.AssistantReleased, &p.assistantReleased},
+		} {
+			if command.value != nil {
+				*command.out = *command.value
+			}
+		}
+	}
+
+	if scale < 0 || scale > 1 {
+		return nil, errors.New("rumble scale must be between 0 and 1")
+	}
+
+	if *swapSticks {
+		p.mutators = append(p.mutators, stadiacontroller.SwapSticks)
+	}
+
+	if *configPath != "" {
+		triggerMapper, err := stadiacontroller.LoadTriggerMapper(*configPath, profile)
+
+		if err != nil {
+			return nil, err
+		}
+		if triggerMapper != nil {
+			p.mutators = append(p.mutators, triggerMapper.Apply)
+		}
+
+		dpadMutators, err := stadiacontroller.LoadDpadMutators(*configPath, profile)
+
+		if err != nil {
+			return nil, err
+		}
+
+		p.mutators = append(p.mutators, dpadMutators...)
+	}
+
+	p.turbo = stadiacontroller.NewTurbo(turboConfig)
+	p.rumble = stadiacontroller.RumbleTransform{
+		LargeScale: *rumbleScaleL * scale,
+		SmallScale: *rumbleScaleS * scale,
+		SwapMotors: *swapRumble,
+		Off:        *rumbleOff,
+	}
+
+	return p, nil
+}
+
+// requestPipeline hands the given pipeline to the main loop, which swaps it
+// in before handling the next report. A pipeline which was not swapped in
+// yet is replaced.
+func requestPipeline(pipelines chan *pipeline, p *pipeline) {
+	for {
+		select {
+		case pipelines <- p:
+			return
+		default:
+		}
+
+		select {
+		case <-pipelines:
+		default:
+		}
+	}
+}
+
+// switchProfile loads the pipeline of the given profile and requests it,
+// making it the -profile once it is loaded. Callers must hold configMu.
+func switchProfile(pipelines chan *pipeline, name string) error {
+	p, err := loadPipeline(name)
+
+	if err != nil {
+		return err
+	}
+
+	*profileName = name
+	requestPipeline(pipelines, p)
+
+	return nil
+}
+
+// nextProfile returns the profile of the -config file which follows the
+// -profile in alphabetical order, wrapping around after the last one.
+func nextProfile() (string, error) {
+	names, err := stadiacontroller.ProfileNames(*configPath)
+
+	if err != nil {
+		return "", err
+	}
+	if len(names) == 0 {
+		return "", errors.New("the config has no profiles")
+	}
+
+	for _, name := range names {
+		if name > *profileName {
+			return name, nil
+		}
+	}
+
+	return names[0], nil
+}
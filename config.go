@@ -0,0 +1,588 @@
+package stadiacontroller
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"sort"
+	"strings"
+)
+
+// ParseConfig configures how ParseReportWithConfig translates the reports
+// sent by a Stadia controller. A nil *ParseConfig is equivalent to its zero
+// value, which translates reports as-is.
+type ParseConfig struct {
+	Deadzone DeadzoneConfig
+	Buttons  ButtonMap
+
+	// Layers map ButtonAssistant and ButtonCapture to the button maps used
+	// instead of Buttons while they are held, for the buttons they contain.
+	// A modifier with a layer is not reported as pressed.
+	Layers map[int]ButtonMap
+
+	// LeftCurve and RightCurve are applied to the sticks after their
+	// deadzones.
+	LeftCurve  ResponseCurve
+	RightCurve ResponseCurve
+
+	// LeftAntiDeadzone and RightAntiDeadzone, between 0 and 1, are the
+	// smallest distance from the center reported for a stick which is not
+	// centered, to counter the deadzone of games. They are applied after the
+	// response curves.
+	LeftAntiDeadzone  float64
+	RightAntiDeadzone float64
+
+	// Invert* invert the corresponding stick axes.
+	InvertLeftX  bool
+	InvertLeftY  bool
+	InvertRightX bool
+	InvertRightY bool
+
+	LeftTrigger  TriggerCalibration
+	RightTrigger TriggerCalibration
+
+	// StickSmoothing, between 0 and 1, smooths the stick axes of the reports
+	// read by a StadiaController with an exponential moving average, to hide
+	// their noise. It is the weight of the previous reports; 0 disables it,
+	// and higher values add more latency.
+	StickSmoothing float64
+
+	// LeftCircular and RightCircular scale the sticks down when they are
+	// outside of the unit circle, since sticks may reach further on their
+	// diagonals, so that they move as fast in every direction.
+	LeftCircular  bool
+	RightCircular bool
+
+	// LegacyAxisScaling maps the stick axes like older versions did, which
+	// did not quite reach the full range of Xbox 360 axes.
+	LegacyAxisScaling bool
+
+	// CenterOffset is subtracted from the stick axes before deadzones. It
+	// describes the physical sticks, so it is not swapped by SwapSticks.
+	CenterOffset StickOffsets
+}
+
+// SwapSticks returns a copy of the configuration where the settings of the
+// left and right sticks are swapped. The copy shares the button map of c.
+func (c *ParseConfig) SwapSticks() *ParseConfig {
+	swapped := *c
+
+	swapped.Deadzone.Left, swapped.Deadzone.Right = c.Deadzone.Right, c.Deadzone.Left
+	swapped.Deadzone.LeftX, swapped.Deadzone.RightX = c.Deadzone.RightX, c.Deadzone.LeftX
+	swapped.Deadzone.LeftY, swapped.Deadzone.RightY = c.Deadzone.RightY, c.Deadzone.LeftY
+	swapped.LeftCurve, swapped.RightCurve = c.RightCurve, c.LeftCurve
+	swapped.LeftAntiDeadzone, swapped.RightAntiDeadzone = c.RightAntiDeadzone, c.LeftAntiDeadzone
+	swapped.LeftCircular, swapped.RightCircular = c.RightCircular, c.LeftCircular
+	swapped.InvertLeftX, swapped.InvertRightX = c.InvertRightX, c.InvertLeftX
+	swapped.InvertLeftY, swapped.InvertRightY = c.InvertRightY, c.InvertLeftY
+
+	return &swapped
+}
+
+// DeadzoneConfig configures the inner deadzones of the sticks, as a fraction
+// of their full range between 0 and 1.
+//
+// A stick whose distance from the center is within its radial deadzone Left
+// or Right is reported as centered. Per-axis deadzones are then applied to
+// each axis independently. Values outside of a deadzone are scaled so that
+// the full range of the stick is still reachable.
+type DeadzoneConfig struct {
+	Left  float64
+	Right float64
+
+	LeftX  float64
+	LeftY  float64
+	RightX float64
+	RightY float64
+}
+
+func (d *DeadzoneConfig) applyLeft(x, y int32) (int32, int32) {
+	x, y = applyRadialDeadzone(x, y, d.Left)
+
+	return applyAxisDeadzone(x, d.LeftX), applyAxisDeadzone(y, d.LeftY)
+}
+
+func (d *DeadzoneConfig) applyRight(x, y int32) (int32, int32) {
+	x, y = applyRadialDeadzone(x, y, d.Right)
+
+	return applyAxisDeadzone(x, d.RightX), applyAxisDeadzone(y, d.RightY)
+}
+
+func applyRadialDeadzone(x, y int32, radius float64) (int32, int32) {
+	if radius <= 0 {
+		return x, y
+	}
+
+	magnitude := math.Hypot(float64(x), float64(y)) / 0x7fff
+
+	if magnitude < radius {
+		return 0, 0
+	}
+	if radius >= 1 {
+		return x, y
+	}
+
+	// Scale along the direction of the stick so that diagonals are preserved.
+	scale := (magnitude - radius) / (1 - radius) / magnitude
+
+	return clampAxisValue(float64(x) * scale), clampAxisValue(float64(y) * scale)
+}
+
+// clampToCircle scales the given stick position along its direction so that
+// it is within the unit circle.
+func clampToCircle(x, y int32) (int32, int32) {
+	magnitude := math.Hypot(float64(x), float64(y)) / 0x7fff
+
+	if magnitude <= 1 {
+		return x, y
+	}
+
+	return clampAxisValue(float64(x) / magnitude), clampAxisValue(float64(y) / magnitude)
+}
+
+func applyAxisDeadzone(value int32, deadzone float64) int32 {
+	if deadzone <= 0 {
+		return value
+	}
+
+	magnitude := math.Abs(float64(value)) / 0x7fff
+
+	if magnitude < deadzone {
+		return 0
+	}
+	if deadzone >= 1 {
+		return value
+	}
+
+	return clampAxisValue(float64(value) * (magnitude - deadzone) / (1 - deadzone) / magnitude)
+}
+
+// invertAxisValue mirrors the given axis value around the center, so that
+// 0x7fff maps to -0x8000 and vice versa. 0 is left unchanged so that a
+// centered stick stays centered.
+func invertAxisValue(value int32) int32 {
+	if value == 0 {
+		return 0
+	}
+
+	return -1 - value
+}
+
+func maybeInvertAxisValue(value int32, invert bool) int32 {
+	if invert {
+		return invertAxisValue(value)
+	}
+
+	return value
+}
+
+// clampAxisValue rounds the given value to the nearest valid axis value.
+func clampAxisValue(value float64) int32 {
+	return int32(math.Max(-0x8000, math.Min(0x7fff, math.Round(value))))
+}
+
+// ButtonMap remaps Xbox 360 controller buttons, from the button reported by
+// the Stadia controller to the buttons sent to the emulated controller. A
+// button may be mapped to several buttons, or to none to disable it.
+// Buttons that are not in the map are sent unchanged.
+//
+// The Capture and Assistant buttons, identified by ButtonCapture and
+// ButtonAssistant, are only mapped to Xbox 360 controller buttons if they
+// are in the map; they are always reported as Xbox360ControllerReport.Capture
+// and Xbox360ControllerReport.Assistant.
+type ButtonMap map[int][]int
+
+// Map returns the buttons to which the given button is mapped.
+func (m ButtonMap) Map(button int) []int {
+	if mapped, ok := m[button]; ok {
+		return mapped
+	}
+
+	return []int{button}
+}
+
+// stadiaButtonAliases maps the names of the Stadia controller buttons to the
+// Xbox 360 controller buttons they are reported as by default, so that they
+// can be used as the source of a mapping.
+var stadiaButtonAliases = map[string]int{
+	"L1":      Xbox360ControllerButtonLeftShoulder,
+	"R1":      Xbox360ControllerButtonRightShoulder,
+	"L3":      Xbox360ControllerButtonLeftThumb,
+	"R3":      Xbox360ControllerButtonRightThumb,
+	"Menu":    Xbox360ControllerButtonStart,
+	"Options": Xbox360ControllerButtonBack,
+	"Stadia":  Xbox360ControllerButtonGuide,
+
+	"Capture":   ButtonCapture,
+	"Assistant": ButtonAssistant,
+}
+
+// parsePhysicalButton returns the bit of the button with the given name,
+// which is either an Xbox 360 controller button name or a Stadia controller
+// button name.
+func parsePhysicalButton(name string) (int, error) {
+	for alias, button := range stadiaButtonAliases {
+		if strings.EqualFold(alias, name) {
+			return button, nil
+		}
+	}
+
+	return ParseXbox360ControllerButton(name)
+}
+
+// ButtonTargets are the names of the buttons a button is mapped to. In JSON,
+// it is either a single name or a list of names.
+type ButtonTargets []string
+
+func (t *ButtonTargets) UnmarshalJSON(data []byte) error {
+	var name string
+
+	if err := json.Unmarshal(data, &name); err == nil {
+		*t = ButtonTargets{name}
+		return nil
+	}
+
+	var names []string
+
+	if err := json.Unmarshal(data, &names); err != nil {
+		return errors.New("expected a button name or a list of button names")
+	}
+
+	*t = names
+
+	return nil
+}
+
+func parseButtonMap(names map[string]ButtonTargets) (ButtonMap, error) {
+	buttons := make(ButtonMap, len(names))
+
+	for from, targets := range names {
+		fromButton, err := parsePhysicalButton(from)
+
+		if err != nil {
+			return nil, fmt.Errorf("key '%s': %w", from, err)
+		}
+
+		toButtons := make([]int, 0, len(targets))
+
+		for _, to := range targets {
+			toButton, err := ParseXbox360ControllerButton(to)
+
+			if err != nil {
+				return nil, fmt.Errorf("key '%s': %w", from, err)
+			}
+
+			toButtons = append(toButtons, toButton)
+		}
+
+		buttons[fromButton] = toButtons
+	}
+
+	return buttons, nil
+}
+
+// LoadButtonMap reads a ButtonMap from a JSON file which maps button names
+// to button names, e.g. {"A": "B", "B": "A", "L3": ["Back", "LeftThumb"]}.
+func LoadButtonMap(path string) (ButtonMap, error) {
+	data, err := ioutil.ReadFile(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var names map[string]ButtonTargets
+
+	if err := json.Unmarshal(data, &names); err != nil {
+		return nil, fmt.Errorf("cannot parse button map %s: %w", path, err)
+	}
+
+	buttons, err := parseButtonMap(names)
+
+	if err != nil {
+		return nil, fmt.Errorf("invalid button map %s: %w", path, err)
+	}
+
+	return buttons, nil
+}
+
+// Config is the content of a JSON config file, as read by ReadConfig.
+type Config struct {
+	// Buttons is a button map in the format read by LoadButtonMap.
+	Buttons map[string]ButtonTargets `json:"buttons"`
+
+	// Layers map "Assistant" or "Capture" to the button maps used while they
+	// are held, e.g. {"Assistant": {"A": "Y"}}.
+	Layers map[string]map[string]ButtonTargets `json:"layers"`
+
+	// Profiles are the named profiles read by LoadProfile.
+	Profiles map[string]ProfileConfig `json:"profiles"`
+
+	// Triggers calibrates the triggers.
+	Triggers struct {
+		Left  TriggerConfig `json:"left"`
+		Right TriggerConfig `json:"right"`
+	} `json:"triggers"`
+
+	// StickToDpad, if set, makes the left stick press the dpad.
+	StickToDpad *StickToDpadConfig `json:"stickToDpad"`
+
+	// DpadToStick makes the dpad deflect the left stick.
+	DpadToStick bool `json:"dpadToStick"`
+
+	// Turbo are the turbo buttons read by LoadTurboConfig.
+	Turbo TurboEntries `json:"turbo"`
+
+	// CenterOffset is the resting position of the sticks, as printed by the
+	// "calibrate center" command.
+	CenterOffset StickOffsets `json:"centerOffset"`
+
+	// Chords and ChordWindow, in milliseconds, are read by LoadChords.
+	Chords      []ChordConfig `json:"chords"`
+	ChordWindow *int          `json:"chordWindow"`
+}
+
+// ReadConfig reads a JSON config file without validating it. Unknown keys
+// are rejected.
+func ReadConfig(path string) (*Config, error) {
+	file, err := os.Open(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	decoder := json.NewDecoder(file)
+	decoder.DisallowUnknownFields()
+
+	var config Config
+
+	if err := decoder.Decode(&config); err != nil {
+		return nil, fmt.Errorf("cannot parse config %s: %w", path, err)
+	}
+
+	return &config, nil
+}
+
+// ConfigErrors lists all the problems found by ValidateConfig.
+type ConfigErrors []error
+
+func (errs ConfigErrors) Error() string {
+	messages := make([]string, len(errs))
+
+	for i, err := range errs {
+		messages[i] = err.Error()
+	}
+
+	return strings.Join(messages, "\n")
+}
+
+// ValidateConfig checks all the settings of the given config, returning
+// ConfigErrors listing every invalid setting if any.
+func ValidateConfig(cfg *Config) error {
+	var errs ConfigErrors
+
+	validateButtons := func(prefix string, buttons map[string]ButtonTargets) {
+		froms := make([]string, 0, len(buttons))
+
+		for from := range buttons {
+			froms = append(froms, from)
+		}
+
+		sort.Strings(froms)
+
+		for _, from := range froms {
+			targets := buttons[from]
+
+			if _, err := parsePhysicalButton(from); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", prefix, err))
+			}
+
+			for _, to := range targets {
+				if _, err := ParseXbox360ControllerButton(to); err != nil {
+					errs = append(errs, fmt.Errorf("%s.%s: %w", prefix, from, err))
+				}
+			}
+		}
+	}
+
+	validateButtons("buttons", cfg.Buttons)
+
+	modifiers := make([]string, 0, len(cfg.Layers))
+
+	for modifier := range cfg.Layers {
+		modifiers = append(modifiers, modifier)
+	}
+
+	sort.Strings(modifiers)
+
+	for _, modifier := range modifiers {
+		buttons := cfg.Layers[modifier]
+
+		if _, err := parseLayerModifier(modifier); err != nil {
+			errs = append(errs, fmt.Errorf("layers: %w", err))
+		}
+
+		validateButtons("layers."+modifier, buttons)
+	}
+
+	for _, trigger := range []struct {
+		key    string
+		config TriggerConfig
+	}{
+		{"left", cfg.Triggers.Left},
+		{"right", cfg.Triggers.Right},
+	} {
+		if err := trigger.config.validate(); err != nil {
+			errs = append(errs, fmt.Errorf("triggers.%s: %w", trigger.key, err))
+		}
+	}
+
+	if err := validateDpad(cfg.StickToDpad, cfg.DpadToStick); err != nil {
+		errs = append(errs, err)
+	}
+
+	if _, err := cfg.Turbo.config(); err != nil {
+		errs = append(errs, fmt.Errorf("turbo: %w", err))
+	}
+
+	for i, chord := range cfg.Chords {
+		if _, err := chord.chord(cfg.Profiles); err != nil {
+			errs = append(errs, fmt.Errorf("chords[%d].%w", i, err))
+		}
+	}
+
+	if cfg.ChordWindow != nil && *cfg.ChordWindow <= 0 {
+		errs = append(errs, errors.New("chordWindow: must be positive"))
+	}
+
+	names := make([]string, 0, len(cfg.Profiles))
+
+	for name := range cfg.Profiles {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		profile := cfg.Profiles[name]
+		prefix := "profiles." + name
+
+		validateButtons(prefix+".buttons", profile.Buttons)
+
+		for _, deadzone := range []struct {
+			key   string
+			value *float64
+		}{
+			{"left", profile.Deadzone.Left}, {"right", profile.Deadzone.Right},
+			{"leftX", profile.Deadzone.LeftX}, {"leftY", profile.Deadzone.LeftY},
+			{"rightX", profile.Deadzone.RightX}, {"rightY", profile.Deadzone.RightY},
+		} {
+			if deadzone.value != nil && (*deadzone.value < 0 || *deadzone.value > 1) {
+				errs = append(errs, fmt.Errorf("%s.deadzone.%s: must be between 0 and 1", prefix, deadzone.key))
+			}
+		}
+
+		for _, curve := range []struct {
+			key   string
+			value *string
+		}{
+			{"left", profile.Curve.Left},
+			{"right", profile.Curve.Right},
+		} {
+			if curve.value != nil {
+				if _, err := ParseResponseCurve(*curve.value); err != nil {
+					errs = append(errs, fmt.Errorf("%s.curve.%s: %w", prefix, curve.key, err))
+				}
+			}
+		}
+
+		if profile.RumbleScale != nil && (*profile.RumbleScale < 0 || *profile.RumbleScale > 1) {
+			errs = append(errs, fmt.Errorf("%s.rumbleScale: must be between 0 and 1", prefix))
+		}
+
+		if profile.Turbo != nil {
+			if _, err := ParseTurboConfig(*profile.Turbo); err != nil {
+				errs = append(errs, fmt.Errorf("%s.turbo: %w", prefix, err))
+			}
+		}
+
+		// Settings of the profile are validated along with the ones of the
+		// config they override, e.g. a deadzone against the saturation of
+		// the config.
+		for _, trigger := range []struct {
+			key      string
+			config   TriggerConfig
+			override *TriggerConfig
+		}{
+			{"left", cfg.Triggers.Left, profile.Triggers.Left},
+			{"right", cfg.Triggers.Right, profile.Triggers.Right},
+		} {
+			if trigger.override == nil {
+				continue
+			}
+			if err := trigger.config.override(trigger.override).validate(); err != nil {
+				errs = append(errs, fmt.Errorf("%s.triggers.%s: %w", prefix, trigger.key, err))
+			}
+		}
+
+		if profile.StickToDpad != nil || profile.DpadToStick != nil {
+			if err := validateDpad(overrideDpad(cfg, profile.StickToDpad, profile.DpadToStick)); err != nil {
+				errs = append(errs, fmt.Errorf("%s.%w", prefix, err))
+			}
+		}
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+
+	return errs
+}
+
+// readValidConfig reads the given config file with ReadConfig, and then
+// validates it.
+func readValidConfig(path string) (*Config, error) {
+	config, err := ReadConfig(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := ValidateConfig(config); err != nil {
+		return nil, fmt.Errorf("invalid config %s:\n%w", path, err)
+	}
+
+	return config, nil
+}
+
+// LoadConfig reads a ParseConfig from a JSON config file. Only the "buttons",
+// "layers" and "triggers" keys are read; profiles are read by LoadProfile.
+func LoadConfig(path string) (*ParseConfig, error) {
+	config, err := readValidConfig(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	buttons, err := parseButtonMap(config.Buttons)
+
+	if err != nil {
+		return nil, fmt.Errorf("invalid config %s: buttons: %w", path, err)
+	}
+
+	layers, err := parseLayers(config.Layers)
+
+	if err != nil {
+		return nil, fmt.Errorf("invalid config %s: layers: %w", path, err)
+	}
+
+	parseConfig := &ParseConfig{Buttons: buttons, Layers: layers, CenterOffset: config.CenterOffset}
+	config.Triggers.Left.apply(&parseConfig.LeftTrigger)
+	config.Triggers.Right.apply(&parseConfig.RightTrigger)
+
+	return parseConfig, nil
+}
@@ -0,0 +1,112 @@
+package stadiacontroller
+
+import (
+	"encoding/json"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestDeadzone(t *testing.T) {
+	for _, test := range []struct {
+		name         string
+		deadzone     DeadzoneConfig
+		leftX, leftY byte
+		wantX, wantY int16
+	}{
+		{"centered", DeadzoneConfig{Left: 0.2}, 0x80, 0x80, 0, 0},
+		{"drift", DeadzoneConfig{Left: 0.2}, 0x90, 0x78, 0, 0},
+		{"full right", DeadzoneConfig{Left: 0.2}, 0xff, 0x80, 32767, 0},
+		{"full left", DeadzoneConfig{Left: 0.2}, 0x00, 0x80, -32768, 0},
+		{"full up", DeadzoneConfig{Left: 0.2}, 0x80, 0x00, 0, 32767},
+		{"halfway, rescaled", DeadzoneConfig{Left: 0.2}, 0xc0, 0x80, 12448, 0},
+		{"diagonal inside the deadzone", DeadzoneConfig{Left: 0.2}, 0x92, 0x6e, 0, 0},
+		// Each axis is within the deadzone, but the stick is not: it must
+		// leave the deadzone smoothly, in the same direction.
+		{"diagonal just outside the deadzone", DeadzoneConfig{Left: 0.2}, 0x93, 0x6d, 312, 309},
+		{"axis deadzone", DeadzoneConfig{LeftX: 0.2}, 0x90, 0xc0, 0, -16513},
+		{"axis deadzones", DeadzoneConfig{LeftX: 0.1, LeftY: 0.3}, 0xa0, 0x40, 5533, 9361},
+		{"right deadzone only", DeadzoneConfig{Right: 0.2, RightX: 0.5}, 0x90, 0x78, 4128, 2047},
+	} {
+		data := centeredReport(func(payload []byte) {
+			payload[3], payload[4] = test.leftX, test.leftY
+		})
+
+		var report Xbox360ControllerReport
+
+		if err := ParseReportWithConfig(data, &report, &ParseConfig{Deadzone: test.deadzone}); err != nil {
+			t.Fatalf("%s: ParseReportWithConfig(% x) failed: %v", test.name, data, err)
+		}
+
+		if x, y := report.GetLeftThumb(); x != test.wantX || y != test.wantY {
+			t.Errorf("%s: left stick (%#02x, %#02x) is at (%d, %d), want (%d, %d)", test.name, test.leftX, test.leftY, x, y, test.wantX, test.wantY)
+		}
+	}
+}
+
+func TestDeadzoneIsContinuous(t *testing.T) {
+	deadzone := DeadzoneConfig{Left: 0.2}
+
+	// Along any direction, the output starts from the center at the edge of
+	// the deadzone and grows without jumps.
+	for _, angle := range []float64{0, 30, 45, 90, 135, 200, 315} {
+		dx, dy := math.Cos(angle*math.Pi/180), math.Sin(angle*math.Pi/180)
+		previous := 0.0
+
+		for i := 0; i <= 1000; i++ {
+			length := float64(i) / 1000 * 0x7fff
+			x, y := deadzone.applyLeft(int32(dx*length), int32(dy*length))
+			magnitude := math.Hypot(float64(x), float64(y))
+
+			if magnitude < previous || magnitude-previous > 100 {
+				t.Errorf("angle %v: step %d: magnitude went from %.0f to %.0f", angle, i, previous, magnitude)
+				break
+			}
+
+			previous = magnitude
+		}
+	}
+}
+
+func TestValidateConfigOrder(t *testing.T) {
+	const config = `{
+		"buttons": {"Z": ["A"], "A": ["Turbo"], "M": ["B"]},
+		"layers": {"Capture": {"Q": ["A"]}, "Assistant": {"P": ["A"]}},
+		"triggers": {"right": {"deadzone": -1, "digital": 300}, "left": {"saturation": 256, "threshold": -1}},
+		"turbo": {"Y": 0, "W": 0, "X": -1},
+		"profiles": {
+			"b": {"buttons": {"K": ["A"]}, "deadzone": {"rightY": 2, "left": 2}, "curve": {"right": "wobbly", "left": "wobbly"}},
+			"a": {"triggers": {"right": {"mode": "sideways"}, "left": {"deadzone": 300}}}
+		}
+	}`
+
+	want := strings.Join([]string{
+		"buttons.A: unknown button 'Turbo'",
+		"buttons: unknown button 'M'",
+		"buttons: unknown button 'Z'",
+		"layers.Assistant: unknown button 'P'",
+		"layers.Capture: unknown button 'Q'",
+		"triggers.left: saturation: must be between 0 and 255",
+		"triggers.right: deadzone: must be between 0 and 255",
+		"turbo: unknown button 'W'",
+		"profiles.a.triggers.left: deadzone: must be between 0 and 255",
+		"profiles.a.triggers.right: deadzone: must be between 0 and 255",
+		"profiles.b.buttons: unknown button 'K'",
+		"profiles.b.deadzone.left: must be between 0 and 1",
+		"profiles.b.deadzone.rightY: must be between 0 and 1",
+		"profiles.b.curve.left: invalid response curve 'wobbly'",
+		"profiles.b.curve.right: invalid response curve 'wobbly'",
+	}, "\n")
+
+	for i := 0; i < 20; i++ {
+		var cfg Config
+
+		if err := json.Unmarshal([]byte(config), &cfg); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := ValidateConfig(&cfg); err == nil || err.Error() != want {
+			t.Fatalf("ValidateConfig() = \n%v\nwant\n%s", err, want)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package stadiacontroller
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// A ResponseCurve is the exponent applied to the distance of a stick from its
+// center, as a fraction of its full range. Exponents above 1 give finer
+// control near the center while still reaching full deflection at the edge.
+//
+// The zero value is equivalent to LinearCurve.
+type ResponseCurve float64
+
+// Preset response curves.
+const (
+	LinearCurve  ResponseCurve = 1
+	SquaredCurve ResponseCurve = 2
+	CubedCurve   ResponseCurve = 3
+)
+
+// ParseResponseCurve parses a response curve given either as a preset name
+// ("linear", "squared" or "cubed") or as a positive exponent, e.g. "1.5".
+func ParseResponseCurve(s string) (ResponseCurve, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "", "linear":
+		return LinearCurve, nil
+	case "squared":
+		return SquaredCurve, nil
+	case "cubed":
+		return CubedCurve, nil
+	}
+
+	exponent, err := strconv.ParseFloat(s, 64)
+
+	if err != nil || exponent <= 0 || math.IsInf(exponent, 0) || math.IsNaN(exponent) {
+		return 0, fmt.Errorf("invalid response curve '%s'", s)
+	}
+
+	return ResponseCurve(exponent), nil
+}
+
+// apply applies the curve to the magnitude of the given stick position,
+// preserving its direction so that diagonals are not distorted.
+func (c ResponseCurve) apply(x, y int32) (int32, int32) {
+	if c <= 0 || c == LinearCurve || (x == 0 && y == 0) {
+		return x, y
+	}
+
+	magnitude := math.Hypot(float64(x), float64(y)) / 0x7fff
+
+	if magnitude >= 1 {
+		return x, y
+	}
+
+	scale := math.Pow(magnitude, float64(c)) / magnitude
+
+	return clampAxisValue(float64(x) * scale), clampAxisValue(float64(y) * scale)
+}
+
+// applyAntiDeadzone maps the magnitude of the given stick position from
+// (0, 1] to (antiDeadzone, 1], preserving its direction, so that the
+// smallest movements already reach past the deadzone applied by a game. A
+// centered stick stays centered.
+func applyAntiDeadzone(x, y int32, antiDeadzone float64) (int32, int32) {
+	if antiDeadzone <= 0 || (x == 0 && y == 0) {
+		return x, y
+	}
+
+	magnitude := math.Hypot(float64(x), float64(y)) / 0x7fff
+
+	if magnitude >= 1 {
+		return x, y
+	}
+
+	scale := (antiDeadzone + (1-antiDeadzone)*magnitude) / magnitude
+
+	return clampAxisValue(float64(x) * scale), clampAxisValue(float64(y) * scale)
+}
@@ -0,0 +1,136 @@
+package stadiacontroller
+
+import (
+	"math"
+	"testing"
+)
+
+func TestParseResponseCurve(t *testing.T) {
+	for _, test := range []struct {
+		s       string
+		want    ResponseCurve
+		wantErr bool
+	}{
+		{"", LinearCurve, false},
+		{"linear", LinearCurve, false},
+		{"Squared", SquaredCurve, false},
+		{" cubed ", CubedCurve, false},
+		{"1.5", 1.5, false},
+		{"0.5", 0.5, false},
+		{"0", 0, true},
+		{"-2", 0, true},
+		{"Inf", 0, true},
+		{"NaN", 0, true},
+		{"steep", 0, true},
+	} {
+		curve, err := ParseResponseCurve(test.s)
+
+		if (err != nil) != test.wantErr {
+			t.Errorf("ParseResponseCurve(%q) returned error %v, want error %v", test.s, err, test.wantErr)
+		} else if curve != test.want {
+			t.Errorf("ParseResponseCurve(%q) = %v, want %v", test.s, curve, test.want)
+		}
+	}
+}
+
+var testCurves = []ResponseCurve{0, LinearCurve, SquaredCurve, CubedCurve, 1.5, 0.5}
+
+func TestResponseCurveEndpoints(t *testing.T) {
+	for _, curve := range testCurves {
+		for _, test := range []struct{ x, y int32 }{
+			{0, 0},
+			{0x7fff, 0},
+			{-0x7fff, 0},
+			{0, 0x7fff},
+			{0, -0x7fff},
+			{-0x8000, 0},
+		} {
+			if x, y := curve.apply(test.x, test.y); x != test.x || y != test.y {
+				t.Errorf("curve %v: apply(%d, %d) = (%d, %d), want it unchanged", curve, test.x, test.y, x, y)
+			}
+		}
+	}
+}
+
+func TestResponseCurveMonotonic(t *testing.T) {
+	for _, curve := range testCurves {
+		previous := int32(0)
+
+		for value := int32(0); value <= 0x7fff; value++ {
+			x, _ := curve.apply(value, 0)
+			_, y := curve.apply(0, -value)
+
+			if x < previous {
+				t.Errorf("curve %v: apply(%d, 0) = %d, lower than %d for a smaller deflection", curve, value, x, previous)
+				break
+			}
+			if y != -x {
+				t.Errorf("curve %v: apply(0, %d) = %d, want %d", curve, -value, y, -x)
+				break
+			}
+
+			previous = x
+		}
+	}
+}
+
+func TestResponseCurvePreservesDirection(t *testing.T) {
+	for _, curve := range testCurves {
+		for _, test := range []struct{ x, y int32 }{
+			{20000, 20000},
+			{-20000, 20000},
+			{30000, 10000},
+			{-10000, -30000},
+		} {
+			x, y := curve.apply(test.x, test.y)
+
+			// Rounding each axis may shift the angle very slightly.
+			if got, want := math.Atan2(float64(y), float64(x)), math.Atan2(float64(test.y), float64(test.x)); math.Abs(got-want) > 1e-3 {
+				t.Errorf("curve %v: apply(%d, %d) = (%d, %d), which changes the direction", curve, test.x, test.y, x, y)
+			}
+		}
+	}
+}
+
+func TestResponseCurveAfterDeadzone(t *testing.T) {
+	const deadzone = 0.2
+
+	cfg := &ParseConfig{Deadzone: DeadzoneConfig{Left: deadzone}, LeftCurve: SquaredCurve}
+	previous := int16(math.MinInt16)
+
+	for value := 0; value <= 0xff; value++ {
+		report := ToXbox360ReportWithConfig(&StadiaReport{LeftX: byte(value), LeftY: 0x80, RightX: 0x80, RightY: 0x80}, cfg)
+		x, y := report.GetLeftThumb()
+
+		if y != 0 {
+			t.Errorf("LeftX %#02x: left thumb Y is %d, want 0", value, y)
+		}
+		if x < previous {
+			t.Errorf("LeftX %#02x: left thumb X is %d, lower than %d for a smaller value", value, x, previous)
+		}
+
+		previous = x
+
+		// The curve applies to the distance past the deadzone, so that the
+		// edge of the deadzone maps to the center.
+		magnitude := math.Abs(float64(scaleAxisByte(byte(value)))) / 0x7fff
+		want := 0.0
+
+		if magnitude >= deadzone {
+			want = math.Min(1, math.Pow((magnitude-deadzone)/(1-deadzone), 2)) * 0x7fff
+		}
+		if value < 0x80 {
+			want = -want
+		}
+
+		if math.Abs(float64(x)-want) > 2 {
+			t.Errorf("LeftX %#02x: left thumb X is %d, want %.0f", value, x, want)
+		}
+	}
+
+	report := ToXbox360ReportWithConfig(&StadiaReport{LeftX: 0xff, LeftY: 0x80}, cfg)
+
+	if x, _ := report.GetLeftThumb(); x != 0x7fff {
+		t.Errorf("full deflection gives %d, want %d", x, 0x7fff)
+	}
+}
@@ -0,0 +1,234 @@
+package stadiacontroller
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// Default settings of a StickToDpad.
+const (
+	DefaultStickToDpadThreshold  = 0.5
+	DefaultStickToDpadHysteresis = 0.1
+	DefaultStickToDpadAngle      = 135
+)
+
+// A StickToDpad presses the dpad in the direction of the left stick, and
+// centers the stick. Its Apply method is a ReportMutator. Since it remembers
+// whether the stick is engaged, a StickToDpad must only be used for a single
+// stream of reports.
+type StickToDpad struct {
+	// Threshold is the distance from the center, between 0 and 1, from which
+	// the stick presses the dpad.
+	Threshold float64
+
+	// Hysteresis is how far below Threshold the stick must go back before
+	// the dpad is released, so that it does not flicker on the boundary.
+	Hysteresis float64
+
+	// EightWay enables diagonals. Otherwise, only the direction closest to
+	// the stick is pressed.
+	EightWay bool
+
+	// Angle is the width in degrees of the sector around each direction in
+	// which it is pressed in 8-way mode, at least 90 and lower than 180, at
+	// which opposite sectors would touch. Sectors overlap on diagonals, so
+	// 135 gives all 8 directions equal sectors, and larger angles favor
+	// diagonals.
+	Angle float64
+
+	engaged bool
+}
+
+// NewStickToDpad returns a StickToDpad with the default threshold,
+// hysteresis and angle.
+func NewStickToDpad(eightWay bool) *StickToDpad {
+	return &StickToDpad{
+		Threshold:  DefaultStickToDpadThreshold,
+		Hysteresis: DefaultStickToDpadHysteresis,
+		EightWay:   eightWay,
+		Angle:      DefaultStickToDpadAngle,
+	}
+}
+
+// Apply presses the dpad according to the left stick of the report.
+func (s *StickToDpad) Apply(report *Xbox360ControllerReport) {
+	x, y := report.GetLeftThumb()
+	report.SetLeftThumb(0, 0)
+
+	magnitude := math.Hypot(float64(x), float64(y)) / math.MaxInt16
+
+	if s.engaged {
+		s.engaged = magnitude >= s.Threshold-s.Hysteresis
+	} else {
+		s.engaged = magnitude >= s.Threshold
+	}
+
+	if !s.engaged || magnitude == 0 {
+		return
+	}
+
+	// Angle of the stick in degrees, counterclockwise from the right.
+	angle := math.Atan2(float64(y), float64(x)) * 180 / math.Pi
+
+	halfSector := 45.0
+
+	if s.EightWay {
+		halfSector = s.Angle / 2
+	}
+
+	for _, direction := range []struct {
+		button int
+		angle  float64
+	}{
+		{Xbox360ControllerButtonRight, 0},
+		{Xbox360ControllerButtonUp, 90},
+		{Xbox360ControllerButtonLeft, 180},
+		{Xbox360ControllerButtonDown, -90},
+	} {
+		delta := math.Abs(math.Remainder(angle-direction.angle, 360))
+
+		if delta > halfSector {
+			continue
+		}
+
+		report.SetButton(direction.button)
+
+		// In 4-way mode, a stick exactly on a diagonal is on the boundary of
+		// two sectors; only press the first one.
+		if !s.EightWay {
+			return
+		}
+	}
+}
+
+// DpadToStick fully deflects the left stick in the directions of the dpad
+// buttons which are held, for games which only read the stick. The dpad
+// buttons are left pressed.
+func DpadToStick(report *Xbox360ControllerReport) {
+	buttons := report.GetButtons()
+	x, y := report.GetLeftThumb()
+
+	held := func(button int) bool { return buttons&(1<<button) != 0 }
+
+	switch {
+	case held(Xbox360ControllerButtonLeft) && !held(Xbox360ControllerButtonRight):
+		x = -math.MaxInt16
+	case held(Xbox360ControllerButtonRight) && !held(Xbox360ControllerButtonLeft):
+		x = math.MaxInt16
+	}
+
+	switch {
+	case held(Xbox360ControllerButtonDown) && !held(Xbox360ControllerButtonUp):
+		y = -math.MaxInt16
+	case held(Xbox360ControllerButtonUp) && !held(Xbox360ControllerButtonDown):
+		y = math.MaxInt16
+	}
+
+	report.SetLeftThumb(x, y)
+}
+
+// StickToDpadConfig configures a StickToDpad in a JSON config file. Unset
+// fields keep their default.
+type StickToDpadConfig struct {
+	// Directions is 4 or 8. Defaults to 8.
+	Directions *int     `json:"directions"`
+	Threshold  *float64 `json:"threshold"`
+	Hysteresis *float64 `json:"hysteresis"`
+	Angle      *float64 `json:"angle"`
+}
+
+func (c *StickToDpadConfig) validate() error {
+	if c.Directions != nil && *c.Directions != 4 && *c.Directions != 8 {
+		return errors.New("directions: must be 4 or 8")
+	}
+	if c.Threshold != nil && (*c.Threshold <= 0 || *c.Threshold > 1) {
+		return errors.New("threshold: must be between 0 and 1")
+	}
+	if c.Hysteresis != nil && (*c.Hysteresis < 0 || *c.Hysteresis > 1) {
+		return errors.New("hysteresis: must be between 0 and 1")
+	}
+	if c.Angle != nil && (*c.Angle < 90 || *c.Angle >= 180) {
+		return errors.New("angle: must be at least 90 and lower than 180")
+	}
+
+	return nil
+}
+
+// stickToDpad returns the StickToDpad described by c.
+func (c *StickToDpadConfig) stickToDpad() *StickToDpad {
+	s := NewStickToDpad(c.Directions == nil || *c.Directions == 8)
+
+	if c.Threshold != nil {
+		s.Threshold = *c.Threshold
+	}
+	if c.Hysteresis != nil {
+		s.Hysteresis = *c.Hysteresis
+	}
+	if c.Angle != nil {
+		s.Angle = *c.Angle
+	}
+
+	return s
+}
+
+// LoadDpadMutators reads the "stickToDpad" and "dpadToStick" settings of a
+// JSON config file, e.g.
+//
+//	{"stickToDpad": {"directions": 4, "threshold": 0.5, "hysteresis": 0.1}}
+//
+// and returns the corresponding mutators, if any. Since the dpad is mapped
+// after buttons are remapped, "dpadToStick" uses the remapped dpad. The
+// settings given by profile, if not nil, replace the ones of the config.
+func LoadDpadMutators(path string, profile *Profile) ([]ReportMutator, error) {
+	config, err := readValidConfig(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	stickToDpad, dpadToStick := config.StickToDpad, config.DpadToStick
+
+	if profile != nil {
+		stickToDpad, dpadToStick = overrideDpad(config, profile.StickToDpad, profile.DpadToStick)
+	}
+
+	var mutators []ReportMutator
+
+	if stickToDpad != nil {
+		mutators = append(mutators, stickToDpad.stickToDpad().Apply)
+	}
+	if dpadToStick {
+		mutators = append(mutators, DpadToStick)
+	}
+
+	return mutators, nil
+}
+
+// overrideDpad returns the "stickToDpad" and "dpadToStick" settings of cfg,
+// replaced by the given settings of a profile when they are not nil.
+func overrideDpad(cfg *Config, stickToDpad *StickToDpadConfig, dpadToStick *bool) (*StickToDpadConfig, bool) {
+	if stickToDpad == nil {
+		stickToDpad = cfg.StickToDpad
+	}
+	if dpadToStick == nil {
+		return stickToDpad, cfg.DpadToStick
+	}
+
+	return stickToDpad, *dpadToStick
+}
+
+// validateDpad checks the given "stickToDpad" and "dpadToStick" settings.
+func validateDpad(stickToDpad *StickToDpadConfig, dpadToStick bool) error {
+	if stickToDpad == nil {
+		return nil
+	}
+	if dpadToStick {
+		return errors.New("stickToDpad and dpadToStick cannot be combined")
+	}
+	if err := stickToDpad.validate(); err != nil {
+		return fmt.Errorf("stickToDpad.%w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,162 @@
+package stadiacontroller
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+// dpadButtons returns the dpad buttons pressed in the given report.
+func dpadButtons(report *Xbox360ControllerReport) []int {
+	var pressed []int
+
+	for _, button := range []int{Xbox360ControllerButtonUp, Xbox360ControllerButtonDown, Xbox360ControllerButtonLeft, Xbox360ControllerButtonRight} {
+		if report.GetButtons()&(1<<button) != 0 {
+			pressed = append(pressed, button)
+		}
+	}
+
+	return pressed
+}
+
+// stickAt returns a report in which the left stick is at the given angle in
+// degrees, counterclockwise from the right, and distance from the center.
+func stickAt(angle, distance float64) Xbox360ControllerReport {
+	report := NewXbox360ControllerReport()
+	radians := angle * math.Pi / 180
+
+	report.SetLeftThumb(int16(math.Cos(radians)*distance*math.MaxInt16), int16(math.Sin(radians)*distance*math.MaxInt16))
+
+	return report
+}
+
+func TestStickToDpadSectors(t *testing.T) {
+	const (
+		up    = Xbox360ControllerButtonUp
+		down  = Xbox360ControllerButtonDown
+		left  = Xbox360ControllerButtonLeft
+		right = Xbox360ControllerButtonRight
+	)
+
+	for _, test := range []struct {
+		name     string
+		eightWay bool
+		angle    float64
+		stick    float64
+		want     []int
+	}{
+		{"4-way right", false, 0, 0, []int{right}},
+		{"4-way up", false, 0, 90, []int{up}},
+		{"4-way left", false, 0, 180, []int{left}},
+		{"4-way down", false, 0, -90, []int{down}},
+		{"4-way closer to right", false, 0, 44, []int{right}},
+		{"4-way closer to up", false, 0, 46, []int{up}},
+		{"4-way closer to down", false, 0, 226, []int{down}},
+		{"8-way right", true, 135, 0, []int{right}},
+		{"8-way up-right", true, 135, 45, []int{up, right}},
+		{"8-way just before the diagonal", true, 135, 22, []int{right}},
+		{"8-way just after the diagonal", true, 135, 23, []int{up, right}},
+		{"8-way down-left", true, 135, 225, []int{down, left}},
+		{"8-way up-left", true, 135, 135, []int{up, left}},
+		{"8-way narrow sectors", true, 90, 40, []int{right}},
+		{"8-way wide sectors", true, 170, 10, []int{up, right}},
+	} {
+		s := &StickToDpad{Threshold: 0.5, EightWay: test.eightWay, Angle: test.angle}
+		report := stickAt(test.stick, 1)
+
+		s.Apply(&report)
+
+		if got := dpadButtons(&report); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: stick at %v° pressed %v, want %v", test.name, test.stick, got, test.want)
+		}
+		if x, y := report.GetLeftThumb(); x != 0 || y != 0 {
+			t.Errorf("%s: stick was left at (%d, %d), want it centered", test.name, x, y)
+		}
+	}
+}
+
+func TestStickToDpadHysteresis(t *testing.T) {
+	s := NewStickToDpad(false)
+
+	for i, step := range []struct {
+		distance float64
+		pressed  bool
+	}{
+		{0.3, false},
+		{0.49, false},
+		{0.51, true},
+		// Within the hysteresis, the dpad stays pressed.
+		{0.45, true},
+		{0.41, true},
+		{0.39, false},
+		// Once released, it must reach the threshold again.
+		{0.45, false},
+		{0.55, true},
+		{0, false},
+	} {
+		report := stickAt(90, step.distance)
+		s.Apply(&report)
+
+		if pressed := len(dpadButtons(&report)) > 0; pressed != step.pressed {
+			t.Errorf("step %d: stick at %v pressed the dpad: %v, want %v", i, step.distance, pressed, step.pressed)
+		}
+	}
+}
+
+func TestDpadToStick(t *testing.T) {
+	const (
+		up    = Xbox360ControllerButtonUp
+		down  = Xbox360ControllerButtonDown
+		left  = Xbox360ControllerButtonLeft
+		right = Xbox360ControllerButtonRight
+	)
+
+	for _, test := range []struct {
+		name         string
+		buttons      []int
+		wantX, wantY int16
+	}{
+		{"released", nil, 1000, -2000},
+		{"up", []int{up}, 1000, math.MaxInt16},
+		{"down", []int{down}, 1000, -math.MaxInt16},
+		{"left", []int{left}, -math.MaxInt16, -2000},
+		{"right", []int{right}, math.MaxInt16, -2000},
+		{"diagonal", []int{down, right}, math.MaxInt16, -math.MaxInt16},
+		{"opposite directions cancel", []int{left, right, up}, 1000, math.MaxInt16},
+	} {
+		report := NewXbox360ControllerReport()
+		report.SetLeftThumb(1000, -2000)
+
+		for _, button := range test.buttons {
+			report.SetButton(button)
+		}
+
+		DpadToStick(&report)
+
+		if x, y := report.GetLeftThumb(); x != test.wantX || y != test.wantY {
+			t.Errorf("%s: left stick is at (%d, %d), want (%d, %d)", test.name, x, y, test.wantX, test.wantY)
+		}
+		if got := len(dpadButtons(&report)); got != len(test.buttons) {
+			t.Errorf("%s: %d dpad buttons are pressed, want %d", test.name, got, len(test.buttons))
+		}
+	}
+}
+
+func TestStickToDpadConfigAngle(t *testing.T) {
+	for _, test := range []struct {
+		angle   float64
+		wantErr bool
+	}{
+		{89, true},
+		{90, false},
+		{135, false},
+		{179, false},
+		{180, true},
+	} {
+		config := StickToDpadConfig{Angle: &test.angle}
+
+		if err := config.validate(); (err != nil) != test.wantErr {
+			t.Errorf("angle %v: validate() = %v, want error %v", test.angle, err, test.wantErr)
+		}
+	}
+}
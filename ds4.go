@@ -0,0 +1,374 @@
+package stadiacontroller
+
+import (
+	"encoding/binary"
+	"errors"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// CreateDualShock4Controller allocates a new emulated DualShock 4 controller.
+// Its rumble notifications are forwarded to the emulator vibration handler,
+// and its lightbar notifications to onLightbar, which may be nil.
+func (e *Emulator) CreateDualShock4Controller(onLightbar func(color DS4LightbarColor)) (*DualShock4Controller, error) {
+	handle, _, err := procTargetDS4Alloc.Call()
+
+	if !errors.Is(err, windows.ERROR_SUCCESS) {
+		return nil, err
+	}
+
+	notificationHandler := func(client, target uintptr, largeMotor, smallMotor byte, lightbarColor unsafe.Pointer) uintptr {
+		e.onVibration(Vibration{LargeMotor: largeMotor, SmallMotor: smallMotor})
+
+		if onLightbar != nil {
+			onLightbar(lightbarColorFromArg(lightbarColor))
+		}
+
+		return 0
+	}
+	callback := windows.NewCallback(notificationHandler)
+
+	return &DualShock4Controller{e, handle, false, callback}, nil
+}
+
+// CreateDS4Controller is like CreateDualShock4Controller, but ignores
+// lightbar notifications.
+func (e *Emulator) CreateDS4Controller() (*DS4Controller, error) {
+	return e.CreateDualShock4Controller(nil)
+}
+
+// DS4Controller is an alias of DualShock4Controller.
+type DS4Controller = DualShock4Controller
+
+type DualShock4Controller struct {
+	emulator            *Emulator
+	handle              uintptr
+	connected           bool
+	notificationHandler uintptr
+}
+
+func (c *DualShock4Controller) Close() error {
+	_, _, err := procTargetFree.Call(c.handle)
+
+	return err
+}
+
+func (c *DualShock4Controller) Connect() error {
+	libErr, _, err := procTargetAdd.Call(c.emulator.handle, c.handle)
+
+	if !errors.Is(err, windows.ERROR_SUCCESS) {
+		return err
+	}
+	if err := NewVigemError(libErr); err != nil {
+		return err
+	}
+
+	libErr, _, err = procTargetDS4RegisterNotification.Call(c.emulator.handle, c.handle, c.notificationHandler)
+
+	if !errors.Is(err, windows.ERROR_SUCCESS) {
+		return err
+	}
+	if err := NewVigemError(libErr); err != nil {
+		return err
+	}
+
+	c.connected = true
+
+	return nil
+}
+
+func (c *DualShock4Controller) Disconnect() error {
+	libErr, _, err := procTargetDS4UnregisterNotification.Call(c.handle)
+
+	if !errors.Is(err, windows.ERROR_SUCCESS) {
+		return err
+	}
+	if err := NewVigemError(libErr); err != nil {
+		return err
+	}
+
+	libErr, _, err = procTargetRemove.Call(c.emulator.handle, c.handle)
+
+	if !errors.Is(err, windows.ERROR_SUCCESS) {
+		return err
+	}
+	if err := NewVigemError(libErr); err != nil {
+		return err
+	}
+
+	c.connected = false
+
+	return nil
+}
+
+func (c *DualShock4Controller) Send(report *DS4Report) error {
+	buf := report.native.Bytes()
+	libErr, _, err := procTargetDS4Update.Call(c.emulator.handle, c.handle, uintptr(unsafe.Pointer(&buf[0])))
+
+	if !errors.Is(err, windows.ERROR_SUCCESS) {
+		return err
+	}
+	if err := NewVigemError(libErr); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// DS4LightbarColor is the color of the lightbar of a DualShock 4 controller.
+type DS4LightbarColor struct {
+	Red   byte
+	Green byte
+	Blue  byte
+}
+
+func lightbarColorFromArg(arg unsafe.Pointer) DS4LightbarColor {
+	if unsafe.Sizeof(arg) == 4 {
+		// On 32-bit platforms, the DS4_LIGHTBAR_COLOR structure is passed by value.
+		value := uintptr(arg)
+
+		return DS4LightbarColor{byte(value), byte(value >> 8), byte(value >> 16)}
+	}
+
+	// On 64-bit platforms, structures whose size is not a power of two are passed
+	// by reference.
+	return *(*DS4LightbarColor)(arg)
+}
+
+// DS4NativeReport mirrors the DS4_REPORT structure expected by ViGEm.
+type DS4NativeReport struct {
+	BThumbLX  uint8
+	BThumbLY  uint8
+	BThumbRX  uint8
+	BThumbRY  uint8
+	WButtons  uint16
+	BSpecial  uint8
+	BTriggerL uint8
+	BTriggerR uint8
+}
+
+// DS4NativeReportSize is the size of the DS4_REPORT structure expected by
+// ViGEm, including its trailing padding byte.
+const DS4NativeReportSize = 10
+
+// Bytes returns the little-endian representation of the report, as expected
+// by ViGEm.
+func (r *DS4NativeReport) Bytes() [DS4NativeReportSize]byte {
+	var buf [DS4NativeReportSize]byte
+
+	buf[0] = r.BThumbLX
+	buf[1] = r.BThumbLY
+	buf[2] = r.BThumbRX
+	buf[3] = r.BThumbRY
+	binary.LittleEndian.PutUint16(buf[4:], r.WButtons)
+	buf[6] = r.BSpecial
+	buf[7] = r.BTriggerL
+	buf[8] = r.BTriggerR
+
+	return buf
+}
+
+type DS4Report struct {
+	native DS4NativeReport
+}
+
+// Bits that correspond to the DualShock 4 controller buttons.
+const (
+	DS4ButtonSquare        = 4
+	DS4ButtonCross         = 5
+	DS4ButtonCircle        = 6
+	DS4ButtonTriangle      = 7
+	DS4ButtonShoulderLeft  = 8
+	DS4ButtonShoulderRight = 9
+	DS4ButtonTriggerLeft   = 10
+	DS4ButtonTriggerRight  = 11
+	DS4ButtonShare         = 12
+	DS4ButtonOptions       = 13
+	DS4ButtonThumbLeft     = 14
+	DS4ButtonThumbRight    = 15
+)
+
+// Bits that correspond to the DualShock 4 special buttons.
+const (
+	DS4SpecialButtonPS       = 0
+	DS4SpecialButtonTouchpad = 1
+)
+
+// Values of the DualShock 4 directional pad, which is encoded as a hat switch
+// in the four lowest bits of the buttons.
+const (
+	DS4DpadNorth     = 0
+	DS4DpadNorthEast = 1
+	DS4DpadEast      = 2
+	DS4DpadSouthEast = 3
+	DS4DpadSouth     = 4
+	DS4DpadSouthWest = 5
+	DS4DpadWest      = 6
+	DS4DpadNorthWest = 7
+	DS4DpadNone      = 8
+)
+
+func NewDS4Report() DS4Report {
+	report := DS4Report{}
+	report.SetLeftThumb(0x80, 0x80)
+	report.SetRightThumb(0x80, 0x80)
+	report.SetDpad(DS4DpadNone)
+
+	return report
+}
+
+func (r *DS4Report) GetButtons() uint16 {
+	return r.native.WButtons &^ 0xF
+}
+
+func (r *DS4Report) SetButton(shiftBy int) {
+	r.native.WButtons |= 1 << shiftBy
+}
+
+func (r *DS4Report) MaybeSetButton(shiftBy int, isSet bool) {
+	if isSet {
+		r.SetButton(shiftBy)
+	}
+}
+
+func (r *DS4Report) GetDpad() byte {
+	return byte(r.native.WButtons & 0xF)
+}
+
+func (r *DS4Report) SetDpad(value byte) {
+	r.native.WButtons = (r.native.WButtons &^ 0xF) | uint16(value&0xF)
+}
+
+func (r *DS4Report) GetSpecialButtons() byte {
+	return r.native.BSpecial
+}
+
+func (r *DS4Report) SetSpecialButton(shiftBy int) {
+	r.native.BSpecial |= 1 << shiftBy
+}
+
+func (r *DS4Report) MaybeSetSpecialButton(shiftBy int, isSet bool) {
+	if isSet {
+		r.SetSpecialButton(shiftBy)
+	}
+}
+
+func (r *DS4Report) GetLeftTrigger() byte {
+	return r.native.BTriggerL
+}
+
+func (r *DS4Report) SetLeftTrigger(value byte) {
+	r.native.BTriggerL = value
+}
+
+func (r *DS4Report) GetRightTrigger() byte {
+	return r.native.BTriggerR
+}
+
+func (r *DS4Report) SetRightTrigger(value byte) {
+	r.native.BTriggerR = value
+}
+
+func (r *DS4Report) GetLeftThumb() (x, y byte) {
+	return r.native.BThumbLX, r.native.BThumbLY
+}
+
+func (r *DS4Report) SetLeftThumb(x, y byte) {
+	r.native.BThumbLX = x
+	r.native.BThumbLY = y
+}
+
+func (r *DS4Report) GetRightThumb() (x, y byte) {
+	return r.native.BThumbRX, r.native.BThumbRY
+}
+
+func (r *DS4Report) SetRightThumb(x, y byte) {
+	r.native.BThumbRX = x
+	r.native.BThumbRY = y
+}
+
+// ParseDS4Report parses a Stadia controller report into a DualShock 4 report.
+func ParseDS4Report(data []byte, report *DS4Report) error {
+	x360 := NewXbox360ControllerReport()
+
+	if err := ParseReport(data, &x360); err != nil {
+		return err
+	}
+
+	*report = DS4ReportFromXbox360(&x360)
+
+	return nil
+}
+
+// DS4ReportFromXbox360 converts an Xbox 360 report into the equivalent
+// DualShock 4 report.
+func DS4ReportFromXbox360(x360 *Xbox360ControllerReport) DS4Report {
+	report := NewDS4Report()
+	buttons := x360.GetButtons()
+	isSet := func(shiftBy int) bool {
+		return buttons&(1<<shiftBy) != 0
+	}
+
+	report.MaybeSetButton(DS4ButtonCross, isSet(Xbox360ControllerButtonA))
+	report.MaybeSetButton(DS4ButtonCircle, isSet(Xbox360ControllerButtonB))
+	report.MaybeSetButton(DS4ButtonSquare, isSet(Xbox360ControllerButtonX))
+	report.MaybeSetButton(DS4ButtonTriangle, isSet(Xbox360ControllerButtonY))
+	report.MaybeSetButton(DS4ButtonShoulderLeft, isSet(Xbox360ControllerButtonLeftShoulder))
+	report.MaybeSetButton(DS4ButtonShoulderRight, isSet(Xbox360ControllerButtonRightShoulder))
+	report.MaybeSetButton(DS4ButtonThumbLeft, isSet(Xbox360ControllerButtonLeftThumb))
+	report.MaybeSetButton(DS4ButtonThumbRight, isSet(Xbox360ControllerButtonRightThumb))
+	report.MaybeSetButton(DS4ButtonShare, isSet(Xbox360ControllerButtonBack) || x360.Capture)
+	report.MaybeSetButton(DS4ButtonOptions, isSet(Xbox360ControllerButtonStart))
+	report.MaybeSetButton(DS4ButtonTriggerLeft, x360.GetLeftTrigger() > 0)
+	report.MaybeSetButton(DS4ButtonTriggerRight, x360.GetRightTrigger() > 0)
+
+	report.MaybeSetSpecialButton(DS4SpecialButtonPS, isSet(Xbox360ControllerButtonGuide))
+	report.MaybeSetSpecialButton(DS4SpecialButtonTouchpad, x360.Assistant)
+
+	up, down := isSet(Xbox360ControllerButtonUp), isSet(Xbox360ControllerButtonDown)
+	left, right := isSet(Xbox360ControllerButtonLeft), isSet(Xbox360ControllerButtonRight)
+
+	switch {
+	case up && right:
+		report.SetDpad(DS4DpadNorthEast)
+	case down && right:
+		report.SetDpad(DS4DpadSouthEast)
+	case down && left:
+		report.SetDpad(DS4DpadSouthWest)
+	case up && left:
+		report.SetDpad(DS4DpadNorthWest)
+	case up:
+		report.SetDpad(DS4DpadNorth)
+	case right:
+		report.SetDpad(DS4DpadEast)
+	case down:
+		report.SetDpad(DS4DpadSouth)
+	case left:
+		report.SetDpad(DS4DpadWest)
+	}
+
+	// DualShock 4 axes are unsigned, and their Y axes point down.
+	lx, ly := x360.GetLeftThumb()
+	rx, ry := x360.GetRightThumb()
+
+	report.SetLeftThumb(convertDS4AxisValue(lx), convertDS4AxisValue(negateAxisValue(ly)))
+	report.SetRightThumb(convertDS4AxisValue(rx), convertDS4AxisValue(negateAxisValue(ry)))
+
+	report.SetLeftTrigger(x360.GetLeftTrigger())
+	report.SetRightTrigger(x360.GetRightTrigger())
+
+	return report
+}
+
+func convertDS4AxisValue(value int16) byte {
+	return byte((int32(value) + 0x8000) >> 8)
+}
+
+func negateAxisValue(value int16) int16 {
+	if value == -0x8000 {
+		return 0x7fff
+	}
+
+	return -value
+}
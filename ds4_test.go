@@ -0,0 +1,128 @@
+package stadiacontroller
+
+import "testing"
+
+func TestConvertDS4AxisValue(t *testing.T) {
+	for _, test := range []struct {
+		value int16
+		want  byte
+	}{
+		{-0x8000, 0x00},
+		{-0x7fff, 0x00},
+		{-0x0101, 0x7e},
+		{-1, 0x7f},
+		{0, 0x80},
+		{0xff, 0x80},
+		{0x100, 0x81},
+		{0x7fff, 0xff},
+	} {
+		if got := convertDS4AxisValue(test.value); got != test.want {
+			t.Errorf("convertDS4AxisValue(%d) = %#02x, want %#02x", test.value, got, test.want)
+		}
+	}
+}
+
+func TestDS4ReportFromXbox360Axes(t *testing.T) {
+	for _, test := range []struct {
+		name           string
+		x, y           int16
+		wantX, wantY   byte
+		trigger        byte
+		wantTriggerBit bool
+	}{
+		{"centered", 0, 0, 0x80, 0x80, 0, false},
+		// DualShock 4 Y axes point down, so up becomes 0.
+		{"up-left", -0x8000, 0x7fff, 0x00, 0x00, 0x01, true},
+		{"down-right", 0x7fff, -0x8000, 0xff, 0xff, 0xff, true},
+	} {
+		x360 := NewXbox360ControllerReport()
+		x360.SetLeftThumb(test.x, test.y)
+		x360.SetRightThumb(test.x, test.y)
+		x360.SetLeftTrigger(test.trigger)
+		x360.SetRightTrigger(test.trigger)
+
+		report := DS4ReportFromXbox360(&x360)
+
+		if x, y := report.GetLeftThumb(); x != test.wantX || y != test.wantY {
+			t.Errorf("%s: left thumb = (%#02x, %#02x), want (%#02x, %#02x)", test.name, x, y, test.wantX, test.wantY)
+		}
+		if x, y := report.GetRightThumb(); x != test.wantX || y != test.wantY {
+			t.Errorf("%s: right thumb = (%#02x, %#02x), want (%#02x, %#02x)", test.name, x, y, test.wantX, test.wantY)
+		}
+		if l, r := report.GetLeftTrigger(), report.GetRightTrigger(); l != test.trigger || r != test.trigger {
+			t.Errorf("%s: triggers = (%d, %d), want %d", test.name, l, r, test.trigger)
+		}
+
+		pressed := report.GetButtons()&(1<<DS4ButtonTriggerLeft) != 0 && report.GetButtons()&(1<<DS4ButtonTriggerRight) != 0
+
+		if pressed != test.wantTriggerBit {
+			t.Errorf("%s: trigger buttons pressed = %v, want %v", test.name, pressed, test.wantTriggerBit)
+		}
+	}
+}
+
+func TestDS4ReportFromXbox360Dpad(t *testing.T) {
+	for _, test := range []struct {
+		buttons []int
+		want    byte
+	}{
+		{nil, DS4DpadNone},
+		{[]int{Xbox360ControllerButtonUp}, DS4DpadNorth},
+		{[]int{Xbox360ControllerButtonUp, Xbox360ControllerButtonRight}, DS4DpadNorthEast},
+		{[]int{Xbox360ControllerButtonRight}, DS4DpadEast},
+		{[]int{Xbox360ControllerButtonDown, Xbox360ControllerButtonRight}, DS4DpadSouthEast},
+		{[]int{Xbox360ControllerButtonDown}, DS4DpadSouth},
+		{[]int{Xbox360ControllerButtonDown, Xbox360ControllerButtonLeft}, DS4DpadSouthWest},
+		{[]int{Xbox360ControllerButtonLeft}, DS4DpadWest},
+		{[]int{Xbox360ControllerButtonUp, Xbox360ControllerButtonLeft}, DS4DpadNorthWest},
+	} {
+		x360 := NewXbox360ControllerReport()
+
+		for _, button := range test.buttons {
+			x360.SetButton(button)
+		}
+
+		report := DS4ReportFromXbox360(&x360)
+
+		if got := report.GetDpad(); got != test.want {
+			t.Errorf("dpad of %v = %d, want %d", test.buttons, got, test.want)
+		}
+		if buttons := report.GetButtons(); buttons != 0 {
+			t.Errorf("buttons of %v = %#04x, want none", test.buttons, buttons)
+		}
+	}
+}
+
+func TestDS4ReportFromXbox360Buttons(t *testing.T) {
+	for _, test := range []struct {
+		name      string
+		set       func(r *Xbox360ControllerReport)
+		button    int
+		isSpecial bool
+	}{
+		{"A", func(r *Xbox360ControllerReport) { r.SetButton(Xbox360ControllerButtonA) }, DS4ButtonCross, false},
+		{"B", func(r *Xbox360ControllerReport) { r.SetButton(Xbox360ControllerButtonB) }, DS4ButtonCircle, false},
+		{"X", func(r *Xbox360ControllerReport) { r.SetButton(Xbox360ControllerButtonX) }, DS4ButtonSquare, false},
+		{"Y", func(r *Xbox360ControllerReport) { r.SetButton(Xbox360ControllerButtonY) }, DS4ButtonTriangle, false},
+		{"Back", func(r *Xbox360ControllerReport) { r.SetButton(Xbox360ControllerButtonBack) }, DS4ButtonShare, false},
+		{"Capture", func(r *Xbox360ControllerReport) { r.Capture = true }, DS4ButtonShare, false},
+		{"Start", func(r *Xbox360ControllerReport) { r.SetButton(Xbox360ControllerButtonStart) }, DS4ButtonOptions, false},
+		{"Guide", func(r *Xbox360ControllerReport) { r.SetButton(Xbox360ControllerButtonGuide) }, DS4SpecialButtonPS, true},
+		{"Assistant", func(r *Xbox360ControllerReport) { r.Assistant = true }, DS4SpecialButtonTouchpad, true},
+	} {
+		x360 := NewXbox360ControllerReport()
+		test.set(&x360)
+
+		report := DS4ReportFromXbox360(&x360)
+		buttons, special := report.GetButtons(), uint16(report.GetSpecialButtons())
+		want := uint16(1) << test.button
+
+		if test.isSpecial {
+			buttons, special = special, buttons
+		}
+
+		if buttons != want || special != 0 {
+			t.Errorf("%s: buttons = %#04x, special = %#02x", test.name, report.GetButtons(), report.GetSpecialButtons())
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package stadiacontroller
+
+// An Event is sent on the channel returned by StadiaController.Events. It is
+// one of ReportEvent, ButtonEvent, ConnectedEvent, DisconnectedEvent and
+// ErrorEvent.
+type Event interface {
+	isEvent()
+}
+
+// A ReportEvent is emitted every time a report is received from the
+// controller.
+type ReportEvent struct {
+	Report Xbox360ControllerReport
+
+	// Raw is the report as sent by the controller, before it was calibrated
+	// and translated.
+	Raw StadiaReport
+
+	// Calibrated is Raw calibrated with the calibration loaded by
+	// WithSavedCalibration, if any, before it was translated. Center
+	// offsets are subtracted from calibrated reports, so they must be
+	// computed from them.
+	Calibrated StadiaReport
+}
+
+// A ButtonEvent is emitted when a button is pressed or released, right after
+// the ReportEvent of the report in which the change was observed.
+type ButtonEvent struct {
+	// Button is either one of the Xbox360ControllerButton* constants, or one
+	// of the Button* constants.
+	Button  int
+	Pressed bool
+}
+
+// A ConnectedEvent is emitted when a device is opened.
+type ConnectedEvent struct {
+	Info DeviceInfo
+}
+
+// A DisconnectedEvent is emitted when the open device is lost. No ReportEvent
+// or ButtonEvent is emitted after it until the next ConnectedEvent.
+type DisconnectedEvent struct {
+	Err error
+}
+
+// An ErrorEvent is emitted when a report cannot be parsed, or when devices
+// cannot be enumerated.
+type ErrorEvent struct {
+	Err error
+}
+
+func (ReportEvent) isEvent()       {}
+func (ButtonEvent) isEvent()       {}
+func (ConnectedEvent) isEvent()    {}
+func (DisconnectedEvent) isEvent() {}
+func (ErrorEvent) isEvent()        {}
+
+// Buttons reported in a ButtonEvent in addition to the Xbox 360 controller
+// buttons. Triggers are considered pressed when their value reaches the
+// threshold given to WithTriggerThreshold.
+const (
+	ButtonLeftTrigger  = 16
+	ButtonRightTrigger = 17
+	ButtonCapture      = 18
+	ButtonAssistant    = 19
+)
+
+// DefaultTriggerThreshold is the trigger value from which a trigger is
+// considered pressed, unless WithTriggerThreshold is given.
+const DefaultTriggerThreshold = 0x80
+
+// WithTriggerThreshold sets the trigger value from which triggers are
+// considered pressed in button events.
+func WithTriggerThreshold(threshold byte) Option {
+	return func(o *options) {
+		o.triggerThreshold = threshold
+	}
+}
+
+// Events returns the channel on which the events of the controller are sent.
+// The channel is closed by Close.
+//
+// GetReport receives from the same channel, so a controller should be used
+// either through Events or through GetReport, but not both. Events are not
+// dropped: reading from the device stalls until they are received.
+func (c *StadiaController) Events() <-chan Event {
+	return c.events
+}
+
+// send sends an event, unless the controller was closed. It must be called
+// with eventsMu held.
+func (c *StadiaController) send(event Event) {
+	select {
+	case <-c.done:
+		return
+	default:
+	}
+
+	select {
+	case c.events <- event:
+	case <-c.done:
+	}
+}
+
+// sendFromDevice sends the given events, unless the given device is no longer
+// the open device.
+func (c *StadiaController) sendFromDevice(device Device, events ...Event) {
+	c.eventsMu.Lock()
+	defer c.eventsMu.Unlock()
+
+	if current, _ := c.state(); current != device {
+		return
+	}
+
+	for _, event := range events {
+		c.send(event)
+	}
+}
+
+// appendButtonEvents appends the button events that lead from the previous
+// report to the current one.
+func appendButtonEvents(events []Event, previous, current *Xbox360ControllerReport, triggerThreshold byte) []Event {
+	compare := func(button int, wasPressed, isPressed bool) {
+		if wasPressed != isPressed {
+			events = append(events, ButtonEvent{button, isPressed})
+		}
+	}
+
+	previousButtons, currentButtons := previous.GetButtons(), current.GetButtons()
+
+	for button := 0; button < 16; button++ {
+		compare(button, previousButtons&(1<<button) != 0, currentButtons&(1<<button) != 0)
+	}
+
+	compare(ButtonLeftTrigger, previous.GetLeftTrigger() >= triggerThreshold, current.GetLeftTrigger() >= triggerThreshold)
+	compare(ButtonRightTrigger, previous.GetRightTrigger() >= triggerThreshold, current.GetRightTrigger() >= triggerThreshold)
+	compare(ButtonCapture, previous.Capture, current.Capture)
+	compare(ButtonAssistant, previous.Assistant, current.Assistant)
+
+	return events
+}
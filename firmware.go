@@ -0,0 +1,111 @@
+package stadiacontroller
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ErrNotConnected is returned by methods which need an open device when no
+// controller is connected.
+var ErrNotConnected = errors.New("no controller connected")
+
+// FirmwareVersion returns the firmware version of the connected controller,
+// as reported in the release number of its USB device descriptor, e.g.
+// "1.05".
+//
+// The controller exposes no documented feature report for its version, so
+// only the release number is used.
+func (c *StadiaController) FirmwareVersion() (string, error) {
+	c.mu.Lock()
+	device, info, err := c.device, c.deviceInfo, c.err
+	c.mu.Unlock()
+
+	if device == nil {
+		if err == nil {
+			err = ErrNotConnected
+		}
+		return "", err
+	}
+	if info.VersionNumber == 0 {
+		return "", errors.New("firmware version unavailable")
+	}
+
+	return formatFirmwareVersion(info.VersionNumber), nil
+}
+
+// formatFirmwareVersion formats the given binary-coded decimal release
+// number, e.g. 0x0105 becomes "1.05".
+func formatFirmwareVersion(version uint16) string {
+	return fmt.Sprintf("%x.%02x", version>>8, version&0xff)
+}
+
+// BluetoothUpdateURL is where the update which unlocks the Bluetooth mode of
+// Stadia controllers is available.
+const BluetoothUpdateURL = "https://stadia.google.com/controller"
+
+// WithBluetoothFirmware sets the release number of the first firmware with the
+// Bluetooth update, e.g. 0x0105 for "1.05". Controllers opened with an older
+// firmware are logged as needing the update.
+//
+// The release numbers of the firmwares published by Google are not
+// documented, so no version is assumed by default, and no controller is
+// reported as outdated unless this option is given.
+func WithBluetoothFirmware(version uint16) Option {
+	return func(o *options) {
+		o.bluetoothFirmware = version
+	}
+}
+
+// needsBluetoothUpdate returns whether the firmware with the given release
+// number predates the given first firmware with the Bluetooth update. Unknown
+// versions, 0, never need it.
+func needsBluetoothUpdate(version, bluetoothFirmware uint16) bool {
+	return version != 0 && bluetoothFirmware != 0 && version < bluetoothFirmware
+}
+
+// checkFirmware logs a pointer to the Bluetooth update if the given opened
+// device needs it.
+func (c *StadiaController) checkFirmware(info *DeviceInfo) {
+	if !needsBluetoothUpdate(info.VersionNumber, c.bluetoothFirmware) {
+		return
+	}
+
+	c.logger.Printf("firmware %s of %s predates the Bluetooth update (firmware %s); it needs the Bluetooth unlock update, see %s",
+		formatFirmwareVersion(info.VersionNumber), info.Product, formatFirmwareVersion(c.bluetoothFirmware), BluetoothUpdateURL)
+}
+
+// ParseFirmwareVersion parses a firmware version formatted like
+// FirmwareVersion, e.g. "1.05", into its binary-coded decimal release number,
+// e.g. 0x0105.
+func ParseFirmwareVersion(s string) (uint16, error) {
+	major, minor := s, ""
+
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		major, minor = s[:i], s[i+1:]
+	}
+
+	if len(major) < 1 || len(major) > 2 || len(minor) != 2 || !isDecimal(major) || !isDecimal(minor) {
+		return 0, fmt.Errorf("invalid firmware version '%s', expected e.g. 1.05", s)
+	}
+
+	// Each decimal digit is a hexadecimal digit in binary-coded decimal.
+	version, err := strconv.ParseUint(major+minor, 16, 16)
+
+	if err != nil {
+		return 0, fmt.Errorf("invalid firmware version '%s': %w", s, err)
+	}
+
+	return uint16(version), nil
+}
+
+func isDecimal(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+
+	return true
+}
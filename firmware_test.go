@@ -0,0 +1,112 @@
+package stadiacontroller
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFormatFirmwareVersion(t *testing.T) {
+	for _, test := range []struct {
+		version uint16
+		want    string
+	}{
+		{0x0001, "0.01"},
+		{0x0100, "1.00"},
+		{0x0105, "1.05"},
+		{0x0210, "2.10"},
+		{0x1099, "10.99"},
+	} {
+		if got := formatFirmwareVersion(test.version); got != test.want {
+			t.Errorf("formatFirmwareVersion(%#04x) = %q, want %q", test.version, got, test.want)
+		}
+	}
+}
+
+func TestFirmwareVersion(t *testing.T) {
+	c := newStadiaController(newOptions(nil))
+	defer c.Close()
+
+	if _, err := c.FirmwareVersion(); !errors.Is(err, ErrNotConnected) {
+		t.Errorf("FirmwareVersion() without device error = %v, want ErrNotConnected", err)
+	}
+
+	// Keep the device connected by never sending its only report.
+	c.connect(DeviceInfo{VersionNumber: 0x0105}, NewMockDevice([][]byte{nil}, time.Hour))
+
+	if version, err := c.FirmwareVersion(); err != nil || version != "1.05" {
+		t.Errorf("FirmwareVersion() = %q, %v, want \"1.05\"", version, err)
+	}
+}
+
+func TestParseFirmwareVersion(t *testing.T) {
+	for _, test := range []struct {
+		s       string
+		want    uint16
+		wantErr bool
+	}{
+		{"1.05", 0x0105, false},
+		{"0.01", 0x0001, false},
+		{"10.99", 0x1099, false},
+		{"1.5", 0, true},
+		{"1", 0, true},
+		{"1.0a", 0, true},
+		{"100.00", 0, true},
+		{".05", 0, true},
+		{"", 0, true},
+	} {
+		version, err := ParseFirmwareVersion(test.s)
+
+		if (err != nil) != test.wantErr {
+			t.Errorf("ParseFirmwareVersion(%q) returned error %v, want error %v", test.s, err, test.wantErr)
+		} else if version != test.want {
+			t.Errorf("ParseFirmwareVersion(%q) = %#04x, want %#04x", test.s, version, test.want)
+		} else if !test.wantErr && formatFirmwareVersion(version) != test.s {
+			t.Errorf("formatFirmwareVersion(ParseFirmwareVersion(%q)) = %q", test.s, formatFirmwareVersion(version))
+		}
+	}
+}
+
+func TestNeedsBluetoothUpdate(t *testing.T) {
+	for _, test := range []struct {
+		version, bluetoothFirmware uint16
+		want                       bool
+	}{
+		{0x0104, 0x0105, true},
+		{0x0099, 0x0100, true},
+		{0x0105, 0x0105, false},
+		{0x0210, 0x0105, false},
+		{0x0104, 0, false},
+		{0, 0x0105, false},
+	} {
+		if got := needsBluetoothUpdate(test.version, test.bluetoothFirmware); got != test.want {
+			t.Errorf("needsBluetoothUpdate(%#04x, %#04x) = %v, want %v", test.version, test.bluetoothFirmware, got, test.want)
+		}
+	}
+}
+
+func TestCheckFirmware(t *testing.T) {
+	for _, test := range []struct {
+		version  uint16
+		wantLogs int
+	}{
+		{0x0104, 1},
+		{0x0105, 0},
+	} {
+		logger := &recordingLogger{}
+		c := newStadiaController(newOptions([]Option{WithLogger(logger), WithBluetoothFirmware(0x0105)}))
+
+		c.checkFirmware(&DeviceInfo{Product: "Stadia Controller", VersionNumber: test.version})
+		c.Close()
+
+		if len(logger.messages) != test.wantLogs {
+			t.Errorf("firmware %#04x: logged %q, want %d messages", test.version, logger.messages, test.wantLogs)
+		}
+		for _, message := range logger.messages {
+			if !strings.Contains(message, "Bluetooth unlock update") || !strings.Contains(message, BluetoothUpdateURL) {
+				t.Errorf("firmware %#04x: logged %q, want a pointer to the Bluetooth unlock update", test.version, message)
+			}
+		}
+	}
+}
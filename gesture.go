@@ -0,0 +1,144 @@
+package stadiacontroller
+
+import "time"
+
+// A Gesture is a way of pressing a button recognized by a GestureDetector.
+type Gesture int
+
+const (
+	// NoGesture is returned while a gesture is still being recognized.
+	NoGesture Gesture = iota
+
+	// ShortPress is a press released before the long press threshold, and
+	// not followed by a second press within the double press window.
+	ShortPress
+
+	// LongPress is a press held for the long press threshold. It is
+	// recognized while the button is still held.
+	LongPress
+
+	// DoublePress is a second press within the double press window after a
+	// short press. It is recognized as soon as the button is pressed again.
+	DoublePress
+)
+
+func (g Gesture) String() string {
+	switch g {
+	case ShortPress:
+		return "short-pressed"
+	case LongPress:
+		return "long-pressed"
+	case DoublePress:
+		return "double-pressed"
+	default:
+		return "none"
+	}
+}
+
+// Default thresholds of a GestureDetector.
+const (
+	DefaultLongPress   = 600 * time.Millisecond
+	DefaultDoublePress = 300 * time.Millisecond
+)
+
+// A GestureDetector recognizes short, long and double presses of a button
+// from its successive states. Since a short press can only be told apart
+// from a long press once the button is released, and from a double press
+// once the double press window is over, a ShortPress is recognized late.
+//
+// The current time is given to each call rather than read from the clock,
+// so a GestureDetector must be polled with Update at its Deadline even if
+// the button does not change.
+type GestureDetector struct {
+	// LongPress is how long a button must be held for a LongPress, or 0 to
+	// disable long presses.
+	LongPress time.Duration
+
+	// DoublePress is how soon after being released a button must be
+	// pressed again for a DoublePress, or 0 to disable double presses, in
+	// which case short presses are recognized as soon as they are released.
+	DoublePress time.Duration
+
+	state    gestureState
+	changeAt time.Time
+}
+
+type gestureState int
+
+const (
+	gestureIdle gestureState = iota
+	gestureHeld
+	gestureReleased
+	gestureDone
+)
+
+// Update returns the gesture recognized given whether the button is pressed
+// at the given time, if any.
+func (d *GestureDetector) Update(pressed bool, now time.Time) Gesture {
+	switch d.state {
+	case gestureIdle:
+		if pressed {
+			d.state, d.changeAt = gestureHeld, now
+		}
+
+	case gestureHeld:
+		if d.LongPress > 0 && now.Sub(d.changeAt) >= d.LongPress {
+			d.state = gestureDone
+
+			return LongPress
+		}
+		if pressed {
+			break
+		}
+		if d.DoublePress == 0 {
+			d.state = gestureIdle
+
+			return ShortPress
+		}
+
+		d.state, d.changeAt = gestureReleased, now
+
+	case gestureReleased:
+		if pressed && now.Sub(d.changeAt) < d.DoublePress {
+			d.state = gestureDone
+
+			return DoublePress
+		}
+		if now.Sub(d.changeAt) >= d.DoublePress {
+			// The button may have been pressed again after the window, in
+			// which case it starts a new gesture.
+			d.state = gestureIdle
+
+			if pressed {
+				d.state, d.changeAt = gestureHeld, now
+			}
+
+			return ShortPress
+		}
+
+	case gestureDone:
+		if !pressed {
+			d.state = gestureIdle
+		}
+	}
+
+	return NoGesture
+}
+
+// Deadline returns when Update must be called next for a gesture to be
+// recognized in time even if the button does not change, if it must.
+func (d *GestureDetector) Deadline() (time.Time, bool) {
+	switch {
+	case d.state == gestureHeld && d.LongPress > 0:
+		return d.changeAt.Add(d.LongPress), true
+	case d.state == gestureReleased:
+		return d.changeAt.Add(d.DoublePress), true
+	default:
+		return time.Time{}, false
+	}
+}
+
+// Reset forgets the current gesture, e.g. after the controller was lost.
+func (d *GestureDetector) Reset() {
+	d.state = gestureIdle
+}
@@ -0,0 +1,149 @@
+package stadiacontroller
+
+import (
+	"testing"
+	"time"
+)
+
+// A gestureStep advances the clock, and then updates the detector with the
+// state of the button, expecting the given gesture.
+type gestureStep struct {
+	after   time.Duration
+	pressed bool
+	want    Gesture
+}
+
+func TestGestureDetector(t *testing.T) {
+	for _, test := range []struct {
+		name                   string
+		longPress, doublePress time.Duration
+		steps                  []gestureStep
+	}{
+		{
+			name:      "short press without double press",
+			longPress: DefaultLongPress,
+			steps: []gestureStep{
+				{0, true, NoGesture},
+				{100 * time.Millisecond, true, NoGesture},
+				{100 * time.Millisecond, false, ShortPress},
+				{time.Second, false, NoGesture},
+			},
+		},
+		{
+			name:        "short press waits for the double press window",
+			longPress:   DefaultLongPress,
+			doublePress: DefaultDoublePress,
+			steps: []gestureStep{
+				{0, true, NoGesture},
+				{100 * time.Millisecond, false, NoGesture},
+				{DefaultDoublePress - time.Millisecond, false, NoGesture},
+				{time.Millisecond, false, ShortPress},
+				{time.Second, false, NoGesture},
+			},
+		},
+		{
+			name:        "long press fires while held, and not on release",
+			longPress:   DefaultLongPress,
+			doublePress: DefaultDoublePress,
+			steps: []gestureStep{
+				{0, true, NoGesture},
+				{DefaultLongPress - time.Millisecond, true, NoGesture},
+				{time.Millisecond, true, LongPress},
+				{time.Second, true, NoGesture},
+				{0, false, NoGesture},
+				{time.Second, false, NoGesture},
+			},
+		},
+		{
+			name:        "released just before the long press threshold",
+			longPress:   DefaultLongPress,
+			doublePress: DefaultDoublePress,
+			steps: []gestureStep{
+				{0, true, NoGesture},
+				{DefaultLongPress - time.Millisecond, false, NoGesture},
+				{DefaultDoublePress, false, ShortPress},
+			},
+		},
+		{
+			name:        "double press fires on the second press",
+			longPress:   DefaultLongPress,
+			doublePress: DefaultDoublePress,
+			steps: []gestureStep{
+				{0, true, NoGesture},
+				{50 * time.Millisecond, false, NoGesture},
+				{DefaultDoublePress - time.Millisecond, true, DoublePress},
+				{DefaultLongPress, true, NoGesture},
+				{0, false, NoGesture},
+				{time.Second, false, NoGesture},
+			},
+		},
+		{
+			name:        "second press after the window starts a new gesture",
+			doublePress: DefaultDoublePress,
+			steps: []gestureStep{
+				{0, true, NoGesture},
+				{50 * time.Millisecond, false, NoGesture},
+				{DefaultDoublePress, true, ShortPress},
+				{50 * time.Millisecond, false, NoGesture},
+				{50 * time.Millisecond, true, DoublePress},
+			},
+		},
+		{
+			name: "long press disabled",
+			steps: []gestureStep{
+				{0, true, NoGesture},
+				{time.Minute, true, NoGesture},
+				{0, false, ShortPress},
+			},
+		},
+	} {
+		clock := &fakeClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+		detector := GestureDetector{LongPress: test.longPress, DoublePress: test.doublePress}
+
+		for i, step := range test.steps {
+			clock.Advance(step.after)
+
+			if got := detector.Update(step.pressed, clock.Now()); got != step.want {
+				t.Errorf("%s: step %d: Update(%v) = %v, want %v", test.name, i, step.pressed, got, step.want)
+			}
+		}
+	}
+}
+
+func TestGestureDetectorDeadline(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+	detector := GestureDetector{LongPress: DefaultLongPress, DoublePress: DefaultDoublePress}
+
+	if _, ok := detector.Deadline(); ok {
+		t.Error("idle detector has a deadline")
+	}
+
+	pressedAt := clock.Now()
+	detector.Update(true, pressedAt)
+
+	if deadline, ok := detector.Deadline(); !ok || !deadline.Equal(pressedAt.Add(DefaultLongPress)) {
+		t.Errorf("held button deadline = %v, %v, want the long press threshold", deadline, ok)
+	}
+
+	clock.Advance(100 * time.Millisecond)
+	releasedAt := clock.Now()
+	detector.Update(false, releasedAt)
+
+	deadline, ok := detector.Deadline()
+
+	if !ok || !deadline.Equal(releasedAt.Add(DefaultDoublePress)) {
+		t.Errorf("released button deadline = %v, %v, want the end of the double press window", deadline, ok)
+	}
+
+	// Polling exactly at the deadline recognizes the short press.
+	if got := detector.Update(false, deadline); got != ShortPress {
+		t.Errorf("Update at the deadline = %v, want %v", got, ShortPress)
+	}
+
+	detector.Update(true, deadline)
+	detector.Reset()
+
+	if _, ok := detector.Deadline(); ok {
+		t.Error("reset detector has a deadline")
+	}
+}
@@ -1,6 +1,6 @@
 module github.com/71/stadiacontroller
 
-go 1.14
+go 1.16
 
 require (
 	golang.org/x/sys v0.0.0-20200409092240-59c9f1ba88fa
@@ -24,27 +24,164 @@ package stadiacontroller
 // OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
 // SOFTWARE.
 
-/*
-#cgo LDFLAGS: -lsetupapi -lhid
-
-#ifdef __MINGW32__
-#include <ntdef.h>
-#endif
-
-#include <windows.h>
-#include <setupapi.h>
-#include <hidsdi.h>
-*/
-import "C"
-
 import (
 	"errors"
 	"fmt"
+	"io"
+	"strings"
 	"sync"
-	"syscall"
+	"sync/atomic"
+	"time"
 	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+var (
+	setupapi = windows.NewLazySystemDLL("setupapi.dll")
+	hid      = windows.NewLazySystemDLL("hid.dll")
+
+	procSetupDiGetClassDevsW              = setupapi.NewProc("SetupDiGetClassDevsW")
+	procSetupDiDestroyDeviceInfoList      = setupapi.NewProc("SetupDiDestroyDeviceInfoList")
+	procSetupDiEnumDeviceInterfaces       = setupapi.NewProc("SetupDiEnumDeviceInterfaces")
+	procSetupDiGetDeviceInterfaceDetailW  = setupapi.NewProc("SetupDiGetDeviceInterfaceDetailW")
+	procSetupDiGetDeviceRegistryPropertyW = setupapi.NewProc("SetupDiGetDeviceRegistryPropertyW")
+
+	procHidDGetHidGuid            = hid.NewProc("HidD_GetHidGuid")
+	procHidDGetAttributes         = hid.NewProc("HidD_GetAttributes")
+	procHidDGetManufacturerString = hid.NewProc("HidD_GetManufacturerString")
+	procHidDGetProductString      = hid.NewProc("HidD_GetProductString")
+	procHidDGetSerialNumberString = hid.NewProc("HidD_GetSerialNumberString")
+	procHidDGetPreparsedData      = hid.NewProc("HidD_GetPreparsedData")
+	procHidDFreePreparsedData     = hid.NewProc("HidD_FreePreparsedData")
+	procHidDGetFeature            = hid.NewProc("HidD_GetFeature")
+	procHidDSetFeature            = hid.NewProc("HidD_SetFeature")
+	procHidDGetInputReport        = hid.NewProc("HidD_GetInputReport")
+	procHidPGetCaps               = hid.NewProc("HidP_GetCaps")
+	procHidPGetSpecificValueCaps  = hid.NewProc("HidP_GetSpecificValueCaps")
+	procHidPGetUsageValue         = hid.NewProc("HidP_GetUsageValue")
+)
+
+const (
+	digcfPresent         = 0x00000002
+	digcfDeviceInterface = 0x00000010
+
+	spdrpClass  = 0x00000007
+	spdrpDriver = 0x00000009
+
+	hidpStatusSuccess = 0x00110000
+
+	// Values of HIDP_REPORT_TYPE.
+	hidpInput   = 0
+	hidpFeature = 2
+)
+
+// spDeviceInterfaceData mirrors SP_DEVICE_INTERFACE_DATA.
+type spDeviceInterfaceData struct {
+	cbSize             uint32
+	interfaceClassGUID windows.GUID
+	flags              uint32
+	reserved           uintptr
+}
+
+// spDevinfoData mirrors SP_DEVINFO_DATA.
+type spDevinfoData struct {
+	cbSize    uint32
+	classGUID windows.GUID
+	devInst   uint32
+	reserved  uintptr
+}
+
+// spDeviceInterfaceDetailData mirrors SP_DEVICE_INTERFACE_DETAIL_DATA_W, whose
+// DevicePath is a variable-length array that starts right after cbSize.
+type spDeviceInterfaceDetailData struct {
+	cbSize     uint32
+	devicePath [1]uint16
+}
+
+// spDeviceInterfaceDetailDataSize is the value expected in
+// spDeviceInterfaceDetailData.cbSize, which is the size of the fixed part of
+// the structure. setupapi.h packs its structures on 32-bit platforms, so this
+// is not always unsafe.Sizeof(spDeviceInterfaceDetailData{}).
+var spDeviceInterfaceDetailDataSize = func() uint32 {
+	if unsafe.Sizeof(uintptr(0)) == 4 {
+		return 6
+	}
+	return 8
+}()
+
+// hiddAttributes mirrors HIDD_ATTRIBUTES.
+type hiddAttributes struct {
+	size          uint32
+	vendorID      uint16
+	productID     uint16
+	versionNumber uint16
+}
+
+// hidpCaps mirrors HIDP_CAPS.
+type hidpCaps struct {
+	usage                     uint16
+	usagePage                 uint16
+	inputReportByteLength     uint16
+	outputReportByteLength    uint16
+	featureReportByteLength   uint16
+	reserved                  [17]uint16
+	numberLinkCollectionNodes uint16
+	numberInputButtonCaps     uint16
+	numberInputValueCaps      uint16
+	numberInputDataIndices    uint16
+	numberOutputButtonCaps    uint16
+	numberOutputValueCaps     uint16
+	numberOutputDataIndices   uint16
+	numberFeatureButtonCaps   uint16
+	numberFeatureValueCaps    uint16
+	numberFeatureDataIndices  uint16
+}
+
+// hidpValueCaps mirrors HIDP_VALUE_CAPS, whose trailing union is only read
+// through its NotRange variant.
+type hidpValueCaps struct {
+	usagePage         uint16
+	reportID          uint8
+	isAlias           uint8
+	bitField          uint16
+	linkCollection    uint16
+	linkUsage         uint16
+	linkUsagePage     uint16
+	isRange           uint8
+	isStringRange     uint8
+	isDesignatorRange uint8
+	isAbsolute        uint8
+	hasNull           uint8
+	reserved          uint8
+	bitSize           uint16
+	reportCount       uint16
+	reserved2         [5]uint16
+	unitsExp          uint32
+	units             uint32
+	logicalMin        int32
+	logicalMax        int32
+	physicalMin       int32
+	physicalMax       int32
+	notRange          [8]uint16
+}
+
+// Ensure at compile time that the fixed-size structures above match the size
+// of their C counterparts.
+var (
+	_ [12]byte = [unsafe.Sizeof(hiddAttributes{})]byte{}
+	_ [64]byte = [unsafe.Sizeof(hidpCaps{})]byte{}
+	_ [72]byte = [unsafe.Sizeof(hidpValueCaps{})]byte{}
 )
 
+// ErrDeviceRemoved is returned by Device.ReadError when the device was
+// unplugged.
+var ErrDeviceRemoved = errors.New("device was removed")
+
+// ErrNoFeatureReports is returned by the feature report methods of Device
+// when the device does not declare any feature report.
+var ErrNoFeatureReports = errors.New("device has no feature reports")
+
 // DeviceInfo provides general information about a device.
 type DeviceInfo struct {
 	// Path contains a platform-specific device path which is used to identify the device.
@@ -56,17 +193,26 @@ type DeviceInfo struct {
 	Manufacturer  string
 	Product       string
 
+	// SerialNumber identifies the device across USB ports. It is empty if
+	// the device has none.
+	SerialNumber string
+
 	UsagePage uint16
 	Usage     uint16
 
-	InputReportLength  uint16
-	OutputReportLength uint16
+	InputReportLength   uint16
+	OutputReportLength  uint16
+	FeatureReportLength uint16
+
+	// ReadTimeout bounds how long the device opened by Open waits for a
+	// report before checking whether it was closed. Zero waits indefinitely.
+	ReadTimeout time.Duration
 }
 
 // A Device provides access to a HID device.
 type Device interface {
 	// Close closes the device and associated resources.
-	Close()
+	Close() error
 
 	// Write writes an output report to device. The first byte must be the
 	// report number to write, zero if the device does not use numbered reports.
@@ -75,42 +221,170 @@ type Device interface {
 	// ReadCh returns a channel that will be sent input reports from the device.
 	// If the device uses numbered reports, the first byte will be the report
 	// number.
+	//
+	// The channel is buffered; if it is full when a new report arrives, the
+	// oldest buffered report is discarded so that the newest input is never
+	// lost.
 	ReadCh() <-chan []byte
 
 	// ReadError returns the read error, if any after the channel returned from
 	// ReadCh has been closed.
 	ReadError() error
+
+	// GetFeature reads the feature report with the given number from the
+	// device into buf, which must hold at least FeatureReportLength+1 bytes.
+	// The first byte of buf is set to the report number, zero if the device
+	// does not use numbered reports. It returns the number of bytes read.
+	GetFeature(reportID byte, buf []byte) (int, error)
+
+	// GetFeatureReport is like GetFeature, but allocates a buffer of the
+	// right size.
+	GetFeatureReport(reportID byte) ([]byte, error)
+
+	// SetFeature sends a feature report to the device. Like for Write, the
+	// first byte must be the report number; shorter reports are padded with
+	// zeros.
+	SetFeature(data []byte) error
+}
+
+// A DropCounter is a Device that counts the input reports it discarded
+// because they were not read from ReadCh in time.
+type DropCounter interface {
+	DroppedReports() uint64
+}
+
+// ErrUsageNotFound is returned by UsageReader.ReadUsageValue when the report
+// descriptor of the device declares no value with the requested usage.
+var ErrUsageNotFound = errors.New("usage not found in report descriptor")
+
+// A UsageValue is a value read from a report of a device, along with the
+// logical range declared for it by the report descriptor of the device.
+type UsageValue struct {
+	Value      int32
+	LogicalMin int32
+	LogicalMax int32
+}
+
+// A UsageReader is a Device that can look up values in its reports by their
+// usage, as declared by its report descriptor, rather than by their offset.
+type UsageReader interface {
+	// ReadUsageValue reads the value with the given usage page and usage from
+	// the feature report which holds it or, failing that, from the input
+	// report which holds it.
+	ReadUsageValue(usagePage, usage uint16) (UsageValue, error)
 }
 
+// readBufferSize is the number of input reports buffered by ReadCh.
+const readBufferSize = 30
+
 type winDevice struct {
-	handle syscall.Handle
-	info   *DeviceInfo
+	// dropped is accessed atomically, and kept first for 64-bit alignment.
+	dropped uint64
+
+	// handleMu guards handle, which is reset by Close while readThread and
+	// the other methods may be using it.
+	handleMu sync.Mutex
+	handle   windows.Handle
+	info     *DeviceInfo
 
 	readSetup sync.Once
 	readCh    chan []byte
+	readErrMu sync.Mutex
 	readErr   error
-	readOl    *syscall.Overlapped
+	readOl    *windows.Overlapped
+
+	// readEventClosed is set once readThread closed the event of readOl. It
+	// is guarded by readErrMu.
+	readEventClosed bool
+}
+
+// signalReadEvent wakes up readThread, unless it already stopped.
+func (d *winDevice) signalReadEvent() {
+	d.readErrMu.Lock()
+	defer d.readErrMu.Unlock()
+
+	if !d.readEventClosed {
+		windows.SetEvent(d.readOl.HEvent)
+	}
 }
 
-// returns the casted handle of the device
-func (d *winDevice) h() C.HANDLE {
-	return (C.HANDLE)((unsafe.Pointer)(d.handle))
+// closeReadEvent closes the event of readOl, once readThread stopped.
+func (d *winDevice) closeReadEvent() {
+	d.readErrMu.Lock()
+	defer d.readErrMu.Unlock()
+
+	windows.CloseHandle(d.readOl.HEvent)
+	d.readEventClosed = true
+}
+
+// setReadErr sets the read error, unless one was already set.
+func (d *winDevice) setReadErr(err error) {
+	d.readErrMu.Lock()
+	defer d.readErrMu.Unlock()
+
+	if d.readErr == nil {
+		d.readErr = err
+	}
+}
+
+// hasReadErr returns whether a read error was set, e.g. by Close.
+func (d *winDevice) hasReadErr() bool {
+	d.readErrMu.Lock()
+	defer d.readErrMu.Unlock()
+
+	return d.readErr != nil
+}
+
+// getHandle returns the handle of the device, which is invalid once the
+// device is closed.
+func (d *winDevice) getHandle() windows.Handle {
+	d.handleMu.Lock()
+	defer d.handleMu.Unlock()
+
+	return d.handle
 }
 
 // checks if the handle of the device is valid
 func (d *winDevice) isValid() bool {
-	return d.handle != syscall.InvalidHandle
+	return d.getHandle() != windows.InvalidHandle
 }
 
-func (d *winDevice) Close() {
-	// cancel any pending reads and unblock read loop
-	d.readErr = errors.New("hid: device closed")
-	C.CancelIo(d.h())
-	C.SetEvent(C.HANDLE(unsafe.Pointer(d.readOl.HEvent)))
-	syscall.CloseHandle(d.readOl.HEvent)
+func (d *winDevice) Close() error {
+	d.handleMu.Lock()
+	handle := d.handle
+	d.handle = windows.InvalidHandle
+	d.handleMu.Unlock()
+
+	if handle == windows.InvalidHandle {
+		return nil
+	}
+
+	// cancel any pending reads and unblock read loop. CancelIo only cancels
+	// the I/O issued by the calling thread, which never includes the read
+	// issued by readThread.
+	d.setReadErr(errors.New("hid: device closed"))
+	windows.CancelIoEx(handle, nil)
+
+	if d.readOl != nil {
+		// The read event belongs to readThread once it started, which closes
+		// it when it stops; otherwise, prevent it from starting and close it
+		// here, so that it is closed exactly once.
+		started := true
+
+		d.readSetup.Do(func() {
+			started = false
+			d.readCh = make(chan []byte)
+			close(d.readCh)
+		})
+
+		if started {
+			d.signalReadEvent()
+		} else {
+			windows.CloseHandle(d.readOl.HEvent)
+		}
+	}
 
-	syscall.CloseHandle(d.handle)
-	d.handle = syscall.InvalidHandle
+	return windows.CloseHandle(handle)
 }
 
 func (d *winDevice) Write(data []byte) error {
@@ -122,19 +396,26 @@ func (d *winDevice) Write(data []byte) error {
 		data = buf
 	}
 
-	ol := new(syscall.Overlapped)
-	if err := syscall.WriteFile(d.handle, data, nil, ol); err != nil {
+	event, err := windows.CreateEvent(nil, 1, 0, nil)
+	if err != nil {
+		return err
+	}
+	defer windows.CloseHandle(event)
+
+	handle := d.getHandle()
+	ol := &windows.Overlapped{HEvent: event}
+	if err := windows.WriteFile(handle, data, nil, ol); err != nil {
 		// IO Pending is ok we simply wait for it to finish a few lines below
 		// all other errors should be reported.
-		if err != syscall.ERROR_IO_PENDING {
+		if err != windows.ERROR_IO_PENDING {
 			return err
 		}
 	}
 
 	// now wait for the overlapped device access to finish.
-	var written C.DWORD
-	if C.GetOverlappedResult(d.h(), (*C.OVERLAPPED)((unsafe.Pointer)(ol)), &written, C.TRUE) == 0 {
-		return syscall.GetLastError()
+	var written uint32
+	if err := windows.GetOverlappedResult(handle, ol, &written, true); err != nil {
+		return err
 	}
 
 	if int(written) != outSize {
@@ -143,93 +424,109 @@ func (d *winDevice) Write(data []byte) error {
 	return nil
 }
 
-type callCFn func(buf unsafe.Pointer, bufSize *C.DWORD) unsafe.Pointer
-
 // simple helper function for this windows
 // "call a function twice to get the amount of space that needs to be allocated" stuff
-func getCString(fnCall callCFn) string {
-	var requiredSize C.DWORD
+func getUTF16String(fnCall func(buf []uint16, requiredSize *uint32) bool) string {
+	var requiredSize uint32
 	fnCall(nil, &requiredSize)
 	if requiredSize <= 0 {
 		return ""
 	}
 
-	buffer := C.malloc((C.size_t)(requiredSize))
-	defer C.free(buffer)
-
-	strPt := fnCall(buffer, &requiredSize)
+	buffer := make([]uint16, (requiredSize+1)/2)
+	if !fnCall(buffer, &requiredSize) {
+		return ""
+	}
 
-	return C.GoString((*C.char)(strPt))
+	return windows.UTF16ToString(buffer)
 }
 
 func openDevice(info *DeviceInfo, enumerate bool) (*winDevice, error) {
-	access := uint32(syscall.GENERIC_WRITE | syscall.GENERIC_READ)
-	shareMode := uint32(syscall.FILE_SHARE_READ | syscall.FILE_SHARE_WRITE)
+	access := uint32(windows.GENERIC_WRITE | windows.GENERIC_READ)
+	shareMode := uint32(windows.FILE_SHARE_READ | windows.FILE_SHARE_WRITE)
 	if enumerate {
 		// if we just need a handle to get the device properties
 		// we should not claim exclusive access on the device
 		access = 0
 	}
-	pPtr, err := syscall.UTF16PtrFromString(info.Path)
+	pPtr, err := windows.UTF16PtrFromString(info.Path)
 	if err != nil {
 		return nil, err
 	}
 
-	hFile, err := syscall.CreateFile(pPtr, access, shareMode, nil, syscall.OPEN_EXISTING, syscall.FILE_FLAG_OVERLAPPED, 0)
+	hFile, err := windows.CreateFile(pPtr, access, shareMode, nil, windows.OPEN_EXISTING, windows.FILE_FLAG_OVERLAPPED, 0)
+	if err != nil {
+		return nil, err
+	}
+	if enumerate {
+		// devices opened to get their properties are never read from, so
+		// they do not need a read event
+		return &winDevice{handle: hFile, info: info}, nil
+	}
+	event, err := windows.CreateEvent(nil, 0, 0, nil)
 	if err != nil {
+		windows.CloseHandle(hFile)
 		return nil, err
 	}
 	return &winDevice{
 		handle: hFile,
 		info:   info,
-		readOl: &syscall.Overlapped{
-			HEvent: syscall.Handle(C.CreateEvent(nil, C.FALSE, C.FALSE, nil)),
+		readOl: &windows.Overlapped{
+			HEvent: event,
 		},
 	}, nil
 }
 
-func getDeviceDetails(deviceInfoSet C.HDEVINFO, deviceInterfaceData *C.SP_DEVICE_INTERFACE_DATA) *DeviceInfo {
-	devicePath := getCString(func(buffer unsafe.Pointer, size *C.DWORD) unsafe.Pointer {
-		interfaceDetailData := (*C.SP_DEVICE_INTERFACE_DETAIL_DATA_A)(buffer)
-		if interfaceDetailData != nil {
-			interfaceDetailData.cbSize = C.DWORD(unsafe.Sizeof(interfaceDetailData))
+func getDeviceDetails(deviceInfoSet windows.Handle, deviceInterfaceData *spDeviceInterfaceData) *DeviceInfo {
+	var devinfoData spDevinfoData
+	devinfoData.cbSize = uint32(unsafe.Sizeof(devinfoData))
+
+	devicePath := getUTF16String(func(buffer []uint16, size *uint32) bool {
+		var interfaceDetailData *spDeviceInterfaceDetailData
+		if buffer != nil {
+			interfaceDetailData = (*spDeviceInterfaceDetailData)(unsafe.Pointer(&buffer[0]))
+			interfaceDetailData.cbSize = spDeviceInterfaceDetailDataSize
 		}
-		C.SetupDiGetDeviceInterfaceDetailA(deviceInfoSet, deviceInterfaceData, interfaceDetailData, *size, size, nil)
-		if interfaceDetailData == nil {
-			return nil
+		r, _, _ := procSetupDiGetDeviceInterfaceDetailW.Call(
+			uintptr(deviceInfoSet),
+			uintptr(unsafe.Pointer(deviceInterfaceData)),
+			uintptr(unsafe.Pointer(interfaceDetailData)),
+			uintptr(*size),
+			uintptr(unsafe.Pointer(size)),
+			uintptr(unsafe.Pointer(&devinfoData)),
+		)
+		if interfaceDetailData == nil || r == 0 {
+			return false
 		}
-		return (unsafe.Pointer)(&interfaceDetailData.DevicePath[0])
+		// Skip cbSize, leaving only the device path.
+		copy(buffer, buffer[unsafe.Offsetof(interfaceDetailData.devicePath)/2:])
+		return true
 	})
 	if devicePath == "" {
 		return nil
 	}
 
 	// Make sure this device is of Setup Class "HIDClass" and has a driver bound to it.
-	var i C.DWORD
-	var devinfoData C.SP_DEVINFO_DATA
-	devinfoData.cbSize = C.DWORD(unsafe.Sizeof(devinfoData))
-	isHID := false
-	for i = 0; ; i++ {
-		if res := C.SetupDiEnumDeviceInfo(deviceInfoSet, i, &devinfoData); res == 0 {
-			break
-		}
-
-		classStr := getCString(func(buffer unsafe.Pointer, size *C.DWORD) unsafe.Pointer {
-			C.SetupDiGetDeviceRegistryPropertyA(deviceInfoSet, &devinfoData, C.SPDRP_CLASS, nil, (*C.BYTE)(buffer), *size, size)
-			return buffer
+	getProperty := func(property uint32) string {
+		return getUTF16String(func(buffer []uint16, size *uint32) bool {
+			var ptr *uint16
+			if buffer != nil {
+				ptr = &buffer[0]
+			}
+			r, _, _ := procSetupDiGetDeviceRegistryPropertyW.Call(
+				uintptr(deviceInfoSet),
+				uintptr(unsafe.Pointer(&devinfoData)),
+				uintptr(property),
+				0,
+				uintptr(unsafe.Pointer(ptr)),
+				uintptr(*size),
+				uintptr(unsafe.Pointer(size)),
+			)
+			return r != 0
 		})
-
-		if classStr == "HIDClass" {
-			driverName := getCString(func(buffer unsafe.Pointer, size *C.DWORD) unsafe.Pointer {
-				C.SetupDiGetDeviceRegistryPropertyA(deviceInfoSet, &devinfoData, C.SPDRP_DRIVER, nil, (*C.BYTE)(buffer), *size, size)
-				return buffer
-			})
-			isHID = driverName != ""
-			break
-		}
 	}
 
-	if !isHID {
+	if getProperty(spdrpClass) != "HIDClass" || getProperty(spdrpDriver) == "" {
 		return nil
 	}
 	d, _ := ByPath(devicePath)
@@ -248,54 +545,87 @@ func ByPath(devicePath string) (*DeviceInfo, error) {
 		return nil, errors.New("Failed to open device")
 	}
 
-	var attrs C.HIDD_ATTRIBUTES
-	attrs.Size = C.DWORD(unsafe.Sizeof(attrs))
-	C.HidD_GetAttributes(dev.h(), &attrs)
+	var attrs hiddAttributes
+	attrs.size = uint32(unsafe.Sizeof(attrs))
+	procHidDGetAttributes.Call(uintptr(dev.handle), uintptr(unsafe.Pointer(&attrs)))
 
-	devInfo.VendorID = uint16(attrs.VendorID)
-	devInfo.ProductID = uint16(attrs.ProductID)
-	devInfo.VersionNumber = uint16(attrs.VersionNumber)
+	devInfo.VendorID = attrs.vendorID
+	devInfo.ProductID = attrs.productID
+	devInfo.VersionNumber = attrs.versionNumber
 
 	const bufLen = 256
 	buff := make([]uint16, bufLen)
 
-	C.HidD_GetManufacturerString(dev.h(), (C.PVOID)(&buff[0]), bufLen)
-	devInfo.Manufacturer = syscall.UTF16ToString(buff)
+	procHidDGetManufacturerString.Call(uintptr(dev.handle), uintptr(unsafe.Pointer(&buff[0])), bufLen)
+	devInfo.Manufacturer = windows.UTF16ToString(buff)
+
+	procHidDGetProductString.Call(uintptr(dev.handle), uintptr(unsafe.Pointer(&buff[0])), bufLen)
+	devInfo.Product = windows.UTF16ToString(buff)
 
-	C.HidD_GetProductString(dev.h(), (C.PVOID)(&buff[0]), bufLen)
-	devInfo.Product = syscall.UTF16ToString(buff)
+	// Not all devices have a serial number, in which case this fails.
+	if r, _, _ := procHidDGetSerialNumberString.Call(uintptr(dev.handle), uintptr(unsafe.Pointer(&buff[0])), bufLen); r != 0 {
+		devInfo.SerialNumber = windows.UTF16ToString(buff)
+	}
+
+	var preparsedData uintptr
+	if r, _, _ := procHidDGetPreparsedData.Call(uintptr(dev.handle), uintptr(unsafe.Pointer(&preparsedData))); r != 0 {
+		var caps hidpCaps
 
-	var preparsedData C.PHIDP_PREPARSED_DATA
-	if C.HidD_GetPreparsedData(dev.h(), &preparsedData) != 0 {
-		var caps C.HIDP_CAPS
+		if r, _, _ := procHidPGetCaps.Call(preparsedData, uintptr(unsafe.Pointer(&caps))); r == hidpStatusSuccess {
+			devInfo.UsagePage = caps.usagePage
+			devInfo.Usage = caps.usage
+			devInfo.InputReportLength = caps.inputReportByteLength - 1
+			devInfo.OutputReportLength = caps.outputReportByteLength - 1
 
-		if C.HidP_GetCaps(preparsedData, &caps) == C.HIDP_STATUS_SUCCESS {
-			devInfo.UsagePage = uint16(caps.UsagePage)
-			devInfo.Usage = uint16(caps.Usage)
-			devInfo.InputReportLength = uint16(caps.InputReportByteLength - 1)
-			devInfo.OutputReportLength = uint16(caps.OutputReportByteLength - 1)
+			if caps.featureReportByteLength > 0 {
+				devInfo.FeatureReportLength = caps.featureReportByteLength - 1
+			}
 		}
 
-		C.HidD_FreePreparsedData(preparsedData)
+		procHidDFreePreparsedData.Call(preparsedData)
 	}
 
 	return devInfo, nil
 }
 
+// IsBluetooth returns whether the device is connected over Bluetooth, which
+// Windows shows in the enumerator of its interface path.
+func (di *DeviceInfo) IsBluetooth() bool {
+	path := strings.ToLower(di.Path)
+
+	return strings.Contains(path, "bthenum") || strings.Contains(path, "bthle") ||
+		strings.Contains(path, "{00001124-0000-1000-8000-00805f9b34fb}")
+}
+
+// hidGUID returns the GUID of the HID device interface class.
+func hidGUID() windows.GUID {
+	var guid windows.GUID
+	procHidDGetHidGuid.Call(uintptr(unsafe.Pointer(&guid)))
+	return guid
+}
+
 // Devices returns all HID devices which are connected to the system.
 func Devices() ([]*DeviceInfo, error) {
 	var result []*DeviceInfo
-	var InterfaceClassGUID C.GUID
-	C.HidD_GetHidGuid(&InterfaceClassGUID)
-	deviceInfoSet := C.SetupDiGetClassDevsA(&InterfaceClassGUID, nil, nil, C.DIGCF_PRESENT|C.DIGCF_DEVICEINTERFACE)
-	defer C.SetupDiDestroyDeviceInfoList(deviceInfoSet)
-
-	var deviceIdx C.DWORD = 0
-	var deviceInterfaceData C.SP_DEVICE_INTERFACE_DATA
-	deviceInterfaceData.cbSize = C.DWORD(unsafe.Sizeof(deviceInterfaceData))
-
-	for ; ; deviceIdx++ {
-		res := C.SetupDiEnumDeviceInterfaces(deviceInfoSet, nil, &InterfaceClassGUID, deviceIdx, &deviceInterfaceData)
+	interfaceClassGUID := hidGUID()
+	r, _, err := procSetupDiGetClassDevsW.Call(uintptr(unsafe.Pointer(&interfaceClassGUID)), 0, 0, digcfPresent|digcfDeviceInterface)
+	deviceInfoSet := windows.Handle(r)
+	if deviceInfoSet == windows.InvalidHandle {
+		return nil, err
+	}
+	defer procSetupDiDestroyDeviceInfoList.Call(uintptr(deviceInfoSet))
+
+	var deviceInterfaceData spDeviceInterfaceData
+	deviceInterfaceData.cbSize = uint32(unsafe.Sizeof(deviceInterfaceData))
+
+	for deviceIdx := 0; ; deviceIdx++ {
+		res, _, _ := procSetupDiEnumDeviceInterfaces.Call(
+			uintptr(deviceInfoSet),
+			0,
+			uintptr(unsafe.Pointer(&interfaceClassGUID)),
+			uintptr(deviceIdx),
+			uintptr(unsafe.Pointer(&deviceInterfaceData)),
+		)
 		if res == 0 {
 			break
 		}
@@ -315,76 +645,267 @@ func (di *DeviceInfo) Open() (Device, error) {
 	}
 	if !d.isValid() {
 		d.Close()
-		err := syscall.GetLastError()
-		if err == nil {
-			err = errors.New("unable to open device")
-		}
-		return nil, err
+		return nil, errors.New("unable to open device")
 	}
 	return d, nil
 }
 
 func (d *winDevice) ReadCh() <-chan []byte {
 	d.readSetup.Do(func() {
-		d.readCh = make(chan []byte, 30)
+		d.readCh = make(chan []byte, readBufferSize)
 		go d.readThread()
 	})
 	return d.readCh
 }
 
+// DroppedReports returns the number of input reports that were discarded
+// because the channel returned by ReadCh was full.
+func (d *winDevice) DroppedReports() uint64 {
+	return atomic.LoadUint64(&d.dropped)
+}
+
+func (d *winDevice) GetFeature(reportID byte, buf []byte) (int, error) {
+	size, err := featureReportSize(d.info.FeatureReportLength)
+
+	if err != nil {
+		return 0, err
+	}
+	if len(buf) < size {
+		return 0, fmt.Errorf("hid: feature report buffer of %d bytes is shorter than %d bytes: %w", len(buf), size, io.ErrShortBuffer)
+	}
+
+	buf[0] = reportID
+
+	if r, _, err := procHidDGetFeature.Call(uintptr(d.getHandle()), uintptr(unsafe.Pointer(&buf[0])), uintptr(size)); r == 0 {
+		return 0, fmt.Errorf("hid: unable to get feature report %#02x: %w", reportID, err)
+	}
+
+	return size, nil
+}
+
+// featureReportSize returns the size of the buffers given to HidD_GetFeature
+// and HidD_SetFeature for a device whose feature reports hold the given
+// number of bytes after the report number.
+func featureReportSize(length uint16) (int, error) {
+	if length == 0 {
+		return 0, ErrNoFeatureReports
+	}
+
+	return int(length) + 1, nil
+}
+
+// featureReportBuffer returns the given feature report padded with zeroes to
+// the given size, since HidD_SetFeature requires a buffer of the full size.
+func featureReportBuffer(data []byte, size int) ([]byte, error) {
+	if len(data) == 0 || len(data) > size {
+		return nil, fmt.Errorf("hid: feature report must hold between 1 and %d bytes, got %d", size, len(data))
+	}
+
+	buf := make([]byte, size)
+	copy(buf, data)
+
+	return buf, nil
+}
+
+func (d *winDevice) GetFeatureReport(reportID byte) ([]byte, error) {
+	buf := make([]byte, d.info.FeatureReportLength+1)
+	n, err := d.GetFeature(reportID, buf)
+
+	return buf[:n], err
+}
+
+func (d *winDevice) SetFeature(data []byte) error {
+	size, err := featureReportSize(d.info.FeatureReportLength)
+
+	if err != nil {
+		return err
+	}
+
+	buf, err := featureReportBuffer(data, size)
+
+	if err != nil {
+		return err
+	}
+
+	if r, _, err := procHidDSetFeature.Call(uintptr(d.getHandle()), uintptr(unsafe.Pointer(&buf[0])), uintptr(size)); r == 0 {
+		return fmt.Errorf("hid: unable to set feature report %#02x: %w", buf[0], err)
+	}
+
+	return nil
+}
+
+func (d *winDevice) ReadUsageValue(usagePage, usage uint16) (UsageValue, error) {
+	handle := d.getHandle()
+
+	var preparsedData uintptr
+	if r, _, err := procHidDGetPreparsedData.Call(uintptr(handle), uintptr(unsafe.Pointer(&preparsedData))); r == 0 {
+		return UsageValue{}, fmt.Errorf("hid: unable to get report descriptor: %w", err)
+	}
+	defer procHidDFreePreparsedData.Call(preparsedData)
+
+	for _, reportType := range []uintptr{hidpFeature, hidpInput} {
+		var caps hidpValueCaps
+		capsLength := uint16(1)
+
+		r, _, _ := procHidPGetSpecificValueCaps.Call(
+			reportType,
+			uintptr(usagePage),
+			0,
+			uintptr(usage),
+			uintptr(unsafe.Pointer(&caps)),
+			uintptr(unsafe.Pointer(&capsLength)),
+			preparsedData,
+		)
+		if r != hidpStatusSuccess || capsLength == 0 {
+			continue
+		}
+
+		var report []byte
+
+		if reportType == hidpFeature {
+			buf := make([]byte, d.info.FeatureReportLength+1)
+			n, err := d.GetFeature(caps.reportID, buf)
+
+			if err != nil {
+				return UsageValue{}, err
+			}
+			report = buf[:n]
+		} else {
+			report = make([]byte, d.info.InputReportLength+1)
+			report[0] = caps.reportID
+
+			if r, _, err := procHidDGetInputReport.Call(uintptr(handle), uintptr(unsafe.Pointer(&report[0])), uintptr(len(report))); r == 0 {
+				return UsageValue{}, fmt.Errorf("hid: unable to get input report %#02x: %w", caps.reportID, err)
+			}
+		}
+
+		var value uint32
+
+		if r, _, _ := procHidPGetUsageValue.Call(
+			reportType,
+			uintptr(usagePage),
+			0,
+			uintptr(usage),
+			uintptr(unsafe.Pointer(&value)),
+			preparsedData,
+			uintptr(unsafe.Pointer(&report[0])),
+			uintptr(len(report)),
+		); r != hidpStatusSuccess {
+			return UsageValue{}, fmt.Errorf("hid: unable to read usage %#02x:%#02x: status %#08x", usagePage, usage, r)
+		}
+
+		result := UsageValue{Value: int32(value), LogicalMin: caps.logicalMin, LogicalMax: caps.logicalMax}
+
+		// HidP_GetUsageValue does not sign-extend values whose logical range
+		// is signed.
+		if caps.logicalMin < 0 && caps.bitSize > 0 && caps.bitSize < 32 {
+			shift := 32 - uint(caps.bitSize)
+			result.Value = int32(value<<shift) >> shift
+		}
+
+		return result, nil
+	}
+
+	return UsageValue{}, ErrUsageNotFound
+}
+
 func (d *winDevice) ReadError() error {
+	d.readErrMu.Lock()
+	defer d.readErrMu.Unlock()
+
 	return d.readErr
 }
 
+// pushReport sends the given report to readCh. If readCh is full, the oldest
+// report is dropped to make room for it. This is only called from readThread,
+// so no other goroutine can fill the channel between the two steps.
+func (d *winDevice) pushReport(report []byte) {
+	for {
+		select {
+		case d.readCh <- report:
+			return
+		default:
+		}
+
+		select {
+		case <-d.readCh:
+			atomic.AddUint64(&d.dropped, 1)
+		default:
+		}
+	}
+}
+
 func (d *winDevice) readThread() {
 	defer close(d.readCh)
+	defer d.closeReadEvent()
+
+	timeout := uint32(windows.INFINITE)
+	if d.info.ReadTimeout > 0 {
+		timeout = uint32((d.info.ReadTimeout + time.Millisecond - 1) / time.Millisecond)
+	}
 
 	for {
+		// Snapshot the handle, which Close resets concurrently.
+		handle := d.getHandle()
+		if handle == windows.InvalidHandle {
+			return
+		}
+
 		buf := make([]byte, d.info.InputReportLength+1)
-		C.ResetEvent(C.HANDLE(unsafe.Pointer(d.readOl.HEvent)))
+		windows.ResetEvent(d.readOl.HEvent)
 
-		if err := syscall.ReadFile(d.handle, buf, nil, d.readOl); err != nil {
-			if err != syscall.ERROR_IO_PENDING {
-				if d.readErr == nil {
-					d.readErr = err
-				}
+		if err := windows.ReadFile(handle, buf, nil, d.readOl); err != nil {
+			if err == windows.ERROR_DEVICE_NOT_CONNECTED {
+				d.setReadErr(fmt.Errorf("hid: %w", ErrDeviceRemoved))
+				return
+			}
+			if err != windows.ERROR_IO_PENDING {
+				d.setReadErr(err)
 				return
 			}
 		}
 
-		// Wait for the read to finish
-		res := C.WaitForSingleObject(C.HANDLE(unsafe.Pointer(d.readOl.HEvent)), C.INFINITE)
-		if res != C.WAIT_OBJECT_0 {
-			if d.readErr == nil {
-				d.readErr = fmt.Errorf("hid: unexpected read wait state %d", res)
-			}
+		// Wait for the read to finish, checking whether the device was closed
+		// after each timeout
+		res, err := windows.WaitForSingleObject(d.readOl.HEvent, timeout)
+		for res == uint32(windows.WAIT_TIMEOUT) && !d.hasReadErr() {
+			res, err = windows.WaitForSingleObject(d.readOl.HEvent, timeout)
+		}
+		if res == uint32(windows.WAIT_TIMEOUT) {
+			return
+		}
+		if res != windows.WAIT_OBJECT_0 {
+			d.setReadErr(fmt.Errorf("hid: unexpected read wait state %d: %v", res, err))
 			return
 		}
 
-		var n C.DWORD
-		if r := C.GetOverlappedResult(d.h(), (*C.OVERLAPPED)((unsafe.Pointer)(d.readOl)), &n, C.TRUE); r == 0 {
-			if d.readErr == nil {
-				d.readErr = fmt.Errorf("hid: unexpected read result state %d", r)
+		var n uint32
+		if err := windows.GetOverlappedResult(handle, d.readOl, &n, true); err != nil {
+			if err == windows.ERROR_DEVICE_NOT_CONNECTED {
+				d.setReadErr(fmt.Errorf("hid: %w", ErrDeviceRemoved))
+			} else {
+				d.setReadErr(fmt.Errorf("hid: unexpected read result state: %w", err))
 			}
 			return
 		}
 		if n == 0 {
-			if d.readErr == nil {
-				d.readErr = errors.New("hid: zero byte read")
-			}
+			d.setReadErr(errors.New("hid: zero byte read"))
 			return
 		}
 
-		if buf[0] == 0 {
-			// Report numbers are not being used, so remove zero to match other platforms
-			buf = buf[1:]
-			n--
-		}
+		d.pushReport(trimReportNumber(buf[:int(n)]))
+	}
 
-		select {
-		case d.readCh <- buf[:int(n)]:
-		default:
-		}
+}
+
+// trimReportNumber returns the given report read from a device without its
+// first byte if it is zero, which Windows prepends when report numbers are
+// not being used, to match other platforms. Numbered reports, like those of
+// Stadia controllers, keep their report number as their first byte.
+func trimReportNumber(report []byte) []byte {
+	if len(report) > 0 && report[0] == 0 {
+		return report[1:]
 	}
 
+	return report
 }
@@ -0,0 +1,273 @@
+package stadiacontroller
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// is64Bit is whether pointers take 8 bytes, which changes the layout of the
+// setupapi structures.
+var is64Bit = unsafe.Sizeof(uintptr(0)) == 8
+
+func TestSetupapiStructLayouts(t *testing.T) {
+	var detail spDeviceInterfaceDetailData
+
+	// SetupDiGetDeviceInterfaceDetailW fails with ERROR_INVALID_USER_BUFFER
+	// unless cbSize is exactly the size of the fixed part of the C structure,
+	// which is packed on 386.
+	wantDetailSize := uint32(8)
+
+	if !is64Bit {
+		wantDetailSize = 6
+	}
+
+	if spDeviceInterfaceDetailDataSize != wantDetailSize {
+		t.Errorf("%s: spDeviceInterfaceDetailDataSize = %d, want %d", runtime.GOARCH, spDeviceInterfaceDetailDataSize, wantDetailSize)
+	}
+	if offset := unsafe.Offsetof(detail.devicePath); offset != 4 {
+		t.Errorf("offset of DevicePath = %d, want 4", offset)
+	}
+
+	// SP_DEVICE_INTERFACE_DATA and SP_DEVINFO_DATA end with a ULONG_PTR.
+	wantDataSize := uintptr(32)
+
+	if !is64Bit {
+		wantDataSize = 28
+	}
+
+	if size := unsafe.Sizeof(spDeviceInterfaceData{}); size != wantDataSize {
+		t.Errorf("%s: size of SP_DEVICE_INTERFACE_DATA = %d, want %d", runtime.GOARCH, size, wantDataSize)
+	}
+	if size := unsafe.Sizeof(spDevinfoData{}); size != wantDataSize {
+		t.Errorf("%s: size of SP_DEVINFO_DATA = %d, want %d", runtime.GOARCH, size, wantDataSize)
+	}
+}
+
+func TestHidStructLayouts(t *testing.T) {
+	var attrs hiddAttributes
+
+	// HidD_GetAttributes fails unless Size is the size of HIDD_ATTRIBUTES.
+	if size := unsafe.Sizeof(attrs); size != 12 {
+		t.Errorf("size of HIDD_ATTRIBUTES = %d, want 12", size)
+	}
+
+	for _, field := range []struct {
+		name   string
+		offset uintptr
+		want   uintptr
+	}{
+		{"Size", unsafe.Offsetof(attrs.size), 0},
+		{"VendorID", unsafe.Offsetof(attrs.vendorID), 4},
+		{"ProductID", unsafe.Offsetof(attrs.productID), 6},
+		{"VersionNumber", unsafe.Offsetof(attrs.versionNumber), 8},
+	} {
+		if field.offset != field.want {
+			t.Errorf("offset of HIDD_ATTRIBUTES.%s = %d, want %d", field.name, field.offset, field.want)
+		}
+	}
+
+	var caps hidpCaps
+
+	if size := unsafe.Sizeof(caps); size != 64 {
+		t.Errorf("size of HIDP_CAPS = %d, want 64", size)
+	}
+	if offset := unsafe.Offsetof(caps.numberLinkCollectionNodes); offset != 44 {
+		t.Errorf("offset of HIDP_CAPS.NumberLinkCollectionNodes = %d, want 44", offset)
+	}
+}
+
+func TestHidpValueCapsLayout(t *testing.T) {
+	var caps hidpValueCaps
+
+	if size := unsafe.Sizeof(caps); size != 72 {
+		t.Errorf("size of HIDP_VALUE_CAPS = %d, want 72", size)
+	}
+
+	for _, field := range []struct {
+		name   string
+		offset uintptr
+		want   uintptr
+	}{
+		{"ReportID", unsafe.Offsetof(caps.reportID), 2},
+		{"BitSize", unsafe.Offsetof(caps.bitSize), 18},
+		{"UnitsExp", unsafe.Offsetof(caps.unitsExp), 32},
+		{"LogicalMin", unsafe.Offsetof(caps.logicalMin), 40},
+		{"LogicalMax", unsafe.Offsetof(caps.logicalMax), 44},
+		{"NotRange", unsafe.Offsetof(caps.notRange), 56},
+	} {
+		if field.offset != field.want {
+			t.Errorf("offset of HIDP_VALUE_CAPS.%s = %d, want %d", field.name, field.offset, field.want)
+		}
+	}
+}
+
+func TestDeviceInfoIsBluetooth(t *testing.T) {
+	for _, test := range []struct {
+		path string
+		want bool
+	}{
+		{`\\?\hid#vid_18d1&pid_9400&mi_00#7&1a2b3c4d&0&0000#{4d1e55b2-f16f-11cf-88cb-001111000030}`, false},
+		{`\\?\HID#{00001124-0000-1000-8000-00805F9B34FB}_VID&000218D1_PID&9400#8&2b3c4d5e&0&0000#{4d1e55b2-f16f-11cf-88cb-001111000030}`, true},
+		{`\\?\hid#{00001812-0000-1000-8000-00805f9b34fb}_dev_vid&0218d1_pid&9400_rev&0001#9&3c4d5e6f&0&0000#{4d1e55b2-f16f-11cf-88cb-001111000030}&bthle`, true},
+		{`\\?\bthenum#{00001124-0000-1000-8000-00805f9b34fb}`, true},
+	} {
+		info := DeviceInfo{Path: test.path}
+
+		if got := info.IsBluetooth(); got != test.want {
+			t.Errorf("IsBluetooth(%s) = %v, want %v", test.path, got, test.want)
+		}
+	}
+}
+
+func TestFeatureReportSize(t *testing.T) {
+	// Devices without feature reports declare a FeatureReportByteLength of
+	// 0, and of 1 if they only declare a report number.
+	if _, err := featureReportSize(0); !errors.Is(err, ErrNoFeatureReports) {
+		t.Errorf("featureReportSize(0) returned %v, want ErrNoFeatureReports", err)
+	}
+
+	for _, length := range []uint16{1, 63, 0xffff} {
+		if size, err := featureReportSize(length); err != nil || size != int(length)+1 {
+			t.Errorf("featureReportSize(%d) = %d, %v, want %d", length, size, err, int(length)+1)
+		}
+	}
+}
+
+func TestFeatureReportBuffer(t *testing.T) {
+	for _, test := range []struct {
+		data    []byte
+		size    int
+		want    []byte
+		wantErr bool
+	}{
+		{[]byte{0x02}, 4, []byte{0x02, 0, 0, 0}, false},
+		{[]byte{0x02, 0xaa, 0xbb}, 4, []byte{0x02, 0xaa, 0xbb, 0}, false},
+		{[]byte{0x02, 0xaa, 0xbb, 0xcc}, 4, []byte{0x02, 0xaa, 0xbb, 0xcc}, false},
+		{[]byte{0x02, 0xaa, 0xbb, 0xcc, 0xdd}, 4, nil, true},
+		{nil, 4, nil, true},
+	} {
+		buf, err := featureReportBuffer(test.data, test.size)
+
+		if (err != nil) != test.wantErr {
+			t.Errorf("featureReportBuffer(% x, %d) returned error %v, want error %v", test.data, test.size, err, test.wantErr)
+		} else if !bytes.Equal(buf, test.want) {
+			t.Errorf("featureReportBuffer(% x, %d) = % x, want % x", test.data, test.size, buf, test.want)
+		}
+	}
+
+	// The report given by the caller is not modified.
+	data := make([]byte, 1, 4)
+	data[0] = 0x02
+
+	if buf, _ := featureReportBuffer(data, 4); &buf[0] == &data[0] {
+		t.Error("featureReportBuffer reused the given report")
+	}
+}
+
+func TestTrimReportNumber(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		report []byte
+		want   []byte
+	}{
+		{"unnumbered", []byte{0x00, 0x01, 0x02}, []byte{0x01, 0x02}},
+		{"unnumbered, payload starting with zero", []byte{0x00, 0x00, 0x02}, []byte{0x00, 0x02}},
+		{"unnumbered, empty payload", []byte{0x00}, []byte{}},
+		{"numbered", []byte{0x03, 0x08, 0x00}, []byte{0x03, 0x08, 0x00}},
+		{"numbered, payload starting with zero", []byte{0x03, 0x00, 0x01}, []byte{0x03, 0x00, 0x01}},
+	} {
+		if got := trimReportNumber(test.report); !bytes.Equal(got, test.want) {
+			t.Errorf("%s: trimReportNumber(% x) = % x, want % x", test.name, test.report, got, test.want)
+		}
+	}
+
+	// The report number of a Stadia report is kept, and its payload is not
+	// mistaken for it: a dpad pointing up is a zero first payload byte.
+	var report StadiaReport
+
+	if err := ParseStadiaReport(trimReportNumber([]byte{stadiaInputReportID, 0, 0, 0, 0x80, 0x80, 0x80, 0x80, 0, 0}), &report); err != nil {
+		t.Fatalf("ParseStadiaReport failed: %v", err)
+	}
+	if !report.DpadUp {
+		t.Errorf("parsed report is %+v, want the dpad pointing up", report)
+	}
+}
+
+var procGetProcessHandleCount = kernel32.NewProc("GetProcessHandleCount")
+
+// handleCount returns the number of handles opened by the process.
+func handleCount(t *testing.T) int {
+	t.Helper()
+
+	var count uint32
+
+	if r, _, err := procGetProcessHandleCount.Call(uintptr(windows.CurrentProcess()), uintptr(unsafe.Pointer(&count))); r == 0 {
+		t.Fatalf("GetProcessHandleCount failed: %v", err)
+	}
+
+	return int(count)
+}
+
+func TestDeviceHandlesAreClosed(t *testing.T) {
+	// A regular file stands for the device: reading from it fails at its
+	// end, which stops readThread like a removed device.
+	path := filepath.Join(t.TempDir(), "device")
+
+	if err := ioutil.WriteFile(path, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		name      string
+		enumerate bool
+		read      bool
+	}{
+		{"opened for enumeration", true, false},
+		{"closed without reading", false, false},
+		{"closed after reading", false, true},
+	} {
+		open := func() {
+			device, err := openDevice(&DeviceInfo{Path: path, InputReportLength: 9}, test.enumerate)
+
+			if err != nil {
+				t.Fatalf("%s: openDevice failed: %v", test.name, err)
+			}
+
+			if test.read {
+				for range device.ReadCh() {
+				}
+			}
+
+			if err := device.Close(); err != nil {
+				t.Errorf("%s: Close() = %v", test.name, err)
+			}
+			if err := device.Close(); err != nil {
+				t.Errorf("%s: second Close() = %v", test.name, err)
+			}
+		}
+
+		// Open once first, so that handles opened once by the runtime or
+		// the system are not counted.
+		open()
+
+		const iterations = 100
+		before := handleCount(t)
+
+		for i := 0; i < iterations; i++ {
+			open()
+		}
+
+		// Leaking a handle per device would add as many handles as there
+		// were iterations; allow a few for the runtime.
+		if after := handleCount(t); after-before >= iterations/10 {
+			t.Errorf("%s: %d handles before opening and closing %d devices, %d after", test.name, before, iterations, after)
+		}
+	}
+}
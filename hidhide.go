@@ -0,0 +1,299 @@
+package stadiacontroller
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf16"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// IOCTLs of the HidHide control device, i.e. CTL_CODE(32769, function,
+// METHOD_BUFFERED, FILE_READ_DATA).
+const (
+	ioctlHidHideGetWhitelist = 0x8001<<16 | 1<<14 | 2048<<2
+	ioctlHidHideSetWhitelist = 0x8001<<16 | 1<<14 | 2049<<2
+	ioctlHidHideGetBlacklist = 0x8001<<16 | 1<<14 | 2050<<2
+	ioctlHidHideSetBlacklist = 0x8001<<16 | 1<<14 | 2051<<2
+	ioctlHidHideGetActive    = 0x8001<<16 | 1<<14 | 2052<<2
+	ioctlHidHideSetActive    = 0x8001<<16 | 1<<14 | 2053<<2
+)
+
+// ErrHidHideNotInstalled is returned by HideDevice if the HidHide driver is
+// not installed.
+var ErrHidHideNotInstalled = errors.New("HidHide is not installed")
+
+// HideDevice uses HidHide to hide the device with the given path from all
+// processes but the current one, so that games do not receive the input of
+// both the physical and the emulated controller.
+//
+// The returned function reverts the changes made to the configuration of
+// HidHide, and should be called on shutdown. If HideDevice fails after
+// changing the configuration, it reverts these changes itself.
+func HideDevice(devicePath string) (unhide func() error, err error) {
+	instanceID, err := deviceInstanceID(devicePath)
+
+	if err != nil {
+		return nil, err
+	}
+
+	imagePath, err := currentImagePath()
+
+	if err != nil {
+		return nil, fmt.Errorf("cannot whitelist current process in HidHide: %w", err)
+	}
+
+	h, err := openHidHide()
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer windows.CloseHandle(h)
+
+	// undo holds the changes made to the configuration so far, which are
+	// reverted in reverse order by unhide, or as soon as a change fails so
+	// that the device is not left hidden from the current process as well.
+	var undo []func(h windows.Handle) error
+
+	revert := func(h windows.Handle) error {
+		for i := len(undo) - 1; i >= 0; i-- {
+			if err := undo[i](h); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	fail := func(message string, err error) (func() error, error) {
+		if revertErr := revert(h); revertErr != nil {
+			return nil, fmt.Errorf("%s: %w (cannot revert changes to HidHide: %v)", message, err, revertErr)
+		}
+
+		return nil, fmt.Errorf("%s: %w", message, err)
+	}
+
+	addedToBlacklist, err := hidHideAddToList(h, ioctlHidHideGetBlacklist, ioctlHidHideSetBlacklist, instanceID)
+
+	if err != nil {
+		return nil, fmt.Errorf("cannot hide device with HidHide: %w", err)
+	}
+	if addedToBlacklist {
+		undo = append(undo, func(h windows.Handle) error {
+			return hidHideRemoveFromList(h, ioctlHidHideGetBlacklist, ioctlHidHideSetBlacklist, instanceID)
+		})
+	}
+
+	addedToWhitelist, err := hidHideAddToList(h, ioctlHidHideGetWhitelist, ioctlHidHideSetWhitelist, imagePath)
+
+	if err != nil {
+		return fail("cannot whitelist current process in HidHide", err)
+	}
+	if addedToWhitelist {
+		undo = append(undo, func(h windows.Handle) error {
+			return hidHideRemoveFromList(h, ioctlHidHideGetWhitelist, ioctlHidHideSetWhitelist, imagePath)
+		})
+	}
+
+	wasActive, err := hidHideGetActive(h)
+
+	if err != nil {
+		return fail("cannot activate HidHide", err)
+	}
+	if !wasActive {
+		if err := hidHideSetActive(h, true); err != nil {
+			return fail("cannot activate HidHide", err)
+		}
+
+		undo = append(undo, func(h windows.Handle) error {
+			return hidHideSetActive(h, false)
+		})
+	}
+
+	return func() error {
+		h, err := openHidHide()
+
+		if err != nil {
+			return err
+		}
+
+		defer windows.CloseHandle(h)
+
+		return revert(h)
+	}, nil
+}
+
+func openHidHide() (windows.Handle, error) {
+	name, err := windows.UTF16PtrFromString(`\\.\HidHide`)
+
+	if err != nil {
+		return 0, err
+	}
+
+	h, err := windows.CreateFile(name, windows.GENERIC_READ, windows.FILE_SHARE_READ|windows.FILE_SHARE_WRITE, nil, windows.OPEN_EXISTING, 0, 0)
+
+	if errors.Is(err, windows.ERROR_FILE_NOT_FOUND) || errors.Is(err, windows.ERROR_PATH_NOT_FOUND) {
+		return 0, ErrHidHideNotInstalled
+	}
+	if err != nil {
+		return 0, fmt.Errorf("cannot open HidHide: %w", err)
+	}
+
+	return h, nil
+}
+
+// deviceInstanceID returns the instance ID of the device with the given
+// interface path, e.g. HID\VID_18D1&PID_9400\7&1A2B3C4D&0&0000 for
+// \\?\hid#vid_18d1&pid_9400#7&1a2b3c4d&0&0000#{4d1e55b2-f16f-11cf-88cb-001111000030}.
+func deviceInstanceID(devicePath string) (string, error) {
+	path := strings.TrimPrefix(devicePath, `\\?\`)
+	parts := strings.Split(path, "#")
+
+	if len(parts) < 4 || !strings.HasPrefix(parts[len(parts)-1], "{") {
+		return "", fmt.Errorf("unexpected device path '%s'", devicePath)
+	}
+
+	return strings.ToUpper(strings.Join(parts[:len(parts)-1], `\`)), nil
+}
+
+// currentImagePath returns the path of the current executable as expected by
+// HidHide, i.e. with a device path instead of a drive letter.
+func currentImagePath() (string, error) {
+	path, err := os.Executable()
+
+	if err != nil {
+		return "", err
+	}
+
+	volume := filepath.VolumeName(path)
+	volumePtr, err := windows.UTF16PtrFromString(volume)
+
+	if err != nil {
+		return "", err
+	}
+
+	buf := make([]uint16, windows.MAX_PATH)
+
+	if _, err := windows.QueryDosDevice(volumePtr, &buf[0], uint32(len(buf))); err != nil {
+		return "", err
+	}
+
+	return windows.UTF16ToString(buf) + path[len(volume):], nil
+}
+
+func hidHideGetList(h windows.Handle, ioctl uint32) ([]string, error) {
+	var size uint32
+
+	if err := windows.DeviceIoControl(h, ioctl, nil, 0, nil, 0, &size, nil); err != nil {
+		return nil, err
+	}
+	if size < 2 {
+		return nil, nil
+	}
+
+	buf := make([]uint16, size/2)
+
+	if err := windows.DeviceIoControl(h, ioctl, nil, 0, (*byte)(unsafe.Pointer(&buf[0])), size, &size, nil); err != nil {
+		return nil, err
+	}
+
+	// The list is made of null-terminated strings, terminated by an empty
+	// string.
+	var list []string
+
+	for len(buf) > 0 && buf[0] != 0 {
+		end := 0
+
+		for end < len(buf) && buf[end] != 0 {
+			end++
+		}
+
+		list = append(list, string(utf16.Decode(buf[:end])))
+
+		if end == len(buf) {
+			break
+		}
+
+		buf = buf[end+1:]
+	}
+
+	return list, nil
+}
+
+func hidHideSetList(h windows.Handle, ioctl uint32, list []string) error {
+	var buf []uint16
+
+	for _, s := range list {
+		buf = append(buf, utf16.Encode([]rune(s))...)
+		buf = append(buf, 0)
+	}
+
+	buf = append(buf, 0)
+
+	var returned uint32
+
+	return windows.DeviceIoControl(h, ioctl, (*byte)(unsafe.Pointer(&buf[0])), uint32(len(buf)*2), nil, 0, &returned, nil)
+}
+
+// hidHideAddToList adds the given value to a HidHide list, returning whether
+// it was not in the list already.
+func hidHideAddToList(h windows.Handle, getIoctl, setIoctl uint32, value string) (bool, error) {
+	list, err := hidHideGetList(h, getIoctl)
+
+	if err != nil {
+		return false, err
+	}
+
+	for _, item := range list {
+		if strings.EqualFold(item, value) {
+			return false, nil
+		}
+	}
+
+	return true, hidHideSetList(h, setIoctl, append(list, value))
+}
+
+func hidHideRemoveFromList(h windows.Handle, getIoctl, setIoctl uint32, value string) error {
+	list, err := hidHideGetList(h, getIoctl)
+
+	if err != nil {
+		return err
+	}
+
+	kept := list[:0]
+
+	for _, item := range list {
+		if !strings.EqualFold(item, value) {
+			kept = append(kept, item)
+		}
+	}
+
+	return hidHideSetList(h, setIoctl, kept)
+}
+
+func hidHideGetActive(h windows.Handle) (bool, error) {
+	var active byte
+	var returned uint32
+
+	if err := windows.DeviceIoControl(h, ioctlHidHideGetActive, nil, 0, &active, 1, &returned, nil); err != nil {
+		return false, err
+	}
+
+	return active != 0, nil
+}
+
+func hidHideSetActive(h windows.Handle, active bool) error {
+	var value byte
+	var returned uint32
+
+	if active {
+		value = 1
+	}
+
+	return windows.DeviceIoControl(h, ioctlHidHideSetActive, &value, 1, nil, 0, &returned, nil)
+}
@@ -0,0 +1,112 @@
+package stadiacontroller
+
+import (
+	"errors"
+	"runtime"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+var (
+	procRegisterHotKey     = user32.NewProc("RegisterHotKey")
+	procUnregisterHotKey   = user32.NewProc("UnregisterHotKey")
+	procPostThreadMessageW = user32.NewProc("PostThreadMessageW")
+)
+
+const (
+	wmQuit   = 0x0012
+	wmHotkey = 0x0312
+
+	modAlt      = 0x0001
+	modControl  = 0x0002
+	modShift    = 0x0004
+	modWin      = 0x0008
+	modNoRepeat = 0x4000
+)
+
+// hotkeyModifiers maps the virtual-key codes of modifiers to their MOD_*
+// flag.
+var hotkeyModifiers = map[uint16]uintptr{
+	0x11: modControl,
+	0x10: modShift,
+	0x12: modAlt,
+	0x5B: modWin,
+}
+
+// A Hotkey is a system-wide key chord registered with RegisterHotkey.
+type Hotkey struct {
+	threadID uint32
+	done     chan struct{}
+}
+
+// RegisterHotkey calls onPressed whenever the given chord is pressed, even if
+// another program has the focus. The chord must have exactly one key which
+// is not a modifier. onPressed is called from a dedicated goroutine.
+func RegisterHotkey(chord KeyChord, onPressed func()) (*Hotkey, error) {
+	var modifiers uintptr
+	var key uint16
+
+	for _, k := range chord {
+		if modifier, ok := hotkeyModifiers[k]; ok {
+			modifiers |= modifier
+		} else if key == 0 {
+			key = k
+		} else {
+			return nil, errors.New("a hotkey must have a single key which is not a modifier")
+		}
+	}
+
+	if key == 0 {
+		return nil, errors.New("a hotkey must have a key which is not a modifier")
+	}
+
+	hotkey := &Hotkey{done: make(chan struct{})}
+	started := make(chan error, 1)
+
+	go func() {
+		// Hotkey messages are posted to the thread which registered the hotkey.
+		runtime.LockOSThread()
+		defer runtime.UnlockOSThread()
+		defer close(hotkey.done)
+
+		if r, _, err := procRegisterHotKey.Call(0, 1, modifiers|modNoRepeat, uintptr(key)); r == 0 {
+			started <- err
+			return
+		}
+
+		defer procUnregisterHotKey.Call(0, 1)
+
+		hotkey.threadID = windows.GetCurrentThreadId()
+		started <- nil
+
+		var m msg
+
+		for {
+			if r, _, _ := procGetMessageW.Call(uintptr(unsafe.Pointer(&m)), 0, 0, 0); r == 0 || int32(r) == -1 {
+				return
+			}
+
+			if m.message == wmHotkey {
+				onPressed()
+			}
+		}
+	}()
+
+	if err := <-started; err != nil {
+		return nil, err
+	}
+
+	return hotkey, nil
+}
+
+// Close unregisters the hotkey.
+func (h *Hotkey) Close() error {
+	if r, _, err := procPostThreadMessageW.Call(uintptr(h.threadID), wmQuit, 0, 0); r == 0 {
+		return err
+	}
+
+	<-h.done
+
+	return nil
+}
@@ -0,0 +1,239 @@
+package stadiacontroller
+
+import (
+	"runtime"
+	"sync"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+var (
+	user32   = windows.NewLazySystemDLL("user32.dll")
+	kernel32 = windows.NewLazySystemDLL("kernel32.dll")
+
+	procRegisterClassExW             = user32.NewProc("RegisterClassExW")
+	procCreateWindowExW              = user32.NewProc("CreateWindowExW")
+	procDestroyWindow                = user32.NewProc("DestroyWindow")
+	procDefWindowProcW               = user32.NewProc("DefWindowProcW")
+	procGetMessageW                  = user32.NewProc("GetMessageW")
+	procDispatchMessageW             = user32.NewProc("DispatchMessageW")
+	procPostMessageW                 = user32.NewProc("PostMessageW")
+	procPostQuitMessage              = user32.NewProc("PostQuitMessage")
+	procRegisterDeviceNotificationW  = user32.NewProc("RegisterDeviceNotificationW")
+	procUnregisterDeviceNotification = user32.NewProc("UnregisterDeviceNotification")
+	procGetModuleHandleW             = kernel32.NewProc("GetModuleHandleW")
+)
+
+const (
+	wmDestroy      = 0x0002
+	wmClose        = 0x0010
+	wmDeviceChange = 0x0219
+
+	hwndMessage = ^uintptr(2) // (HWND)-3
+
+	dbtDeviceArrival         = 0x8000
+	dbtDeviceRemoveComplete  = 0x8004
+	dbtDevtypDeviceInterface = 0x00000005
+
+	deviceNotifyWindowHandle = 0x00000000
+)
+
+// wndClassEx mirrors WNDCLASSEXW.
+type wndClassEx struct {
+	size       uint32
+	style      uint32
+	wndProc    uintptr
+	clsExtra   int32
+	wndExtra   int32
+	instance   uintptr
+	icon       uintptr
+	cursor     uintptr
+	background uintptr
+	menuName   *uint16
+	className  *uint16
+	iconSm     uintptr
+}
+
+// msg mirrors MSG.
+type msg struct {
+	hwnd    uintptr
+	message uint32
+	wParam  uintptr
+	lParam  uintptr
+	time    uint32
+	ptX     int32
+	ptY     int32
+	private uint32
+}
+
+// devBroadcastDeviceInterface mirrors DEV_BROADCAST_DEVICEINTERFACE_W, whose
+// dbcc_name is a variable-length array that starts at the end of the
+// structure.
+type devBroadcastDeviceInterface struct {
+	size       uint32
+	deviceType uint32
+	reserved   uint32
+	classGUID  windows.GUID
+	name       [1]uint16
+}
+
+// A deviceWatcher listens for the arrival and removal of device interfaces
+// using a message-only window.
+type deviceWatcher struct {
+	hwnd      uintptr
+	onArrival func(path string)
+	onRemoval func(path string)
+}
+
+var (
+	watcherClassOnce sync.Once
+	watcherClassName *uint16
+	watcherClassErr  error
+
+	watchersMu sync.Mutex
+	watchers   = map[uintptr]*deviceWatcher{}
+)
+
+func registerWatcherClass() {
+	watcherClassName, watcherClassErr = windows.UTF16PtrFromString("stadiacontroller-device-watcher")
+
+	if watcherClassErr != nil {
+		return
+	}
+
+	instance, _, _ := procGetModuleHandleW.Call(0)
+	class := wndClassEx{
+		wndProc:   windows.NewCallback(watcherWndProc),
+		instance:  instance,
+		className: watcherClassName,
+	}
+	class.size = uint32(unsafe.Sizeof(class))
+
+	if r, _, err := procRegisterClassExW.Call(uintptr(unsafe.Pointer(&class))); r == 0 {
+		watcherClassErr = err
+	}
+}
+
+func watcherWndProc(hwnd, message, wParam uintptr, lParam unsafe.Pointer) uintptr {
+	switch message {
+	case wmDeviceChange:
+		watchersMu.Lock()
+		watcher := watchers[hwnd]
+		watchersMu.Unlock()
+
+		if watcher == nil || lParam == nil {
+			break
+		}
+
+		broadcast := (*devBroadcastDeviceInterface)(lParam)
+
+		if broadcast.deviceType != dbtDevtypDeviceInterface {
+			break
+		}
+
+		nameLength := (uintptr(broadcast.size) - unsafe.Offsetof(broadcast.name)) / 2
+		name := (*[windows.MAX_LONG_PATH]uint16)(unsafe.Pointer(&broadcast.name[0]))[:nameLength:nameLength]
+		path := windows.UTF16ToString(name)
+
+		switch wParam {
+		case dbtDeviceArrival:
+			watcher.onArrival(path)
+		case dbtDeviceRemoveComplete:
+			watcher.onRemoval(path)
+		}
+
+		return 1
+
+	case wmDestroy:
+		procPostQuitMessage.Call(0)
+
+		return 0
+	}
+
+	r, _, _ := procDefWindowProcW.Call(hwnd, message, wParam, uintptr(lParam))
+
+	return r
+}
+
+// watchDevices starts listening for the arrival and removal of devices
+// exposing an interface of the given class, calling the given functions with
+// the path of the device interface. The functions are called from a
+// dedicated goroutine.
+func watchDevices(classGUID windows.GUID, onArrival, onRemoval func(path string)) (*deviceWatcher, error) {
+	watcherClassOnce.Do(registerWatcherClass)
+
+	if watcherClassErr != nil {
+		return nil, watcherClassErr
+	}
+
+	watcher := &deviceWatcher{onArrival: onArrival, onRemoval: onRemoval}
+	started := make(chan error, 1)
+
+	go func() {
+		// Window messages are delivered to the thread which created the window.
+		runtime.LockOSThread()
+		defer runtime.UnlockOSThread()
+
+		hwnd, _, err := procCreateWindowExW.Call(0, uintptr(unsafe.Pointer(watcherClassName)), 0, 0, 0, 0, 0, 0, hwndMessage, 0, 0, 0)
+
+		if hwnd == 0 {
+			started <- err
+			return
+		}
+
+		filter := devBroadcastDeviceInterface{
+			deviceType: dbtDevtypDeviceInterface,
+			classGUID:  classGUID,
+		}
+		filter.size = uint32(unsafe.Sizeof(filter))
+
+		notification, _, err := procRegisterDeviceNotificationW.Call(hwnd, uintptr(unsafe.Pointer(&filter)), deviceNotifyWindowHandle)
+
+		if notification == 0 {
+			procDestroyWindow.Call(hwnd)
+			started <- err
+			return
+		}
+
+		defer procUnregisterDeviceNotification.Call(notification)
+
+		watcher.hwnd = hwnd
+		watchersMu.Lock()
+		watchers[hwnd] = watcher
+		watchersMu.Unlock()
+
+		defer func() {
+			watchersMu.Lock()
+			delete(watchers, hwnd)
+			watchersMu.Unlock()
+		}()
+
+		started <- nil
+
+		var m msg
+
+		for {
+			if r, _, _ := procGetMessageW.Call(uintptr(unsafe.Pointer(&m)), 0, 0, 0); r == 0 || int32(r) == -1 {
+				return
+			}
+
+			procDispatchMessageW.Call(uintptr(unsafe.Pointer(&m)))
+		}
+	}()
+
+	if err := <-started; err != nil {
+		return nil, err
+	}
+
+	return watcher, nil
+}
+
+// Close stops listening for device notifications.
+func (w *deviceWatcher) Close() error {
+	if r, _, err := procPostMessageW.Call(w.hwnd, wmClose, 0, 0); r == 0 {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,203 @@
+package stadiacontroller
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"unsafe"
+)
+
+const (
+	inputMouse = 0
+
+	mouseEventMove      = 0x0001
+	mouseEventLeftDown  = 0x0002
+	mouseEventLeftUp    = 0x0004
+	mouseEventRightDown = 0x0008
+	mouseEventRightUp   = 0x0010
+)
+
+// mouseInput mirrors INPUT with its MOUSEINPUT member, which is its largest.
+type mouseInput struct {
+	inputType uint32
+	mi        mouseInputData
+}
+
+// mouseInputData mirrors MOUSEINPUT.
+type mouseInputData struct {
+	dx        int32
+	dy        int32
+	mouseData uint32
+	flags     uint32
+	time      uint32
+	extraInfo uintptr
+}
+
+// DefaultKeyboardMouseSensitivity is the default distance in pixels the
+// cursor moves for each report while the right stick is fully deflected.
+const DefaultKeyboardMouseSensitivity = 10
+
+// DefaultKeyboardMouseKeys are the default keys pressed for each Xbox 360
+// controller button by a KeyboardMouseSink.
+var DefaultKeyboardMouseKeys = map[int]uint16{
+	Xbox360ControllerButtonUp:            virtualKeys["up"],
+	Xbox360ControllerButtonDown:          virtualKeys["down"],
+	Xbox360ControllerButtonLeft:          virtualKeys["left"],
+	Xbox360ControllerButtonRight:         virtualKeys["right"],
+	Xbox360ControllerButtonStart:         virtualKeys["enter"],
+	Xbox360ControllerButtonBack:          virtualKeys["tab"],
+	Xbox360ControllerButtonLeftShoulder:  virtualKeys["q"],
+	Xbox360ControllerButtonRightShoulder: virtualKeys["e"],
+	Xbox360ControllerButtonA:             virtualKeys["space"],
+	Xbox360ControllerButtonB:             virtualKeys["escape"],
+	Xbox360ControllerButtonX:             virtualKeys["r"],
+	Xbox360ControllerButtonY:             virtualKeys["f"],
+}
+
+// ParseKeyboardMapping parses the keys pressed for each button, given as
+// BUTTON:KEY entries separated by commas, e.g. "A:space,B:escape". Keys are
+// named like in ParseKeyChord.
+func ParseKeyboardMapping(s string) (map[int]uint16, error) {
+	keys := map[int]uint16{}
+
+	if s == "" {
+		return keys, nil
+	}
+
+	for _, entry := range strings.Split(s, ",") {
+		parts := strings.SplitN(entry, ":", 2)
+
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid key mapping entry '%s', expected BUTTON:KEY", entry)
+		}
+
+		button, err := ParseXbox360ControllerButton(strings.TrimSpace(parts[0]))
+
+		if err != nil {
+			return nil, fmt.Errorf("invalid key mapping entry '%s': %w", entry, err)
+		}
+
+		key, ok := virtualKeys[strings.ToLower(strings.TrimSpace(parts[1]))]
+
+		if !ok {
+			return nil, fmt.Errorf("invalid key mapping entry '%s': unknown key '%s'", entry, parts[1])
+		}
+
+		keys[button] = key
+	}
+
+	return keys, nil
+}
+
+// A KeyboardMouseSink sends reports as keyboard and mouse input instead of
+// to an emulated controller, for games which do not support controllers.
+// Buttons press the keys they are mapped to, the right stick moves the
+// cursor, and the right and left triggers press the left and right mouse
+// buttons. The left stick is ignored.
+//
+// A KeyboardMouseSink must only be used from a single goroutine.
+type KeyboardMouseSink struct {
+	// Keys maps Xbox 360 controller buttons to the virtual-key code pressed
+	// while they are held.
+	Keys map[int]uint16
+
+	// Sensitivity is the distance in pixels the cursor moves for each report
+	// while the right stick is fully deflected.
+	Sensitivity float64
+
+	buttons                uint16
+	leftClick, rightClick  bool
+	remainderX, remainderY float64
+}
+
+// NewKeyboardMouseSink returns a sink using the given keys, or
+// DefaultKeyboardMouseKeys if nil, and sensitivity.
+func NewKeyboardMouseSink(keys map[int]uint16, sensitivity float64) *KeyboardMouseSink {
+	if keys == nil {
+		keys = DefaultKeyboardMouseKeys
+	}
+
+	return &KeyboardMouseSink{Keys: keys, Sensitivity: sensitivity}
+}
+
+// Send presses and releases keys and mouse buttons, and moves the cursor,
+// according to the given report.
+func (s *KeyboardMouseSink) Send(report *Xbox360ControllerReport) error {
+	var keyInputs []keyboardInput
+
+	buttons := report.GetButtons()
+	changed := buttons ^ s.buttons
+
+	for button, key := range s.Keys {
+		if changed&(1<<button) == 0 {
+			continue
+		}
+
+		if buttons&(1<<button) != 0 {
+			keyInputs = append(keyInputs, newKeyboardInput(key, 0))
+		} else {
+			keyInputs = append(keyInputs, newKeyboardInput(key, keyEventKeyUp))
+		}
+	}
+
+	if err := sendInputs(keyInputs); err != nil {
+		return err
+	}
+
+	s.buttons = buttons
+
+	var mouseInputs []mouseInput
+
+	leftClick := report.GetRightTrigger() >= DefaultTriggerThreshold
+	rightClick := report.GetLeftTrigger() >= DefaultTriggerThreshold
+
+	if leftClick && !s.leftClick {
+		mouseInputs = append(mouseInputs, newMouseInput(0, 0, mouseEventLeftDown))
+	} else if !leftClick && s.leftClick {
+		mouseInputs = append(mouseInputs, newMouseInput(0, 0, mouseEventLeftUp))
+	}
+	if rightClick && !s.rightClick {
+		mouseInputs = append(mouseInputs, newMouseInput(0, 0, mouseEventRightDown))
+	} else if !rightClick && s.rightClick {
+		mouseInputs = append(mouseInputs, newMouseInput(0, 0, mouseEventRightUp))
+	}
+
+	// Accumulate the motion which is too small to move the cursor, so that
+	// the cursor still moves slowly when the stick is barely deflected.
+	x, y := report.GetRightThumb()
+
+	s.remainderX += float64(x) / math.MaxInt16 * s.Sensitivity
+	s.remainderY -= float64(y) / math.MaxInt16 * s.Sensitivity
+
+	dx, dy := math.Trunc(s.remainderX), math.Trunc(s.remainderY)
+
+	if dx != 0 || dy != 0 {
+		s.remainderX -= dx
+		s.remainderY -= dy
+
+		mouseInputs = append(mouseInputs, newMouseInput(int32(dx), int32(dy), mouseEventMove))
+	}
+
+	if len(mouseInputs) == 0 {
+		return nil
+	}
+
+	if err := sendInput(len(mouseInputs), unsafe.Pointer(&mouseInputs[0]), unsafe.Sizeof(mouseInputs[0]), "mouse"); err != nil {
+		return err
+	}
+
+	s.leftClick, s.rightClick = leftClick, rightClick
+
+	return nil
+}
+
+// Close releases all the keys and mouse buttons held by the sink.
+func (s *KeyboardMouseSink) Close() error {
+	neutral := NewXbox360ControllerReport()
+
+	return s.Send(&neutral)
+}
+
+func newMouseInput(dx, dy int32, flags uint32) mouseInput {
+	return mouseInput{inputType: inputMouse, mi: mouseInputData{dx: dx, dy: dy, flags: flags}}
+}
@@ -0,0 +1,137 @@
+package stadiacontroller
+
+import (
+	"fmt"
+	"strings"
+	"unsafe"
+)
+
+var procSendInput = user32.NewProc("SendInput")
+
+const (
+	inputKeyboard = 1
+
+	keyEventExtendedKey = 0x0001
+	keyEventKeyUp       = 0x0002
+)
+
+// keyboardInput mirrors INPUT with its KEYBDINPUT member. INPUT is padded to
+// the size of its largest member, MOUSEINPUT.
+type keyboardInput struct {
+	inputType uint32
+	ki        keybdInput
+	padding   [8]byte
+}
+
+// keybdInput mirrors KEYBDINPUT.
+type keybdInput struct {
+	vk        uint16
+	scan      uint16
+	flags     uint32
+	time      uint32
+	extraInfo uintptr
+}
+
+// virtualKeys maps the names accepted by ParseKeyChord to virtual-key codes.
+var virtualKeys = map[string]uint16{
+	"ctrl": 0x11, "control": 0x11, "shift": 0x10, "alt": 0x12, "win": 0x5B,
+
+	"backspace": 0x08, "tab": 0x09, "enter": 0x0D, "pause": 0x13, "capslock": 0x14,
+	"escape": 0x1B, "esc": 0x1B, "space": 0x20, "pageup": 0x21, "pagedown": 0x22,
+	"end": 0x23, "home": 0x24, "left": 0x25, "up": 0x26, "right": 0x27, "down": 0x28,
+	"printscreen": 0x2C, "insert": 0x2D, "delete": 0x2E,
+
+	"volumemute": 0xAD, "volumedown": 0xAE, "volumeup": 0xAF,
+	"nexttrack": 0xB0, "prevtrack": 0xB1, "stop": 0xB2, "playpause": 0xB3,
+}
+
+// extendedKeys are the keys which must be sent with KEYEVENTF_EXTENDEDKEY.
+var extendedKeys = map[uint16]bool{
+	0x21: true, 0x22: true, 0x23: true, 0x24: true, 0x25: true, 0x26: true,
+	0x27: true, 0x28: true, 0x2C: true, 0x2D: true, 0x2E: true, 0x5B: true,
+}
+
+func init() {
+	for c := 'a'; c <= 'z'; c++ {
+		virtualKeys[string(c)] = uint16('A' + c - 'a')
+	}
+	for c := '0'; c <= '9'; c++ {
+		virtualKeys[string(c)] = uint16(c)
+	}
+	for i := 1; i <= 24; i++ {
+		virtualKeys[fmt.Sprintf("f%d", i)] = uint16(0x70 + i - 1)
+	}
+}
+
+// A KeyChord is a combination of keys pressed together, e.g. Win+Alt+PrtScn.
+type KeyChord []uint16
+
+// ParseKeyChord parses a chord given as case-insensitive key names separated
+// by '+', e.g. "win+alt+printscreen". Modifiers (ctrl, shift, alt and win),
+// letters, digits, function keys (f1 to f24), navigation keys and media keys
+// (volumeup, volumedown, volumemute, playpause, nexttrack, prevtrack and stop)
+// are supported.
+func ParseKeyChord(s string) (KeyChord, error) {
+	var chord KeyChord
+
+	for _, name := range strings.Split(s, "+") {
+		key, ok := virtualKeys[strings.ToLower(strings.TrimSpace(name))]
+
+		if !ok {
+			return nil, fmt.Errorf("unknown key '%s' in chord '%s'", name, s)
+		}
+
+		chord = append(chord, key)
+	}
+
+	return chord, nil
+}
+
+// Press presses all the keys of the chord, in order.
+func (c KeyChord) Press() error {
+	inputs := make([]keyboardInput, len(c))
+
+	for i, key := range c {
+		inputs[i] = newKeyboardInput(key, 0)
+	}
+
+	return sendInputs(inputs)
+}
+
+// Release releases all the keys of the chord, in reverse order.
+func (c KeyChord) Release() error {
+	inputs := make([]keyboardInput, len(c))
+
+	for i, key := range c {
+		inputs[len(c)-1-i] = newKeyboardInput(key, keyEventKeyUp)
+	}
+
+	return sendInputs(inputs)
+}
+
+func newKeyboardInput(key uint16, flags uint32) keyboardInput {
+	if extendedKeys[key] {
+		flags |= keyEventExtendedKey
+	}
+
+	return keyboardInput{inputType: inputKeyboard, ki: keybdInput{vk: key, flags: flags}}
+}
+
+func sendInputs(inputs []keyboardInput) error {
+	if len(inputs) == 0 {
+		return nil
+	}
+
+	return sendInput(len(inputs), unsafe.Pointer(&inputs[0]), unsafe.Sizeof(inputs[0]), "keyboard")
+}
+
+// sendInput calls SendInput with count INPUT structures of the given size.
+func sendInput(count int, inputs unsafe.Pointer, size uintptr, kind string) error {
+	sent, _, err := procSendInput.Call(uintptr(count), uintptr(inputs), size)
+
+	if int(sent) != count {
+		return fmt.Errorf("cannot send %s input: %w", kind, err)
+	}
+
+	return nil
+}
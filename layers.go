@@ -0,0 +1,122 @@
+package stadiacontroller
+
+import (
+	"fmt"
+	"strings"
+)
+
+// layerModifiers are the buttons which may activate a layer, in the order in
+// which their layers are looked up when both are held.
+var layerModifiers = []int{ButtonAssistant, ButtonCapture}
+
+// parseLayerModifier returns the button of the layer modifier with the given
+// case-insensitive name, i.e. Assistant or Capture.
+func parseLayerModifier(name string) (int, error) {
+	switch {
+	case strings.EqualFold(name, "Assistant"):
+		return ButtonAssistant, nil
+	case strings.EqualFold(name, "Capture"):
+		return ButtonCapture, nil
+	default:
+		return 0, fmt.Errorf("unknown layer modifier '%s', expected Assistant or Capture", name)
+	}
+}
+
+// parseLayers parses the "layers" of a config file, which map a modifier to
+// a button map in the format read by LoadButtonMap.
+func parseLayers(names map[string]map[string]ButtonTargets) (map[int]ButtonMap, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	layers := make(map[int]ButtonMap, len(names))
+
+	for modifierName, buttonNames := range names {
+		modifier, err := parseLayerModifier(modifierName)
+
+		if err != nil {
+			return nil, err
+		}
+
+		buttons, err := parseButtonMap(buttonNames)
+
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", modifierName, err)
+		}
+
+		layers[modifier] = buttons
+	}
+
+	return layers, nil
+}
+
+// isLayerModifier returns whether the given button activates a layer of c.
+func (c *ParseConfig) isLayerModifier(button int) bool {
+	_, ok := c.Layers[button]
+
+	return ok
+}
+
+// layerOf returns the modifier whose layer maps the given button in the
+// given report, or -1 if the button uses the base mapping.
+func (c *ParseConfig) layerOf(r *StadiaReport, button int) int {
+	for _, modifier := range layerModifiers {
+		layer, ok := c.Layers[modifier]
+
+		if !ok || !isModifierHeld(r, modifier) {
+			continue
+		}
+		if _, ok := layer[button]; ok {
+			return modifier
+		}
+	}
+
+	return -1
+}
+
+// isModifierHeld returns whether the given layer modifier is held in the
+// given report.
+func isModifierHeld(r *StadiaReport, modifier int) bool {
+	if modifier == ButtonAssistant {
+		return r.Assistant
+	}
+
+	return r.Capture
+}
+
+// buttonMap returns the button map of the given layer.
+func (c *ParseConfig) buttonMap(layer int) ButtonMap {
+	if layer < 0 {
+		return c.Buttons
+	}
+
+	return c.Layers[layer]
+}
+
+// A layerTracker remembers the layer in which each held button was pressed,
+// so that the button keeps the mapping of that layer until it is released,
+// even if its modifier is released first. Otherwise, the button pressed
+// through the layer would be released and the base mapping pressed instead.
+type layerTracker map[int]int
+
+// layerOf returns the layer of the given button, which is held in the given
+// report.
+func (t layerTracker) layerOf(r *StadiaReport, cfg *ParseConfig, button int) int {
+	if t == nil {
+		return cfg.layerOf(r, button)
+	}
+
+	layer, ok := t[button]
+
+	if !ok {
+		layer = cfg.layerOf(r, button)
+		t[button] = layer
+	}
+
+	return layer
+}
+
+// release forgets the layer of the given button, which is not held anymore.
+func (t layerTracker) release(button int) {
+	delete(t, button)
+}
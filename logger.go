@@ -0,0 +1,27 @@
+package stadiacontroller
+
+import "log"
+
+// A Logger receives the messages logged by a StadiaController, such as
+// failures to open a device. *log.Logger implements it.
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+// WithLogger makes the controller log its messages to the given logger
+// instead of the standard logger. A nil logger discards them.
+func WithLogger(logger Logger) Option {
+	return func(o *options) {
+		if logger == nil {
+			logger = discardLogger{}
+		}
+		o.logger = logger
+	}
+}
+
+type discardLogger struct{}
+
+func (discardLogger) Printf(format string, v ...interface{}) {}
+
+// defaultLogger is the logger used when WithLogger is not given.
+var defaultLogger Logger = log.Default()
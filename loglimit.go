@@ -0,0 +1,78 @@
+package stadiacontroller
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// logRepeatInterval is how often a message logged again and again is
+// summarized by a logLimiter.
+const logRepeatInterval = 5 * time.Second
+
+// A logLimiter collapses identical messages logged repeatedly into a single
+// "last message repeated N times" line, logged at most every interval.
+// Messages are grouped by class, e.g. "discovery", so that messages of one
+// class do not interrupt the repetitions of another.
+type logLimiter struct {
+	logger   Logger
+	interval time.Duration
+	now      func() time.Time
+
+	mu      sync.Mutex
+	classes map[string]*logClass
+}
+
+type logClass struct {
+	last     string
+	repeated int
+	since    time.Time
+}
+
+func newLogLimiter(logger Logger) *logLimiter {
+	return &logLimiter{
+		logger:   logger,
+		interval: logRepeatInterval,
+		now:      time.Now,
+		classes:  map[string]*logClass{},
+	}
+}
+
+// Printf logs the given message, unless it is the same as the last message
+// of the given class.
+func (l *logLimiter) Printf(class string, format string, v ...interface{}) {
+	message := fmt.Sprintf(format, v...)
+	now := l.now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	state, ok := l.classes[class]
+
+	if !ok {
+		state = &logClass{}
+		l.classes[class] = state
+	}
+
+	if ok && message == state.last {
+		state.repeated++
+
+		if now.Sub(state.since) >= l.interval {
+			l.logger.Printf("last message repeated %d times", state.repeated)
+			state.repeated = 0
+			state.since = now
+		}
+
+		return
+	}
+
+	if state.repeated > 0 {
+		l.logger.Printf("last message repeated %d times", state.repeated)
+	}
+
+	l.logger.Printf("%s", message)
+
+	state.last = message
+	state.repeated = 0
+	state.since = now
+}
@@ -0,0 +1,93 @@
+package stadiacontroller
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// recordingLogger is a Logger which keeps the messages logged to it.
+type recordingLogger struct {
+	messages []string
+}
+
+func (l *recordingLogger) Printf(format string, v ...interface{}) {
+	l.messages = append(l.messages, fmt.Sprintf(format, v...))
+}
+
+// fakeClock is a clock which only moves forward when told to.
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+func TestLogLimiter(t *testing.T) {
+	logger := &recordingLogger{}
+	clock := &fakeClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+
+	limiter := newLogLimiter(logger)
+	limiter.now = clock.Now
+
+	// The first message is logged, and identical ones are only counted.
+	for i := 0; i < 10; i++ {
+		limiter.Printf("read", "unable to read from controller")
+		clock.Advance(100 * time.Millisecond)
+	}
+
+	// Once the interval elapsed, the repetitions are summarized.
+	clock.Advance(logRepeatInterval)
+	limiter.Printf("read", "unable to read from controller")
+	limiter.Printf("read", "unable to read from controller")
+
+	// Another class does not interrupt the repetitions.
+	limiter.Printf("discovery", "cannot enumerate devices")
+
+	// A new message of the class first logs the remaining repetitions.
+	limiter.Printf("read", "device was removed")
+	limiter.Printf("read", "unable to read from controller")
+
+	want := []string{
+		"unable to read from controller",
+		"last message repeated 10 times",
+		"cannot enumerate devices",
+		"last message repeated 1 times",
+		"device was removed",
+		"unable to read from controller",
+	}
+
+	if !reflect.DeepEqual(logger.messages, want) {
+		t.Errorf("logged messages are\n%q\nwant\n%q", logger.messages, want)
+	}
+}
+
+func TestLogLimiterSummarizesAtMostEveryInterval(t *testing.T) {
+	logger := &recordingLogger{}
+	clock := &fakeClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+
+	limiter := newLogLimiter(logger)
+	limiter.now = clock.Now
+
+	// Log the same message every second for 20 seconds.
+	for i := 0; i <= 20; i++ {
+		limiter.Printf("parse", "unable to parse controller report")
+		clock.Advance(time.Second)
+	}
+
+	want := []string{"unable to parse controller report"}
+
+	for i := 0; i < 20/int(logRepeatInterval/time.Second); i++ {
+		want = append(want, fmt.Sprintf("last message repeated %d times", logRepeatInterval/time.Second))
+	}
+
+	if !reflect.DeepEqual(logger.messages, want) {
+		t.Errorf("logged messages are\n%q\nwant\n%q", logger.messages, want)
+	}
+}
@@ -0,0 +1,148 @@
+package stadiacontroller
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// A MockDevice is a Device which replays a fixed list of input reports, for
+// instance reports read back from a recording with ReadRecording.
+//
+// Once all reports have been sent, the read channel is closed and ReadError
+// returns io.EOF.
+type MockDevice struct {
+	reports  [][]byte
+	interval time.Duration
+
+	readSetup sync.Once
+	readCh    chan []byte
+	closeOnce sync.Once
+	closed    chan struct{}
+
+	mu       sync.Mutex
+	writes   [][]byte
+	features map[byte][]byte
+}
+
+// NewMockDevice returns a device which sends the given reports on its read
+// channel, waiting for the given interval before each report.
+func NewMockDevice(reports [][]byte, interval time.Duration) *MockDevice {
+	return &MockDevice{
+		reports:  reports,
+		interval: interval,
+		readCh:   make(chan []byte),
+		closed:   make(chan struct{}),
+	}
+}
+
+// Close stops the replay of reports.
+func (d *MockDevice) Close() error {
+	d.closeOnce.Do(func() {
+		close(d.closed)
+	})
+
+	return nil
+}
+
+// Write records the given output report, which can later be retrieved with
+// Writes.
+func (d *MockDevice) Write(data []byte) error {
+	select {
+	case <-d.closed:
+		return ErrClosed
+	default:
+	}
+
+	d.mu.Lock()
+	d.writes = append(d.writes, append([]byte(nil), data...))
+	d.mu.Unlock()
+
+	return nil
+}
+
+// Writes returns all output reports written to the device so far.
+func (d *MockDevice) Writes() [][]byte {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return append([][]byte(nil), d.writes...)
+}
+
+func (d *MockDevice) ReadCh() <-chan []byte {
+	d.readSetup.Do(func() {
+		go d.replay()
+	})
+
+	return d.readCh
+}
+
+func (d *MockDevice) ReadError() error {
+	return io.EOF
+}
+
+// GetFeature copies the last feature report set with the given number by
+// SetFeature into buf, which must be large enough to hold it.
+func (d *MockDevice) GetFeature(reportID byte, buf []byte) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	report, ok := d.features[reportID]
+
+	if !ok {
+		return 0, fmt.Errorf("mock: no feature report %#02x: %w", reportID, ErrNoFeatureReports)
+	}
+	if len(buf) < len(report) {
+		return 0, fmt.Errorf("mock: feature report buffer of %d bytes is shorter than %d bytes: %w", len(buf), len(report), io.ErrShortBuffer)
+	}
+
+	return copy(buf, report), nil
+}
+
+func (d *MockDevice) GetFeatureReport(reportID byte) ([]byte, error) {
+	d.mu.Lock()
+	buf := make([]byte, len(d.features[reportID]))
+	d.mu.Unlock()
+
+	n, err := d.GetFeature(reportID, buf)
+
+	return buf[:n], err
+}
+
+// SetFeature records the given feature report, which is then returned by
+// GetFeature. Its first byte is the report number.
+func (d *MockDevice) SetFeature(data []byte) error {
+	if len(data) == 0 {
+		return errors.New("mock: empty feature report")
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.features == nil {
+		d.features = make(map[byte][]byte)
+	}
+	d.features[data[0]] = append([]byte(nil), data...)
+
+	return nil
+}
+
+func (d *MockDevice) replay() {
+	defer close(d.readCh)
+
+	for _, report := range d.reports {
+		select {
+		case <-time.After(d.interval):
+		case <-d.closed:
+			return
+		}
+
+		select {
+		case d.readCh <- report:
+		case <-d.closed:
+			return
+		}
+	}
+}
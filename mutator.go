@@ -0,0 +1,36 @@
+package stadiacontroller
+
+// A ReportMutator transforms a report after it was parsed, and before it is
+// sent to the emulated controller.
+type ReportMutator func(report *Xbox360ControllerReport)
+
+// MutateReport applies the given mutators to the report, in order.
+func MutateReport(report *Xbox360ControllerReport, mutators ...ReportMutator) {
+	for _, mutate := range mutators {
+		mutate(report)
+	}
+}
+
+// SwapSticks swaps the left and right sticks, along with their buttons.
+//
+// Since the sticks are swapped after parsing, the per-stick settings of the
+// ParseConfig used to parse the report should be swapped with
+// ParseConfig.SwapSticks so that they still apply to the resulting sticks.
+func SwapSticks(report *Xbox360ControllerReport) {
+	lx, ly := report.GetLeftThumb()
+	rx, ry := report.GetRightThumb()
+
+	report.SetLeftThumb(rx, ry)
+	report.SetRightThumb(lx, ly)
+
+	buttons := report.GetButtons()
+	report.ClearButton(Xbox360ControllerButtonLeftThumb)
+	report.ClearButton(Xbox360ControllerButtonRightThumb)
+
+	if buttons&(1<<Xbox360ControllerButtonLeftThumb) != 0 {
+		report.SetButton(Xbox360ControllerButtonRightThumb)
+	}
+	if buttons&(1<<Xbox360ControllerButtonRightThumb) != 0 {
+		report.SetButton(Xbox360ControllerButtonLeftThumb)
+	}
+}
@@ -0,0 +1,53 @@
+package stadiacontroller
+
+// SetPaused sets whether the controller is paused. While paused, reports
+// are still read from the controller, but GetReport returns a neutral report
+// once and then waits until the controller is resumed, when it returns the
+// current state of the controller right away. Vibrations are stopped and
+// ignored while paused.
+func (c *StadiaController) SetPaused(paused bool) {
+	c.mu.Lock()
+	changed := c.paused != paused
+	c.paused = paused
+	c.mu.Unlock()
+
+	if changed {
+		c.pausedChanged(paused)
+	}
+}
+
+// Paused returns whether the controller is paused by SetPaused.
+func (c *StadiaController) Paused() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.paused
+}
+
+// TogglePaused pauses the controller if it is running, and resumes it
+// otherwise. It returns whether the controller is now paused.
+func (c *StadiaController) TogglePaused() bool {
+	// Flip the state in a single critical section, so that concurrent
+	// toggles cannot both pause or both resume the controller.
+	c.mu.Lock()
+	c.paused = !c.paused
+	paused := c.paused
+	c.mu.Unlock()
+
+	c.pausedChanged(paused)
+
+	return paused
+}
+
+// pausedChanged stops vibrations when the controller is paused, and wakes up
+// GetReport.
+func (c *StadiaController) pausedChanged(paused bool) {
+	if paused {
+		c.playVibration(nil, Vibration{})
+	}
+
+	select {
+	case c.pauseChanged <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,40 @@
+package stadiacontroller
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestTogglePausedConcurrently(t *testing.T) {
+	c := newStadiaController(newOptions([]Option{WithLogger(nil)}))
+	defer c.Close()
+
+	const toggles = 100
+
+	var wg sync.WaitGroup
+	var pauses int64
+
+	for i := 0; i < toggles; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			if c.TogglePaused() {
+				atomic.AddInt64(&pauses, 1)
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	// Each toggle sees the state left by the previous one, so they
+	// alternate between pausing and resuming.
+	if pauses != toggles/2 {
+		t.Errorf("%d toggles paused the controller, want %d", pauses, toggles/2)
+	}
+	if c.Paused() {
+		t.Error("controller is paused after an even number of toggles")
+	}
+}
@@ -0,0 +1,267 @@
+package stadiacontroller
+
+import (
+	"fmt"
+	"sort"
+)
+
+// A Profile is a named set of settings read from a config file by
+// LoadProfile. Settings which are not given by the profile are nil.
+type Profile struct {
+	Buttons ButtonMap
+
+	LeftDeadzone   *float64
+	RightDeadzone  *float64
+	LeftXDeadzone  *float64
+	LeftYDeadzone  *float64
+	RightXDeadzone *float64
+	RightYDeadzone *float64
+
+	LeftCurve  *ResponseCurve
+	RightCurve *ResponseCurve
+
+	InvertLeftX  *bool
+	InvertLeftY  *bool
+	InvertRightX *bool
+	InvertRightY *bool
+
+	RumbleScale *float64
+	Turbo       TurboConfig
+
+	// LeftTrigger and RightTrigger override the "triggers" settings of the
+	// config which they set, and StickToDpad and DpadToStick its dpad
+	// settings. Trigger modes and dpad settings are read by LoadTriggerMapper
+	// and LoadDpadMutators.
+	LeftTrigger  *TriggerConfig
+	RightTrigger *TriggerConfig
+	StickToDpad  *StickToDpadConfig
+	DpadToStick  *bool
+
+	// Commands run when the Capture and Assistant buttons are pressed or
+	// released.
+	CapturePressed    *string
+	CaptureReleased   *string
+	AssistantPressed  *string
+	AssistantReleased *string
+}
+
+// ProfileConfig is the JSON representation of a Profile, as found in a
+// Config.
+type ProfileConfig struct {
+	Buttons map[string]ButtonTargets `json:"buttons"`
+
+	Deadzone struct {
+		Left   *float64 `json:"left"`
+		Right  *float64 `json:"right"`
+		LeftX  *float64 `json:"leftX"`
+		LeftY  *float64 `json:"leftY"`
+		RightX *float64 `json:"rightX"`
+		RightY *float64 `json:"rightY"`
+	} `json:"deadzone"`
+
+	Curve struct {
+		Left  *string `json:"left"`
+		Right *string `json:"right"`
+	} `json:"curve"`
+
+	Invert struct {
+		LeftX  *bool `json:"leftX"`
+		LeftY  *bool `json:"leftY"`
+		RightX *bool `json:"rightX"`
+		RightY *bool `json:"rightY"`
+	} `json:"invert"`
+
+	RumbleScale *float64 `json:"rumbleScale"`
+	Turbo       *string  `json:"turbo"`
+
+	Triggers struct {
+		Left  *TriggerConfig `json:"left"`
+		Right *TriggerConfig `json:"right"`
+	} `json:"triggers"`
+
+	StickToDpad *StickToDpadConfig `json:"stickToDpad"`
+	DpadToStick *bool              `json:"dpadToStick"`
+
+	Commands struct {
+		CapturePressed    *string `json:"capturePressed"`
+		CaptureReleased   *string `json:"captureReleased"`
+		AssistantPressed  *string `json:"assistantPressed"`
+		AssistantReleased *string `json:"assistantReleased"`
+	} `json:"commands"`
+}
+
+// LoadProfile reads the profile with the given name from the "profiles" key
+// of a JSON config file, e.g.
+//
+//	{"profiles": {"racing": {"deadzone": {"left": 0.1}, "turbo": "A:15"}}}
+//
+// A profile may set "buttons", "deadzone" ("left", "right", "leftX", "leftY",
+// "rightX" and "rightY"), "curve" ("left" and "right"), "invert" ("leftX",
+// "leftY", "rightX" and "rightY"), "rumbleScale", "turbo" and "commands"
+// ("capturePressed", "captureReleased", "assistantPressed" and
+// "assistantReleased"), in the same formats as the command line flags.
+//
+// A profile may also set "triggers" ("left" and "right"), "stickToDpad" and
+// "dpadToStick", in the same formats as the config. The settings of a trigger
+// which are not given by the profile are kept.
+func LoadProfile(path, name string) (*Profile, error) {
+	config, err := readValidConfig(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	profileConfig, ok := config.Profiles[name]
+
+	if !ok {
+		return nil, fmt.Errorf("unknown profile '%s' in config %s", name, path)
+	}
+
+	profile, err := profileConfig.parse()
+
+	if err != nil {
+		return nil, fmt.Errorf("invalid profile '%s' in config %s: %w", name, path, err)
+	}
+
+	return profile, nil
+}
+
+// ProfileNames returns the names of the profiles of a JSON config file, in
+// alphabetical order.
+func ProfileNames(path string) ([]string, error) {
+	config, err := readValidConfig(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(config.Profiles))
+
+	for name := range config.Profiles {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names, nil
+}
+
+func (f *ProfileConfig) parse() (*Profile, error) {
+	profile := &Profile{
+		LeftDeadzone:   f.Deadzone.Left,
+		RightDeadzone:  f.Deadzone.Right,
+		LeftXDeadzone:  f.Deadzone.LeftX,
+		LeftYDeadzone:  f.Deadzone.LeftY,
+		RightXDeadzone: f.Deadzone.RightX,
+		RightYDeadzone: f.Deadzone.RightY,
+
+		InvertLeftX:  f.Invert.LeftX,
+		InvertLeftY:  f.Invert.LeftY,
+		InvertRightX: f.Invert.RightX,
+		InvertRightY: f.Invert.RightY,
+
+		RumbleScale: f.RumbleScale,
+
+		LeftTrigger:  f.Triggers.Left,
+		RightTrigger: f.Triggers.Right,
+		StickToDpad:  f.StickToDpad,
+		DpadToStick:  f.DpadToStick,
+
+		CapturePressed:    f.Commands.CapturePressed,
+		CaptureReleased:   f.Commands.CaptureReleased,
+		AssistantPressed:  f.Commands.AssistantPressed,
+		AssistantReleased: f.Commands.AssistantReleased,
+	}
+
+	if f.Buttons != nil {
+		buttons, err := parseButtonMap(f.Buttons)
+
+		if err != nil {
+			return nil, fmt.Errorf("buttons: %w", err)
+		}
+
+		profile.Buttons = buttons
+	}
+
+	for _, curve := range []struct {
+		s   *string
+		out **ResponseCurve
+	}{{f.Curve.Left, &profile.LeftCurve}, {f.Curve.Right, &profile.RightCurve}} {
+		if curve.s == nil {
+			continue
+		}
+
+		parsed, err := ParseResponseCurve(*curve.s)
+
+		if err != nil {
+			return nil, err
+		}
+
+		*curve.out = &parsed
+	}
+
+	if f.Turbo != nil {
+		turbo, err := ParseTurboConfig(*f.Turbo)
+
+		if err != nil {
+			return nil, err
+		}
+
+		profile.Turbo = turbo
+	}
+
+	return profile, nil
+}
+
+// Apply overrides the settings of cfg with the settings given by the profile.
+func (p *Profile) Apply(cfg *ParseConfig) {
+	if p.Buttons != nil {
+		cfg.Buttons = p.Buttons
+	}
+
+	for _, setting := range []struct {
+		value *float64
+		out   *float64
+	}{
+		{p.LeftDeadzone, &cfg.Deadzone.Left},
+		{p.RightDeadzone, &cfg.Deadzone.Right},
+		{p.LeftXDeadzone, &cfg.Deadzone.LeftX},
+		{p.LeftYDeadzone, &cfg.Deadzone.LeftY},
+		{p.RightXDeadzone, &cfg.Deadzone.RightX},
+		{p.RightYDeadzone, &cfg.Deadzone.RightY},
+	} {
+		if setting.value != nil {
+			*setting.out = *setting.value
+		}
+	}
+
+	if p.LeftCurve != nil {
+		cfg.LeftCurve = *p.LeftCurve
+	}
+	if p.RightCurve != nil {
+		cfg.RightCurve = *p.RightCurve
+	}
+
+	for _, trigger := range []struct {
+		config *TriggerConfig
+		out    *TriggerCalibration
+	}{{p.LeftTrigger, &cfg.LeftTrigger}, {p.RightTrigger, &cfg.RightTrigger}} {
+		if trigger.config != nil {
+			trigger.config.apply(trigger.out)
+		}
+	}
+
+	for _, setting := range []struct {
+		value *bool
+		out   *bool
+	}{
+		{p.InvertLeftX, &cfg.InvertLeftX},
+		{p.InvertLeftY, &cfg.InvertLeftY},
+		{p.InvertRightX, &cfg.InvertRightX},
+		{p.InvertRightY, &cfg.InvertRightY},
+	} {
+		if setting.value != nil {
+			*setting.out = *setting.value
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package stadiacontroller
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeConfig writes the given JSON config to a temporary file, and returns
+// its path.
+func writeConfig(t *testing.T, config string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "config.json")
+
+	if err := ioutil.WriteFile(path, []byte(config), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestProfileOverridesTriggersAndDpad(t *testing.T) {
+	path := writeConfig(t, `{
+		"triggers": {"left": {"deadzone": 10, "saturation": 200, "mode": "buttons"}},
+		"dpadToStick": true,
+		"profiles": {
+			"default": {},
+			"racing": {
+				"triggers": {"left": {"saturation": 150, "mode": "analog"}, "right": {"mode": "buttons"}},
+				"stickToDpad": {"directions": 4},
+				"dpadToStick": false
+			}
+		}
+	}`)
+
+	mapper, err := LoadTriggerMapper(path, nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mapper == nil || mapper.Left.Mode != TriggerButtons || mapper.Right.Mode != TriggerAnalog {
+		t.Errorf("without profile, trigger mapper is %+v, want left buttons and right analog", mapper)
+	}
+
+	racing, err := LoadProfile(path, "racing")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mapper, err = LoadTriggerMapper(path, racing)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mapper == nil || mapper.Left.Mode != TriggerAnalog || mapper.Right.Mode != TriggerButtons {
+		t.Errorf("with profile, trigger mapper is %+v, want left analog and right buttons", mapper)
+	}
+
+	config, err := LoadConfig(path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	racing.Apply(config)
+
+	// The deadzone of the config is kept, and its saturation is overridden.
+	if want := (TriggerCalibration{Min: 10, Max: 150}); config.LeftTrigger != want {
+		t.Errorf("with profile, left trigger calibration is %+v, want %+v", config.LeftTrigger, want)
+	}
+
+	// With dpadToStick, pressing Up deflects the left stick; with
+	// stickToDpad instead, the centered stick releases the dpad.
+	for _, test := range []struct {
+		profile         string
+		wantDpadToStick bool
+	}{
+		{"default", true},
+		{"racing", false},
+	} {
+		profile, err := LoadProfile(path, test.profile)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		mutators, err := LoadDpadMutators(path, profile)
+
+		if err != nil {
+			t.Errorf("%s: %v", test.profile, err)
+			continue
+		}
+		if len(mutators) != 1 {
+			t.Errorf("%s: got %d dpad mutators, want 1", test.profile, len(mutators))
+			continue
+		}
+
+		report := NewXbox360ControllerReport()
+		report.SetButton(Xbox360ControllerButtonUp)
+		MutateReport(&report, mutators...)
+
+		if _, y := report.GetLeftThumb(); (y != 0) != test.wantDpadToStick {
+			t.Errorf("%s: pressing Up moved the left stick to %d, want dpadToStick %v", test.profile, y, test.wantDpadToStick)
+		}
+	}
+}
+
+func TestValidateConfigProfileOverrides(t *testing.T) {
+	for _, test := range []struct {
+		config string
+		want   string
+	}{
+		{
+			`{"triggers": {"left": {"deadzone": 100}}, "profiles": {"p": {"triggers": {"left": {"saturation": 50}}}}}`,
+			"profiles.p.triggers.left: deadzone must be lower than saturation",
+		},
+		{
+			`{"profiles": {"p": {"triggers": {"right": {"mode": "sideways"}}}}}`,
+			"profiles.p.triggers.right: mode: unknown trigger mode 'sideways'",
+		},
+		{
+			`{"stickToDpad": {}, "profiles": {"p": {"dpadToStick": true}}}`,
+			"profiles.p.stickToDpad and dpadToStick cannot be combined",
+		},
+		{
+			`{"profiles": {"p": {"stickToDpad": {"directions": 6}}}}`,
+			"profiles.p.stickToDpad.directions: must be 4 or 8",
+		},
+	} {
+		_, err := LoadProfile(writeConfig(t, test.config), "p")
+
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: got error %v, want %q", test.config, err, test.want)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package stadiacontroller
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A RecordedReport is a raw report read from a recording written by a
+// controller created with WithRecorder.
+type RecordedReport struct {
+	Time time.Time
+	Data []byte
+}
+
+// WithRecorder makes the controller write every raw report it reads to w,
+// before parsing it. Each report is written on its own line, as its RFC 3339
+// timestamp followed by a space and the base64 encoding of its data.
+func WithRecorder(w io.Writer) Option {
+	return func(o *options) {
+		o.recorder = &recorder{w: w}
+	}
+}
+
+type recorder struct {
+	mu     sync.Mutex
+	w      io.Writer
+	failed bool
+	logger Logger
+}
+
+func (r *recorder) record(data []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.failed {
+		return
+	}
+
+	line := fmt.Sprintf("%s %s\n", time.Now().Format(time.RFC3339Nano), base64.StdEncoding.EncodeToString(data))
+
+	if _, err := io.WriteString(r.w, line); err != nil {
+		r.logger.Printf("unable to record report, recording stopped: %v", err)
+		r.failed = true
+	}
+}
+
+// ReadRecording reads the reports written by a controller created with
+// WithRecorder. Lines which only contain base64 data, such as the raw reports
+// logged when a report cannot be parsed, are also accepted.
+func ReadRecording(r io.Reader) ([]RecordedReport, error) {
+	var reports []RecordedReport
+
+	scanner := bufio.NewScanner(r)
+
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+
+		if line == "" {
+			continue
+		}
+
+		report := RecordedReport{}
+		encoded := line
+
+		if i := strings.IndexByte(line, ' '); i != -1 {
+			timestamp, err := time.Parse(time.RFC3339Nano, line[:i])
+
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid timestamp: %w", lineNumber, err)
+			}
+
+			report.Time = timestamp
+			encoded = line[i+1:]
+		}
+
+		data, err := base64.StdEncoding.DecodeString(encoded)
+
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid report: %w", lineNumber, err)
+		}
+
+		report.Data = data
+		reports = append(reports, report)
+	}
+
+	return reports, scanner.Err()
+}
+
+// ParseReportsFromFile parses all the reports of the recording at the given
+// path with ParseReportWithConfig. cfg may be nil.
+func ParseReportsFromFile(path string, cfg *ParseConfig) ([]Xbox360ControllerReport, error) {
+	file, err := os.Open(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	recorded, err := ReadRecording(file)
+
+	if err != nil {
+		return nil, fmt.Errorf("cannot read recording %s: %w", path, err)
+	}
+
+	reports := make([]Xbox360ControllerReport, len(recorded))
+
+	for i, recordedReport := range recorded {
+		if err := ParseReportWithConfig(recordedReport.Data, &reports[i], cfg); err != nil {
+			return nil, fmt.Errorf("report %d of %s: %w", i+1, path, err)
+		}
+	}
+
+	return reports, nil
+}
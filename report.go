@@ -0,0 +1,224 @@
+package stadiacontroller
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+// XUSBReport mirrors the XUSB_REPORT structure expected by ViGEm.
+type XUSBReport struct {
+	WButtons      uint16
+	BLeftTrigger  uint8
+	BRightTrigger uint8
+	SThumbLX      int16
+	SThumbLY      int16
+	SThumbRX      int16
+	SThumbRY      int16
+}
+
+// XUSBReportSize is the size of the XUSB_REPORT structure expected by ViGEm.
+const XUSBReportSize = 12
+
+// Bytes returns the little-endian representation of the report, as expected
+// by ViGEm.
+func (r *XUSBReport) Bytes() [XUSBReportSize]byte {
+	var buf [XUSBReportSize]byte
+
+	binary.LittleEndian.PutUint16(buf[0:], r.WButtons)
+	buf[2] = r.BLeftTrigger
+	buf[3] = r.BRightTrigger
+	binary.LittleEndian.PutUint16(buf[4:], uint16(r.SThumbLX))
+	binary.LittleEndian.PutUint16(buf[6:], uint16(r.SThumbLY))
+	binary.LittleEndian.PutUint16(buf[8:], uint16(r.SThumbRX))
+	binary.LittleEndian.PutUint16(buf[10:], uint16(r.SThumbRY))
+
+	return buf
+}
+
+type Xbox360ControllerReport struct {
+	native    XUSBReport
+	Capture   bool
+	Assistant bool
+}
+
+// Bits that correspond to the Xbox 360 controller buttons.
+const (
+	Xbox360ControllerButtonUp            = 0
+	Xbox360ControllerButtonDown          = 1
+	Xbox360ControllerButtonLeft          = 2
+	Xbox360ControllerButtonRight         = 3
+	Xbox360ControllerButtonStart         = 4
+	Xbox360ControllerButtonBack          = 5
+	Xbox360ControllerButtonLeftThumb     = 6
+	Xbox360ControllerButtonRightThumb    = 7
+	Xbox360ControllerButtonLeftShoulder  = 8
+	Xbox360ControllerButtonRightShoulder = 9
+	Xbox360ControllerButtonGuide         = 10
+	Xbox360ControllerButtonA             = 12
+	Xbox360ControllerButtonB             = 13
+	Xbox360ControllerButtonX             = 14
+	Xbox360ControllerButtonY             = 15
+)
+
+// Xbox360ControllerButtonNames maps the name of each Xbox 360 controller
+// button to its bit.
+var Xbox360ControllerButtonNames = map[string]int{
+	"Up":            Xbox360ControllerButtonUp,
+	"Down":          Xbox360ControllerButtonDown,
+	"Left":          Xbox360ControllerButtonLeft,
+	"Right":         Xbox360ControllerButtonRight,
+	"Start":         Xbox360ControllerButtonStart,
+	"Back":          Xbox360ControllerButtonBack,
+	"LeftThumb":     Xbox360ControllerButtonLeftThumb,
+	"RightThumb":    Xbox360ControllerButtonRightThumb,
+	"LeftShoulder":  Xbox360ControllerButtonLeftShoulder,
+	"RightShoulder": Xbox360ControllerButtonRightShoulder,
+	"Guide":         Xbox360ControllerButtonGuide,
+	"A":             Xbox360ControllerButtonA,
+	"B":             Xbox360ControllerButtonB,
+	"X":             Xbox360ControllerButtonX,
+	"Y":             Xbox360ControllerButtonY,
+}
+
+// ParseXbox360ControllerButton returns the bit of the Xbox 360 controller
+// button with the given case-insensitive name.
+func ParseXbox360ControllerButton(name string) (int, error) {
+	for buttonName, button := range Xbox360ControllerButtonNames {
+		if strings.EqualFold(buttonName, name) {
+			return button, nil
+		}
+	}
+
+	return 0, fmt.Errorf("unknown button '%s'", name)
+}
+
+func NewXbox360ControllerReport() Xbox360ControllerReport {
+	return Xbox360ControllerReport{}
+}
+
+// Reset releases all buttons and triggers, including Capture and Assistant,
+// and centers the sticks, so that the report can be reused.
+func (r *Xbox360ControllerReport) Reset() {
+	*r = Xbox360ControllerReport{}
+}
+
+func (r *Xbox360ControllerReport) GetButtons() uint16 {
+	return r.native.WButtons
+}
+
+func (r *Xbox360ControllerReport) SetButtons(buttons uint16) {
+	r.native.WButtons = buttons
+}
+
+func (r *Xbox360ControllerReport) MaybeSetButton(shiftBy int, isSet bool) {
+	if isSet {
+		r.SetButton(shiftBy)
+	}
+}
+
+func (r *Xbox360ControllerReport) SetButton(shiftBy int) {
+	r.native.WButtons |= 1 << shiftBy
+}
+
+func (r *Xbox360ControllerReport) ClearButton(shiftBy int) {
+	r.native.WButtons &^= 1 << shiftBy
+}
+
+func (r *Xbox360ControllerReport) GetLeftTrigger() byte {
+	return r.native.BLeftTrigger
+}
+
+func (r *Xbox360ControllerReport) SetLeftTrigger(value byte) {
+	r.native.BLeftTrigger = value
+}
+
+func (r *Xbox360ControllerReport) GetRightTrigger() byte {
+	return r.native.BRightTrigger
+}
+
+func (r *Xbox360ControllerReport) SetRightTrigger(value byte) {
+	r.native.BRightTrigger = value
+}
+
+func (r *Xbox360ControllerReport) GetLeftThumb() (x, y int16) {
+	return r.native.SThumbLX, r.native.SThumbLY
+}
+
+func (r *Xbox360ControllerReport) SetLeftThumb(x, y int16) {
+	r.native.SThumbLX = x
+	r.native.SThumbLY = y
+}
+
+func (r *Xbox360ControllerReport) GetRightThumb() (x, y int16) {
+	return r.native.SThumbRX, r.native.SThumbRY
+}
+
+func (r *Xbox360ControllerReport) SetRightThumb(x, y int16) {
+	r.native.SThumbRX = x
+	r.native.SThumbRY = y
+}
+
+// Equal returns whether both reports have the same buttons, triggers and
+// sticks. Capture and Assistant, which are not sent to the emulated
+// controller, are ignored.
+func (r *Xbox360ControllerReport) Equal(other *Xbox360ControllerReport) bool {
+	return r.native == other.native
+}
+
+// Diff returns the bitmasks of the buttons pressed and released in r since
+// the previous report.
+func (r *Xbox360ControllerReport) Diff(previous *Xbox360ControllerReport) (pressed, released uint16) {
+	changed := r.native.WButtons ^ previous.native.WButtons
+
+	return changed & r.native.WButtons, changed & previous.native.WButtons
+}
+
+// xbox360ControllerButtonOrder lists the buttons in the order in which they
+// are printed by String.
+var xbox360ControllerButtonOrder = []struct {
+	name string
+	bit  int
+}{
+	{"A", Xbox360ControllerButtonA},
+	{"B", Xbox360ControllerButtonB},
+	{"X", Xbox360ControllerButtonX},
+	{"Y", Xbox360ControllerButtonY},
+	{"Up", Xbox360ControllerButtonUp},
+	{"Down", Xbox360ControllerButtonDown},
+	{"Left", Xbox360ControllerButtonLeft},
+	{"Right", Xbox360ControllerButtonRight},
+	{"LeftShoulder", Xbox360ControllerButtonLeftShoulder},
+	{"RightShoulder", Xbox360ControllerButtonRightShoulder},
+	{"LeftThumb", Xbox360ControllerButtonLeftThumb},
+	{"RightThumb", Xbox360ControllerButtonRightThumb},
+	{"Start", Xbox360ControllerButtonStart},
+	{"Back", Xbox360ControllerButtonBack},
+	{"Guide", Xbox360ControllerButtonGuide},
+}
+
+// String renders the pressed buttons, sticks and triggers of the report, e.g.
+// "A B LX=-120 LY=0 RX=0 RY=0 LT=0 RT=255".
+func (r *Xbox360ControllerReport) String() string {
+	var b strings.Builder
+
+	for _, button := range xbox360ControllerButtonOrder {
+		if r.native.WButtons&(1<<button.bit) != 0 {
+			b.WriteString(button.name)
+			b.WriteByte(' ')
+		}
+	}
+
+	if r.Capture {
+		b.WriteString("Capture ")
+	}
+	if r.Assistant {
+		b.WriteString("Assistant ")
+	}
+
+	fmt.Fprintf(&b, "LX=%d LY=%d RX=%d RY=%d LT=%d RT=%d",
+		r.native.SThumbLX, r.native.SThumbLY, r.native.SThumbRX, r.native.SThumbRY,
+		r.native.BLeftTrigger, r.native.BRightTrigger)
+
+	return b.String()
+}
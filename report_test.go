@@ -0,0 +1,85 @@
+package stadiacontroller
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"unsafe"
+)
+
+func TestXUSBReportLayout(t *testing.T) {
+	var report XUSBReport
+
+	if size := unsafe.Sizeof(report); size != XUSBReportSize {
+		t.Fatalf("unsafe.Sizeof(XUSBReport{}) = %d, want %d", size, XUSBReportSize)
+	}
+
+	// Offsets of the fields of XUSB_REPORT.
+	for _, field := range []struct {
+		name   string
+		offset uintptr
+		want   uintptr
+	}{
+		{"WButtons", unsafe.Offsetof(report.WButtons), 0},
+		{"BLeftTrigger", unsafe.Offsetof(report.BLeftTrigger), 2},
+		{"BRightTrigger", unsafe.Offsetof(report.BRightTrigger), 3},
+		{"SThumbLX", unsafe.Offsetof(report.SThumbLX), 4},
+		{"SThumbLY", unsafe.Offsetof(report.SThumbLY), 6},
+		{"SThumbRX", unsafe.Offsetof(report.SThumbRX), 8},
+		{"SThumbRY", unsafe.Offsetof(report.SThumbRY), 10},
+	} {
+		if field.offset != field.want {
+			t.Errorf("offset of %s = %d, want %d", field.name, field.offset, field.want)
+		}
+	}
+}
+
+func TestXUSBReportBytes(t *testing.T) {
+	report := XUSBReport{
+		WButtons:      0xf00f,
+		BLeftTrigger:  0x12,
+		BRightTrigger: 0xff,
+		SThumbLX:      -0x8000,
+		SThumbLY:      0x7fff,
+		SThumbRX:      -1,
+		SThumbRY:      0x0102,
+	}
+
+	want := []byte{
+		0x0f, 0xf0,
+		0x12,
+		0xff,
+		0x00, 0x80,
+		0xff, 0x7f,
+		0xff, 0xff,
+		0x02, 0x01,
+	}
+
+	buf := report.Bytes()
+
+	if !bytes.Equal(buf[:], want) {
+		t.Fatalf("Bytes() = % x, want % x", buf, want)
+	}
+
+	// Windows is little-endian, so the bytes given to ViGEm must also be the
+	// in-memory representation of the struct, like the C struct it replaces.
+	memory := *(*[XUSBReportSize]byte)(unsafe.Pointer(&report))
+
+	if memory != buf {
+		t.Errorf("in-memory report = % x, want % x", memory, buf)
+	}
+
+	roundTrip := XUSBReport{
+		WButtons:      binary.LittleEndian.Uint16(buf[0:]),
+		BLeftTrigger:  buf[2],
+		BRightTrigger: buf[3],
+		SThumbLX:      int16(binary.LittleEndian.Uint16(buf[4:])),
+		SThumbLY:      int16(binary.LittleEndian.Uint16(buf[6:])),
+		SThumbRX:      int16(binary.LittleEndian.Uint16(buf[8:])),
+		SThumbRY:      int16(binary.LittleEndian.Uint16(buf[10:])),
+	}
+
+	if roundTrip != report {
+		t.Errorf("round-trip report = %+v, want %+v", roundTrip, report)
+	}
+}
@@ -0,0 +1,37 @@
+package stadiacontroller
+
+import "math"
+
+// A stickFilter smooths the stick axes of successive reports with an
+// exponential moving average, to hide the noise of some sticks. A new filter
+// must be used for each device, so that the average of a previous device
+// does not leak into the first reports of the next one.
+type stickFilter struct {
+	values [4]float64
+	primed bool
+}
+
+// apply smooths the sticks of the given report in place. smoothing, between
+// 0 and 1, is the weight of the previous average; 0 leaves reports as-is.
+func (f *stickFilter) apply(report *StadiaReport, smoothing float64) {
+	axes := [4]*byte{&report.LeftX, &report.LeftY, &report.RightX, &report.RightY}
+
+	if smoothing <= 0 || !f.primed {
+		for i, axis := range axes {
+			f.values[i] = float64(*axis)
+		}
+
+		f.primed = true
+
+		return
+	}
+
+	if smoothing > 1 {
+		smoothing = 1
+	}
+
+	for i, axis := range axes {
+		f.values[i] = smoothing*f.values[i] + (1-smoothing)*float64(*axis)
+		*axis = byte(math.Round(f.values[i]))
+	}
+}
@@ -0,0 +1,85 @@
+package stadiacontroller
+
+import (
+	"math"
+	"testing"
+)
+
+func TestStickFilterPassThrough(t *testing.T) {
+	var filter stickFilter
+
+	for i := 0; i < 256; i++ {
+		// Jump around the range of each axis.
+		want := StadiaReport{LeftX: byte(i), LeftY: byte(255 - i), RightX: byte(i * 7), RightY: byte(i * 13)}
+		report := want
+
+		filter.apply(&report, 0)
+
+		if report != want {
+			t.Fatalf("with smoothing 0, filtered report is %+v, want %+v", report, want)
+		}
+	}
+}
+
+func TestStickFilterStepResponse(t *testing.T) {
+	for _, smoothing := range []float64{0.25, 0.5, 0.9} {
+		var filter stickFilter
+
+		// The first report is the initial average, and is not smoothed.
+		report := StadiaReport{LeftX: 0x80, LeftY: 0x80, RightX: 0x80, RightY: 0x80}
+		filter.apply(&report, smoothing)
+
+		if report.LeftX != 0x80 {
+			t.Errorf("smoothing %v: first report moved to %#02x", smoothing, report.LeftX)
+		}
+
+		// After a step, the average moves towards the new value by a
+		// constant fraction of the remaining distance at each report.
+		previous := byte(0x80)
+
+		for step := 1; step <= 100; step++ {
+			report := StadiaReport{LeftX: 0xff, LeftY: 0x00, RightX: 0x80, RightY: 0x80}
+			filter.apply(&report, smoothing)
+
+			want := 0xff - 0x7f*math.Pow(smoothing, float64(step))
+
+			if math.Abs(float64(report.LeftX)-want) > 0.5 {
+				t.Errorf("smoothing %v: step %d: LeftX is %#02x, want %.1f", smoothing, step, report.LeftX, want)
+			}
+			if report.LeftX < previous {
+				t.Errorf("smoothing %v: step %d: LeftX moved back from %#02x to %#02x", smoothing, step, previous, report.LeftX)
+			}
+			if got, want := report.LeftY, 0xff-report.LeftX; got != want && got != want+1 && got != want-1 {
+				t.Errorf("smoothing %v: step %d: LeftY is %#02x, want %#02x", smoothing, step, got, want)
+			}
+			if report.RightX != 0x80 || report.RightY != 0x80 {
+				t.Errorf("smoothing %v: step %d: right stick moved to (%#02x, %#02x)", smoothing, step, report.RightX, report.RightY)
+			}
+
+			previous = report.LeftX
+		}
+
+		if previous != 0xff {
+			t.Errorf("smoothing %v: LeftX settled at %#02x, want 0xff", smoothing, previous)
+		}
+	}
+}
+
+func TestStickFilterReset(t *testing.T) {
+	var filter stickFilter
+
+	for i := 0; i < 10; i++ {
+		report := StadiaReport{LeftX: 0x00}
+		filter.apply(&report, 0.9)
+	}
+
+	// readReports uses a new filter for each device, which does not carry
+	// the average of the previous one.
+	filter = stickFilter{}
+	report := StadiaReport{LeftX: 0xff}
+	filter.apply(&report, 0.9)
+
+	if report.LeftX != 0xff {
+		t.Errorf("first report of a new filter is %#02x, want 0xff", report.LeftX)
+	}
+}
@@ -1,10 +1,13 @@
 package stadiacontroller
 
 import (
-	"encoding/base64"
+	"context"
 	"errors"
 	"fmt"
-	"log"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -13,186 +16,1099 @@ const (
 	stadiaControllerPid = 0x9400
 )
 
+// A DeviceID is a pair of USB vendor and product IDs.
+type DeviceID struct {
+	Vendor, Product uint16
+}
+
+// DefaultDeviceIDs are the IDs of the devices recognized as Stadia
+// controllers unless WithDeviceIDs is given.
+var DefaultDeviceIDs = []DeviceID{{stadiaControllerVid, stadiaControllerPid}}
+
 type StadiaController struct {
-	device *Device
-	ticker *time.Ticker
-	err    error
+	// mu guards device, devicePath, deviceInfo and err, which are written by
+	// the discovery goroutine and read by GetReport, Vibrate and Close, as
+	// well as config, profile, paused and lastReport, the last report
+	// received by GetReport.
+	mu         sync.Mutex
+	device     Device
+	devicePath string
+	deviceInfo DeviceInfo
+	err        error
+	config     *ParseConfig
+	profile    string
+	paused     bool
+	lastReport Xbox360ControllerReport
+
+	// deviceMu is held for reading while using device outside of mu, e.g.
+	// in BatteryLevel, and for writing by dropDevice while closing it, so
+	// that the device is never used once closed. It is locked before mu.
+	deviceMu sync.RWMutex
+
+	// pauseChanged wakes up GetReport when the controller is paused or
+	// resumed.
+	pauseChanged chan struct{}
+
+	// wantedPath is the path of the only device to open, if any.
+	wantedPath   string
+	wantedSerial string
+	deviceIDs    []DeviceID
+
+	// bluetoothFirmware is the release number of the first firmware with
+	// the Bluetooth update, if known.
+	bluetoothFirmware uint16
+
+	// enumerate lists the connected devices; it is Devices, except in
+	// tests.
+	enumerate func() ([]*DeviceInfo, error)
+
+	useCalibration bool
+	autoCenter     time.Duration
+
+	scan         chan struct{}
+	pollInterval time.Duration
+	backoff      *Backoff
+	readTimeout  time.Duration
+	ticker       *time.Ticker
+	watcher      *deviceWatcher
+
+	// retryAt is the time before which scans requested by the ticker are
+	// skipped, after discovery failed. It is guarded by mu.
+	retryAt time.Time
+
+	done      chan struct{}
+	closeOnce sync.Once
+	closeErr  error
+
+	vibrations  chan Vibration
+	rumbleScale float64
+
+	// vibrationMu guards vibrationTimer, which plays the next step of a
+	// vibration pattern, and vibrationGen, which is incremented whenever a
+	// new vibration cancels the current pattern.
+	vibrationMu      sync.Mutex
+	vibrationTimer   *time.Timer
+	vibrationGen     uint64
+	vibrationTimeout time.Duration
+
+	minVibrationInterval time.Duration
+
+	// statsMu guards vibrationStats.
+	statsMu        sync.Mutex
+	vibrationStats VibrationStats
+	counters       *counters
+	rate           *reportRate
+
+	// eventsMu serializes the events sent on events and the calls to the
+	// connection handlers.
+	eventsMu         sync.Mutex
+	events           chan Event
+	triggerThreshold byte
+	onConnect        func(info DeviceInfo)
+	onDisconnect     func(err error)
+
+	recorder *recorder
+	logger   Logger
+
+	// chords is only used by readReports, and onChord is called from it.
+	chords  *ChordEngine
+	onChord func(chord Chord)
+
+	// limiter collapses the messages logged repeatedly, e.g. while the
+	// controller keeps failing.
+	limiter *logLimiter
 }
 
-func NewStadiaController() *StadiaController {
-	ticker := time.NewTicker(1 * time.Second)
-	controller := &StadiaController{nil, ticker, nil}
+// An Option configures a StadiaController created by NewStadiaController.
+type Option func(*options)
 
-	go func() {
-		for range ticker.C {
-			if controller.device != nil || controller.err != nil {
-				continue
-			}
+type options struct {
+	polling          bool
+	pollInterval     time.Duration
+	backoffBase      time.Duration
+	readTimeout      time.Duration
+	backoffMax       time.Duration
+	devicePath       string
+	serialNumber     string
+	useCalibration   bool
+	autoCenter       time.Duration
+	deviceIDs        []DeviceID
+	rumbleScale      float64
+	vibrationTimeout time.Duration
+	maxVibrationRate float64
+	recorder         *recorder
+	triggerThreshold byte
+	onConnect        func(info DeviceInfo)
+	onDisconnect     func(err error)
+	logger           Logger
+	chords           *ChordEngine
+	onChord          func(chord Chord)
+	enumerate        func() ([]*DeviceInfo, error)
 
-			devices, err := Devices()
+	bluetoothFirmware uint16
+}
 
-			if err != nil {
-				controller.err = err
+// DefaultPollInterval is the interval at which devices are enumerated when
+// polling. After a failure, enumeration is retried with the backoff set by
+// WithBackoff instead.
+const DefaultPollInterval = 1 * time.Second
 
-				break
-			}
+// WithPolling makes the controller look for a device by enumerating all
+// devices periodically, instead of waiting for device notifications.
+func WithPolling() Option {
+	return func(o *options) {
+		o.polling = true
+	}
+}
 
-			for _, device := range devices {
-				if device.VendorID == stadiaControllerVid && device.ProductID == stadiaControllerPid {
-					openDevice, err := device.Open()
+// WithPollInterval sets the interval at which devices are enumerated when
+// polling. It defaults to DefaultPollInterval.
+func WithPollInterval(interval time.Duration) Option {
+	return func(o *options) {
+		o.pollInterval = interval
+	}
+}
 
-					if err != nil {
-						log.Printf("cannot open device %s: %v", device.Path, err)
+// WithReadTimeout sets the DeviceInfo.ReadTimeout of the devices opened by
+// the controller.
+func WithReadTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.readTimeout = timeout
+	}
+}
 
-						break
-					}
+// WithDevicePath makes the controller only open the device with the given
+// path, instead of the first Stadia controller it finds. The device is opened
+// even if its IDs are not those of a Stadia controller, and paths are
+// compared case-insensitively.
+func WithDevicePath(path string) Option {
+	return func(o *options) {
+		o.devicePath = path
+	}
+}
 
-					log.Printf("opened device %s", device.Path)
-					controller.device = &openDevice
+// WithSerialNumber makes the controller only open the device with the given
+// serial number, instead of the first Stadia controller it finds. Unlike a
+// path, a serial number does not change when the device is plugged into
+// another port.
+func WithSerialNumber(serial string) Option {
+	return func(o *options) {
+		o.serialNumber = serial
+	}
+}
+
+// WithSavedCalibration makes the controller calibrate the sticks of each
+// device it opens with the calibration saved for its serial number by
+// SaveCalibration, if any.
+func WithSavedCalibration() Option {
+	return func(o *options) {
+		o.useCalibration = true
+	}
+}
 
-					break
+// WithAutoCenter samples the resting position of the sticks during the given
+// duration after a controller is opened, and then centers them like
+// ParseConfig.CenterOffset, in addition to it. The sticks must be left alone
+// meanwhile; offsets larger than MaxAutoCenterOffset are ignored, since the
+// sticks were probably moved.
+func WithAutoCenter(duration time.Duration) Option {
+	return func(o *options) {
+		o.autoCenter = duration
+	}
+}
+
+// MaxAutoCenterOffset is the largest offset of a stick axis accepted by
+// WithAutoCenter.
+const MaxAutoCenterOffset = 0x2000
+
+// WithDeviceIDs makes the controller open devices with any of the given IDs,
+// instead of DefaultDeviceIDs, e.g. for hardware revisions with another
+// product ID.
+func WithDeviceIDs(ids ...DeviceID) Option {
+	return func(o *options) {
+		o.deviceIDs = ids
+	}
+}
+
+// WithConnectHandler registers a function called when a device is opened.
+func WithConnectHandler(onConnect func(info DeviceInfo)) Option {
+	return func(o *options) {
+		o.onConnect = onConnect
+	}
+}
+
+// WithDisconnectHandler registers a function called when the open device is
+// lost, with the error which caused it to be dropped.
+func WithDisconnectHandler(onDisconnect func(err error)) Option {
+	return func(o *options) {
+		o.onDisconnect = onDisconnect
+	}
+}
+
+// WithChords recognizes the chords of the given engine in the reports of the
+// controller, releasing their buttons, and calls onChord with each chord
+// pressed. ChordPause chords also pause or resume the controller, since
+// GetReport does not return reports while paused. onChord may be nil, and is
+// called from the goroutine reading reports, so it should return quickly.
+func WithChords(engine *ChordEngine, onChord func(chord Chord)) Option {
+	return func(o *options) {
+		o.chords = engine
+		o.onChord = onChord
+	}
+}
+
+// NewStadiaController returns a controller which will open the first Stadia
+// controller it finds, and look for a new one when it is disconnected.
+//
+// By default, devices are discovered when Windows notifies us of their
+// arrival. If these notifications are unavailable, or if WithPolling is
+// given, all devices are enumerated periodically instead (see
+// WithPollInterval).
+func NewStadiaController(opts ...Option) *StadiaController {
+	options := newOptions(opts)
+	controller := newStadiaController(options)
+
+	if !options.polling {
+		watcher, err := watchDevices(hidGUID(), controller.onDeviceArrival, controller.onDeviceRemoval)
+
+		if err != nil {
+			controller.logger.Printf("cannot listen for device notifications, polling instead: %v", err)
+			options.polling = true
+		} else {
+			controller.watcher = watcher
+		}
+	}
+
+	if options.polling {
+		ticker := time.NewTicker(controller.pollInterval)
+		controller.ticker = ticker
+
+		go func() {
+			for {
+				select {
+				case <-ticker.C:
+					controller.requestScan()
+				case <-controller.done:
+					return
 				}
 			}
+		}()
+	}
+
+	go func() {
+		for {
+			select {
+			case <-controller.scan:
+				controller.discover()
+			case <-controller.done:
+				return
+			}
 		}
 	}()
 
+	controller.requestScan()
+
+	return controller
+}
+
+// OpenStadiaController is like NewStadiaController, but first checks that
+// devices can be enumerated, returning an error matching ErrDiscoveryFailed
+// otherwise. Not finding a Stadia controller is not an error: the returned
+// controller waits for one to be connected.
+func OpenStadiaController(opts ...Option) (*StadiaController, error) {
+	if _, err := Devices(); err != nil {
+		return nil, &discoveryError{err}
+	}
+
+	return NewStadiaController(opts...), nil
+}
+
+// NewStadiaControllerWithDevice returns a controller which reads from the
+// given device instead of discovering devices, e.g. a MockDevice. Once the
+// device is lost, no other device is opened.
+func NewStadiaControllerWithDevice(device Device, opts ...Option) *StadiaController {
+	controller := newStadiaController(newOptions(opts))
+	controller.connect(DeviceInfo{}, device)
+
+	return controller
+}
+
+func newOptions(opts []Option) options {
+	options := options{
+		triggerThreshold: DefaultTriggerThreshold,
+		rumbleScale:      1,
+		vibrationTimeout: DefaultVibrationTimeout,
+		maxVibrationRate: DefaultMaxVibrationRate,
+		pollInterval:     DefaultPollInterval,
+		backoffBase:      DefaultBackoffBase,
+		backoffMax:       DefaultBackoffMax,
+		logger:           defaultLogger,
+		deviceIDs:        DefaultDeviceIDs,
+		enumerate:        Devices,
+	}
+
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	return options
+}
+
+func newStadiaController(options options) *StadiaController {
+	controller := &StadiaController{
+		scan:             make(chan struct{}, 1),
+		pollInterval:     options.pollInterval,
+		backoff:          NewBackoff(options.backoffBase, options.backoffMax),
+		readTimeout:      options.readTimeout,
+		done:             make(chan struct{}),
+		vibrations:       make(chan Vibration, 1),
+		events:           make(chan Event, 64),
+		pauseChanged:     make(chan struct{}, 1),
+		wantedPath:       options.devicePath,
+		wantedSerial:     options.serialNumber,
+		deviceIDs:        options.deviceIDs,
+		enumerate:        options.enumerate,
+		useCalibration:   options.useCalibration,
+		autoCenter:       options.autoCenter,
+		rumbleScale:      options.rumbleScale,
+		vibrationTimeout: options.vibrationTimeout,
+		triggerThreshold: options.triggerThreshold,
+		recorder:         options.recorder,
+		onConnect:        options.onConnect,
+		onDisconnect:     options.onDisconnect,
+		rate:             newReportRate(time.Now()),
+		counters:         &counters{},
+		logger:           options.logger,
+		limiter:          newLogLimiter(options.logger),
+		chords:           options.chords,
+		onChord:          options.onChord,
+	}
+
+	if controller.recorder != nil {
+		controller.recorder.logger = options.logger
+	}
+
+	controller.bluetoothFirmware = options.bluetoothFirmware
+
+	if options.maxVibrationRate > 0 {
+		controller.minVibrationInterval = time.Duration(float64(time.Second) / options.maxVibrationRate)
+	}
+
+	go controller.writeVibrations()
+
 	return controller
 }
 
-func (c *StadiaController) Close() {
-	c.ticker.Stop()
+// scanLater asks the discovery goroutine to look for a device after the next
+// backoff delay. When devices are polled, the scans requested by the ticker
+// before then are skipped instead.
+func (c *StadiaController) scanLater() {
+	delay := c.backoff.Next()
 
-	if c.device == nil {
+	if c.ticker == nil {
+		time.AfterFunc(delay, c.requestScan)
 		return
 	}
 
-	(*c.device).Close()
+	c.mu.Lock()
+	c.retryAt = time.Now().Add(delay)
+	c.mu.Unlock()
 }
 
-func (c *StadiaController) Vibrate(largeMotor, smallMotor byte) error {
-	if c.device == nil {
-		return c.err
+// requestScan asks the discovery goroutine to look for a device.
+func (c *StadiaController) requestScan() {
+	select {
+	case c.scan <- struct{}{}:
+	default:
 	}
+}
 
-	return (*c.device).Write([]byte{0x05, largeMotor, largeMotor, smallMotor, smallMotor})
+func (c *StadiaController) onDeviceArrival(path string) {
+	c.requestScan()
 }
 
-var RetryError = errors.New("retry")
+func (c *StadiaController) onDeviceRemoval(path string) {
+	c.mu.Lock()
+	device, devicePath := c.device, c.devicePath
+	c.mu.Unlock()
 
-func (c *StadiaController) GetReport() (Xbox360ControllerReport, error) {
-	report := Xbox360ControllerReport{}
+	if device != nil && strings.EqualFold(path, devicePath) {
+		c.disconnect(device, fmt.Errorf("device %s: %w", devicePath, ErrDeviceRemoved))
+	}
+}
 
-	if c.device == nil {
-		err := c.err
-		if err == nil {
-			err = RetryError
+// discover opens the first Stadia controller it finds, unless a device is
+// already open.
+func (c *StadiaController) discover() {
+	if device, _ := c.state(); device != nil {
+		// Without device notifications, a removed device is only noticed
+		// once its pending read fails, which may take a while.
+		if c.ticker != nil {
+			c.checkDevicePresent()
 		}
-		return report, err
+
+		return
 	}
 
-	buf, ok := <-(*c.device).ReadCh()
+	c.mu.Lock()
+	retryAt := c.retryAt
+	c.mu.Unlock()
 
-	if !ok {
-		err := (*c.device).ReadError()
-		log.Printf("unable to read from controller: %v", err)
-		log.Printf("waiting for new controller")
-		(*c.device).Close()
-		c.device = nil
-		return report, RetryError
+	if time.Now().Before(retryAt) {
+		return
 	}
 
-	err := ParseReport(buf, &report)
+	var devices []*DeviceInfo
+	var err error
+
+	if c.wantedPath != "" {
+		// Open exactly the wanted device, whatever its IDs. If it cannot be
+		// queried, it is most likely not plugged in.
+		if device, err := ByPath(c.wantedPath); err == nil {
+			devices = []*DeviceInfo{device}
+		}
+	} else {
+		devices, err = c.enumerate()
+	}
 
+	c.mu.Lock()
 	if err != nil {
-		log.Printf("unable to parse controller report: %v", err)
-		return report, RetryError
+		c.err = &discoveryError{err}
+	} else {
+		c.err = nil
 	}
+	c.mu.Unlock()
 
-	return report, nil
+	if err != nil {
+		c.limiter.Printf("discovery", "cannot enumerate devices, retrying: %v", err)
+
+		c.eventsMu.Lock()
+		c.send(ErrorEvent{&discoveryError{err}})
+		c.eventsMu.Unlock()
+
+		c.scanLater()
+
+		return
+	}
+
+	var candidates []*DeviceInfo
+
+	for _, device := range devices {
+		if c.wants(device) {
+			candidates = append(candidates, device)
+		}
+	}
+
+	candidates, bluetooth := withoutBluetooth(candidates)
+
+	if len(candidates) == 0 {
+		if bluetooth > 0 {
+			c.limiter.Printf("discovery", "found a Stadia controller connected over Bluetooth, which is not supported yet; connect it with a USB cable")
+		}
+		return
+	}
+
+	device, reason := candidates[0], "it has the requested path"
+
+	if c.wantedPath == "" {
+		var gamepads []*DeviceInfo
+
+		if gamepads, reason = gamepadInterfaces(candidates); len(gamepads) == 0 {
+			c.limiter.Printf("discovery", "found %d Stadia controller interfaces, but none of them looks like a gamepad", len(candidates))
+			return
+		}
+
+		device = gamepads[0]
+	}
+
+	c.logger.Printf("using interface %s, since %s", device.Path, reason)
+
+	device.ReadTimeout = c.readTimeout
+	openDevice, err := device.Open()
+
+	if err != nil {
+		c.limiter.Printf("discovery", "cannot open device %s: %v", device.Path, err)
+		c.scanLater()
+
+		return
+	}
+
+	c.logger.Printf("opened %s, version %#04x (firmware %s)", device.Product, device.VersionNumber, formatFirmwareVersion(device.VersionNumber))
+	c.checkFirmware(device)
+	c.connect(*device, openDevice)
 }
 
-func ParseReport(data []byte, report *Xbox360ControllerReport) error {
-	if len(data) == 0 {
-		return errors.New("cannot parse empty report")
-	}
-
-	if data[0] == 0x03 && len(data) >= 10 {
-		a := data[1]
-		b := data[2]
-		c := data[3]
-
-		// Update common buttons.
-		report.MaybeSetButton(Xbox360ControllerButtonA, (c&0b0100_0000) != 0)
-		report.MaybeSetButton(Xbox360ControllerButtonB, (c&0b0010_0000) != 0)
-		report.MaybeSetButton(Xbox360ControllerButtonX, (c&0b0001_0000) != 0)
-		report.MaybeSetButton(Xbox360ControllerButtonY, (c&0b0000_1000) != 0)
-		report.MaybeSetButton(Xbox360ControllerButtonLeftShoulder, (c&0b0000_0100) != 0)
-		report.MaybeSetButton(Xbox360ControllerButtonRightShoulder, (c&0b0000_0010) != 0)
-		report.MaybeSetButton(Xbox360ControllerButtonLeftThumb, (c&0b0000_0001) != 0)
-		report.MaybeSetButton(Xbox360ControllerButtonRightThumb, (b&0b1000_0000) != 0)
-		report.MaybeSetButton(Xbox360ControllerButtonBack, (b&0b0100_0000) != 0)
-		report.MaybeSetButton(Xbox360ControllerButtonStart, (b&0b0010_0000) != 0)
-		report.MaybeSetButton(Xbox360ControllerButtonGuide, (b&0b0001_0000) != 0)
-
-		report.Assistant = (b & 0b0000_0010) != 0
-		report.Capture = (b & 0b0000_0001) != 0
-
-		// Update DPad buttons.
-		switch a {
-		case 0:
-			report.SetButton(Xbox360ControllerButtonUp)
-		case 1:
-			report.SetButton(Xbox360ControllerButtonUp)
-			report.SetButton(Xbox360ControllerButtonRight)
-		case 2:
-			report.SetButton(Xbox360ControllerButtonRight)
-		case 3:
-			report.SetButton(Xbox360ControllerButtonRight)
-			report.SetButton(Xbox360ControllerButtonDown)
-		case 4:
-			report.SetButton(Xbox360ControllerButtonDown)
-		case 5:
-			report.SetButton(Xbox360ControllerButtonDown)
-			report.SetButton(Xbox360ControllerButtonLeft)
-		case 6:
-			report.SetButton(Xbox360ControllerButtonLeft)
-		case 7:
-			report.SetButton(Xbox360ControllerButtonLeft)
-			report.SetButton(Xbox360ControllerButtonUp)
-		}
-
-		// Normalize axes values.
-		// Port of https://github.com/MWisBest/StadiEm.
-		for i := 4; i < 8; i++ {
-			if data[i] <= 0x7F && data[i] > 0x00 {
-				data[i]--
-			}
+// checkDevicePresent drops the open device if it is no longer enumerated.
+func (c *StadiaController) checkDevicePresent() {
+	c.mu.Lock()
+	devicePath := c.devicePath
+	c.mu.Unlock()
+
+	devices, err := c.enumerate()
+
+	if err != nil || devicePath == "" {
+		return
+	}
+
+	for _, device := range devices {
+		if strings.EqualFold(device.Path, devicePath) {
+			return
+		}
+	}
+
+	c.onDeviceRemoval(devicePath)
+}
+
+// wants returns whether the given device should be opened.
+func (c *StadiaController) wants(device *DeviceInfo) bool {
+	if c.wantedPath != "" {
+		return strings.EqualFold(device.Path, c.wantedPath)
+	}
+
+	return isStadiaController(device, c.deviceIDs) &&
+		(c.wantedSerial == "" || device.SerialNumber == c.wantedSerial)
+}
+
+func isStadiaController(device *DeviceInfo, ids []DeviceID) bool {
+	for _, id := range ids {
+		if device.VendorID == id.Vendor && device.ProductID == id.Product {
+			return true
+		}
+	}
+
+	return false
+}
+
+const (
+	usagePageGenericDesktop = 0x01
+	usageGamepad            = 0x05
+)
+
+// gamepadInterfaces returns the given interfaces of Stadia controllers from
+// which reports can be read, along with why they were chosen.
+//
+// A controller exposes several HID collections with the same IDs, and only
+// the gamepad collection sends 0x03 reports. It is recognized by its usage,
+// or, for firmwares which report other usages, by input reports long enough
+// to be 0x03 reports.
+func gamepadInterfaces(devices []*DeviceInfo) ([]*DeviceInfo, string) {
+	var gamepads, longReports []*DeviceInfo
+
+	for _, device := range devices {
+		if device.UsagePage == usagePageGenericDesktop && device.Usage == usageGamepad {
+			gamepads = append(gamepads, device)
+		} else if device.InputReportLength >= 10 {
+			longReports = append(longReports, device)
 		}
+	}
+
+	if len(gamepads) > 0 {
+		return gamepads, "it is a gamepad collection"
+	}
+
+	return longReports, "it has no gamepad collection, but this interface has input reports of at least 10 bytes"
+}
+
+// withoutBluetooth drops the Bluetooth interfaces of the given devices,
+// returning the remaining devices and the number of dropped interfaces.
+//
+// The reports sent over Bluetooth are not decoded yet, so a controller
+// connected over Bluetooth would never send any input; it is only used once
+// connected over USB.
+func withoutBluetooth(devices []*DeviceInfo) ([]*DeviceInfo, int) {
+	var usb []*DeviceInfo
+
+	for _, device := range devices {
+		if !device.IsBluetooth() {
+			usb = append(usb, device)
+		}
+	}
+
+	return usb, len(devices) - len(usb)
+}
+
+// DiscoverStadiaControllers returns a controller for each Stadia controller
+// currently connected over USB, created with NewStadiaController and the given
+// options. Each controller only opens its own device, and reopens it if it
+// is reconnected, on any port if the device has a serial number, and on the
+// same port otherwise.
+func DiscoverStadiaControllers(opts ...Option) ([]*StadiaController, error) {
+	devices, err := Devices()
+
+	if err != nil {
+		return nil, &discoveryError{err}
+	}
 
-		// Set axes values.
-		lThumbX := convertAxisValue(data[4]) - 0x8000
-		lThumbY := -convertAxisValue(data[5]) + 0x7fff
-		rThumbX := convertAxisValue(data[6]) - 0x8000
-		rThumbY := -convertAxisValue(data[7]) + 0x7fff
+	ids := newOptions(opts).deviceIDs
 
-		if lThumbY == -1 {
-			lThumbY = 0
+	var stadiaDevices []*DeviceInfo
+
+	for _, device := range devices {
+		if isStadiaController(device, ids) {
+			stadiaDevices = append(stadiaDevices, device)
 		}
-		if rThumbY == -1 {
-			rThumbY = 0
+	}
+
+	stadiaDevices, _ = withoutBluetooth(stadiaDevices)
+	gamepads, _ := gamepadInterfaces(stadiaDevices)
+
+	var controllers []*StadiaController
+
+	for _, device := range gamepads {
+		// Prefer the serial number, which survives moving the device to
+		// another port.
+		only := WithDevicePath(device.Path)
+
+		if device.SerialNumber != "" {
+			only = WithSerialNumber(device.SerialNumber)
 		}
 
-		report.SetLeftThumb(int16(lThumbX), int16(lThumbY))
-		report.SetRightThumb(int16(rThumbX), int16(rThumbY))
+		controllerOpts := append(opts[:len(opts):len(opts)], only)
+		controllers = append(controllers, NewStadiaController(controllerOpts...))
+	}
+
+	return controllers, nil
+}
+
+// state returns a consistent snapshot of the current device and error.
+func (c *StadiaController) state() (Device, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.device, c.err
+}
+
+// connect makes the given device the open device, and starts reading from it.
+func (c *StadiaController) connect(info DeviceInfo, device Device) {
+	c.eventsMu.Lock()
+	c.mu.Lock()
+	c.device = device
+	c.devicePath = info.Path
+	c.deviceInfo = info
+	c.mu.Unlock()
+
+	atomic.AddUint64(&c.counters.connects, 1)
 
-		// Set triggers.
-		report.SetLeftTrigger(data[8])
-		report.SetRightTrigger(data[9])
+	if c.onConnect != nil {
+		c.onConnect(info)
+	}
+	c.send(ConnectedEvent{info})
+	c.eventsMu.Unlock()
+
+	go c.readReports(device, info)
+}
 
+// dropDevice closes the given device and forgets it, unless it was already
+// replaced by another device. It returns whether the device was dropped.
+func (c *StadiaController) dropDevice(device Device) (bool, error) {
+	c.deviceMu.Lock()
+	defer c.deviceMu.Unlock()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.device != device {
+		return false, nil
+	}
+
+	if counter, ok := device.(DropCounter); ok {
+		atomic.AddUint64(&c.counters.droppedReports, counter.DroppedReports())
+	}
+
+	err := device.Close()
+	c.device = nil
+	c.devicePath = ""
+	c.deviceInfo = DeviceInfo{}
+
+	return true, err
+}
+
+// disconnect drops the given device like dropDevice, notifying the
+// disconnect handler and sending a DisconnectedEvent with the given reason if
+// it was dropped.
+func (c *StadiaController) disconnect(device Device, reason error) {
+	c.eventsMu.Lock()
+	defer c.eventsMu.Unlock()
+
+	if dropped, _ := c.dropDevice(device); !dropped {
+		return
+	}
+
+	if c.onDisconnect != nil {
+		c.onDisconnect(reason)
+	}
+	c.send(DisconnectedEvent{reason})
+}
+
+// loadCalibration returns the calibration saved for the given device if
+// WithSavedCalibration was given, or nil.
+func (c *StadiaController) loadCalibration(info DeviceInfo) *Calibration {
+	if !c.useCalibration {
 		return nil
 	}
 
-	return fmt.Errorf("unknown report format; raw report was %s", base64.StdEncoding.EncodeToString(data))
+	calibration, err := LoadCalibration(info.SerialNumber)
+
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			c.logger.Printf("cannot use saved calibration: %v", err)
+		}
+		return nil
+	}
+
+	return calibration
+}
+
+// parseErrorInterval is the minimum interval between two ErrorEvents sent
+// because reports cannot be parsed.
+const parseErrorInterval = 1 * time.Minute
+
+// readReports sends the reports read from the given device as events until
+// it is closed.
+func (c *StadiaController) readReports(device Device, info DeviceInfo) {
+	lastReport := Xbox360ControllerReport{}
+	lastParseError := time.Time{}
+	hasRead := false
+	calibration := c.loadCalibration(info)
+	filter := stickFilter{}
+	layers := layerTracker{}
+	center := autoCenter{until: time.Now().Add(c.autoCenter), sampling: c.autoCenter > 0}
+
+	if c.chords != nil {
+		c.chords.Reset()
+	}
+
+	// Chords held for some time must be performed even if no report is
+	// received meanwhile.
+	var chordTimer <-chan time.Time
+	var chordDeadline time.Time
+
+	reports := device.ReadCh()
+
+read:
+	for {
+		var buf []byte
+
+		select {
+		case b, ok := <-reports:
+			if !ok {
+				break read
+			}
+
+			buf = b
+
+		case <-chordTimer:
+			chordTimer, chordDeadline = nil, time.Time{}
+			c.performChords(c.chords.Poll(time.Now()))
+			continue
+		}
+
+		if c.recorder != nil {
+			c.recorder.record(buf)
+		}
+
+		var raw StadiaReport
+
+		if err := ParseStadiaReport(buf, &raw); err != nil {
+			if errors.Is(err, ErrIgnoredReport) {
+				continue
+			}
+
+			atomic.AddUint64(&c.counters.parseErrors, 1)
+
+			// Malformed reports may be sent many times a second, so only
+			// report them once in a while.
+			if now := time.Now(); now.Sub(lastParseError) >= parseErrorInterval {
+				lastParseError = now
+				c.sendFromDevice(device, ErrorEvent{fmt.Errorf("unable to parse controller report: %w", err)})
+			}
+			continue
+		}
+
+		atomic.AddUint64(&c.counters.reports, 1)
+		c.rate.add(time.Now())
+
+		if !hasRead {
+			c.backoff.Reset()
+			hasRead = true
+		}
+
+		config := c.getConfig()
+		calibrated := raw
+		calibration.Apply(&calibrated)
+		reportEvent := ReportEvent{Raw: raw, Calibrated: calibrated}
+
+		if c.chords != nil {
+			c.performChords(c.chords.Apply(&calibrated, time.Now()))
+
+			if deadline, ok := c.chords.Deadline(); !ok {
+				chordTimer, chordDeadline = nil, time.Time{}
+			} else if !deadline.Equal(chordDeadline) {
+				chordTimer, chordDeadline = time.After(time.Until(deadline)), deadline
+			}
+		}
+
+		if config != nil {
+			filter.apply(&calibrated, config.StickSmoothing)
+		}
+		if center.sampling || center.offsets != (StickOffsets{}) {
+			config = center.apply(c.limiter, &calibrated, config)
+		}
+
+		report := toXbox360Report(&calibrated, config, layers)
+		reportEvent.Report = report
+
+		events := appendButtonEvents([]Event{reportEvent}, &lastReport, &report, c.triggerThreshold)
+		lastReport = report
+
+		c.sendFromDevice(device, events...)
+	}
+
+	c.disconnect(device, device.ReadError())
+
+	// Look for the device again right away if it was working, e.g. if it was
+	// simply unplugged, but back off if it keeps failing.
+	if hasRead {
+		c.requestScan()
+	} else {
+		c.scanLater()
+	}
+}
+
+// performChords performs the given chords recognized by readReports.
+func (c *StadiaController) performChords(chords []Chord) {
+	for _, chord := range chords {
+		if chord.Action == ChordPause {
+			c.TogglePaused()
+		}
+		if c.onChord != nil {
+			c.onChord(chord)
+		}
+	}
+}
+
+// Reopen closes the open device, if any, so that it is looked for and opened
+// again as if it had been unplugged. A DisconnectedEvent is sent meanwhile.
+func (c *StadiaController) Reopen() {
+	if device, _ := c.state(); device != nil {
+		c.disconnect(device, ErrReopened)
+	}
+}
+
+// ErrReopened is the reason of the DisconnectedEvent sent by Reopen.
+var ErrReopened = errors.New("controller reopened")
+
+// Close closes the open device, if any, and stops looking for devices. It
+// may be called several times, and always returns the error encountered by
+// the first call.
+func (c *StadiaController) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.done)
+
+		c.vibrationMu.Lock()
+		c.vibrationGen++
+		c.stopVibrationTimer()
+		c.vibrationMu.Unlock()
+
+		if c.ticker != nil {
+			c.ticker.Stop()
+		}
+		if c.watcher != nil {
+			c.closeErr = c.watcher.Close()
+		}
+
+		if device, _ := c.state(); device != nil {
+			if _, err := c.dropDevice(device); err != nil {
+				c.closeErr = err
+			}
+		}
+
+		c.eventsMu.Lock()
+		close(c.events)
+		c.eventsMu.Unlock()
+	})
+
+	return c.closeErr
+}
+
+// SetConfig sets the configuration used to parse the reports returned by
+// GetReport.
+func (c *StadiaController) SetConfig(config *ParseConfig) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.config = config
+}
+
+// SetProfile is like SetConfig, but also makes the given profile the one
+// returned by ActiveProfile. Both are swapped at once, so that reports are
+// never parsed with the config of one profile while another is active.
+func (c *StadiaController) SetProfile(name string, config *ParseConfig) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.config = config
+	c.profile = name
+}
+
+// ActiveProfile returns the name of the profile set by SetProfile, or an
+// empty string if none was set.
+func (c *StadiaController) ActiveProfile() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.profile
+}
+
+// getConfig returns the configuration set by SetConfig.
+func (c *StadiaController) getConfig() *ParseConfig {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.config
+}
+
+var RetryError = errors.New("retry")
+
+// ErrClosed is returned by GetReport once the controller is closed.
+var ErrClosed = errors.New("controller closed")
+
+// ErrDiscoveryFailed is matched by the errors returned by GetReport while
+// devices cannot be enumerated. Discovery is retried in the background, so
+// these errors also match RetryError, and they wrap the enumeration error.
+var ErrDiscoveryFailed = errors.New("device discovery failed")
+
+type discoveryError struct {
+	err error
+}
+
+func (e *discoveryError) Error() string {
+	return fmt.Sprintf("%v: %v", ErrDiscoveryFailed, e.err)
+}
+
+func (e *discoveryError) Unwrap() error {
+	return e.err
+}
+
+func (e *discoveryError) Is(target error) bool {
+	return target == ErrDiscoveryFailed || target == RetryError
+}
+
+// ErrDisconnected is matched by the error returned by GetReport when the
+// device was lost. It also matches RetryError, and wraps the reason why the
+// device was lost.
+var ErrDisconnected = errors.New("controller disconnected")
+
+type disconnectedError struct {
+	err error
+}
+
+func (e *disconnectedError) Error() string {
+	return fmt.Sprintf("%v: %v", ErrDisconnected, e.err)
+}
+
+func (e *disconnectedError) Unwrap() error {
+	return e.err
+}
+
+func (e *disconnectedError) Is(target error) bool {
+	return target == ErrDisconnected || target == RetryError
+}
+
+func (c *StadiaController) GetReport() (Xbox360ControllerReport, error) {
+	return c.GetReportContext(context.Background())
+}
+
+// GetReportContext is like GetReport, but returns ctx.Err() if ctx is done
+// before a report is received.
+func (c *StadiaController) GetReportContext(ctx context.Context) (Xbox360ControllerReport, error) {
+	var report Xbox360ControllerReport
+
+	err := c.GetReportInto(ctx, &report)
+
+	return report, err
+}
+
+// GetReportInto is like GetReportContext, but fills the given report instead
+// of returning one, so that a single report can be reused. The report is
+// reset if an error is returned.
+func (c *StadiaController) GetReportInto(ctx context.Context, report *Xbox360ControllerReport) error {
+	report.Reset()
+
+	for {
+		select {
+		case event, ok := <-c.events:
+			if !ok {
+				return ErrClosed
+			}
+
+			switch event := event.(type) {
+			case ReportEvent:
+				c.mu.Lock()
+				c.lastReport = event.Report
+				paused := c.paused
+				c.mu.Unlock()
+
+				if paused {
+					continue
+				}
+
+				*report = event.Report
+				return nil
+
+			case DisconnectedEvent:
+				// Do not return the state of the lost controller once resumed.
+				c.mu.Lock()
+				c.lastReport = NewXbox360ControllerReport()
+				c.mu.Unlock()
+
+				return &disconnectedError{event.Err}
+
+			case ErrorEvent:
+				if errors.Is(event.Err, RetryError) {
+					return event.Err
+				}
+
+				c.limiter.Printf("report", "%v", event.Err)
+				return RetryError
+			}
+
+		case <-c.pauseChanged:
+			c.mu.Lock()
+			paused, lastReport := c.paused, c.lastReport
+			c.mu.Unlock()
+
+			if !paused {
+				*report = lastReport
+			}
+
+			return nil
+
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// ParseReport parses an input report sent by a Stadia controller into the
+// given Xbox 360 controller report. It is equivalent to ParseStadiaReport
+// followed by ToXbox360Report.
+func ParseReport(data []byte, report *Xbox360ControllerReport) error {
+	return ParseReportWithConfig(data, report, nil)
+}
+
+// ParseReportWithConfig parses a report like ParseReport, and then applies
+// the given configuration to it. cfg may be nil.
+func ParseReportWithConfig(data []byte, report *Xbox360ControllerReport, cfg *ParseConfig) error {
+	var stadiaReport StadiaReport
+
+	if err := ParseStadiaReport(data, &stadiaReport); err != nil {
+		return err
+	}
+
+	*report = ToXbox360ReportWithConfig(&stadiaReport, cfg)
+
+	return nil
 }
 
 func convertAxisValue(byteValue byte) int32 {
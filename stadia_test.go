@@ -0,0 +1,389 @@
+package stadiacontroller
+
+import (
+	"errors"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// collectEvents returns the events sent by the given controller until its
+// device is disconnected.
+func collectEvents(t *testing.T, c *StadiaController) []Event {
+	t.Helper()
+
+	var events []Event
+	timeout := time.After(5 * time.Second)
+
+	for {
+		select {
+		case event := <-c.Events():
+			events = append(events, event)
+
+			if _, ok := event.(DisconnectedEvent); ok {
+				return events
+			}
+
+		case <-timeout:
+			t.Fatalf("device was not disconnected; events were %v", events)
+		}
+	}
+}
+
+func TestReadReportsUnknownReports(t *testing.T) {
+	input := []byte{stadiaInputReportID, 8, 0, 0, 0x80, 0x80, 0x80, 0x80, 0, 0}
+	unknown := []byte{0x07, 0x01, 0x02, 0x03}
+	truncated := []byte{stadiaInputReportID, 8, 0, 0}
+
+	for _, test := range []struct {
+		name       string
+		reports    [][]byte
+		wantErrors int
+	}{
+		{"unknown reports are skipped", [][]byte{unknown, input, unknown}, 0},
+		{"malformed input reports are reported", [][]byte{truncated, input}, 1},
+	} {
+		c := newStadiaController(newOptions([]Option{WithLogger(nil)}))
+		c.connect(DeviceInfo{}, NewMockDevice(test.reports, 0))
+
+		var errs []error
+		reports := 0
+
+		for _, event := range collectEvents(t, c) {
+			switch event := event.(type) {
+			case ErrorEvent:
+				errs = append(errs, event.Err)
+			case ReportEvent:
+				reports++
+			}
+		}
+
+		c.Close()
+
+		if reports != 1 {
+			t.Errorf("%s: got %d reports, want 1", test.name, reports)
+		}
+		if len(errs) != test.wantErrors {
+			t.Errorf("%s: got errors %v, want %d", test.name, errs, test.wantErrors)
+		}
+		for _, err := range errs {
+			if errors.Is(err, ErrIgnoredReport) {
+				t.Errorf("%s: got error %v for an ignored report", test.name, err)
+			}
+		}
+		if stats := c.Stats(); stats.ParseErrors != uint64(test.wantErrors) {
+			t.Errorf("%s: got %d parse errors, want %d", test.name, stats.ParseErrors, test.wantErrors)
+		}
+	}
+}
+
+func TestWithoutBluetooth(t *testing.T) {
+	const bluetooth = `\\?\hid#{00001124-0000-1000-8000-00805f9b34fb}_vid&000218d1_pid&9400#`
+
+	usbA := &DeviceInfo{Path: `\\?\hid#vid_18d1&pid_9400#a`, SerialNumber: "A"}
+	usbB := &DeviceInfo{Path: `\\?\hid#vid_18d1&pid_9400#b`, SerialNumber: "B"}
+	btA := &DeviceInfo{Path: bluetooth + "a", SerialNumber: "A"}
+	btC := &DeviceInfo{Path: bluetooth + "c", SerialNumber: "C"}
+
+	for _, test := range []struct {
+		name        string
+		devices     []*DeviceInfo
+		want        []*DeviceInfo
+		wantDropped int
+	}{
+		{"usb only", []*DeviceInfo{usbA, usbB}, []*DeviceInfo{usbA, usbB}, 0},
+		{"bluetooth only", []*DeviceInfo{btA, btC}, nil, 2},
+		{"same controller on both", []*DeviceInfo{btA, usbA}, []*DeviceInfo{usbA}, 1},
+		{"different controllers", []*DeviceInfo{btC, usbB}, []*DeviceInfo{usbB}, 1},
+	} {
+		got, dropped := withoutBluetooth(test.devices)
+
+		if dropped != test.wantDropped {
+			t.Errorf("%s: dropped %d devices, want %d", test.name, dropped, test.wantDropped)
+		}
+		if len(got) != len(test.want) {
+			t.Errorf("%s: withoutBluetooth returned %d devices, want %d", test.name, len(got), len(test.want))
+			continue
+		}
+		for i := range got {
+			if got[i] != test.want[i] {
+				t.Errorf("%s: device %d is %s, want %s", test.name, i, got[i].Path, test.want[i].Path)
+			}
+		}
+	}
+}
+
+func TestDiscoveryIgnoresBluetooth(t *testing.T) {
+	logger := &recordingLogger{}
+	bluetooth := &DeviceInfo{
+		Path:      `\\?\hid#{00001124-0000-1000-8000-00805f9b34fb}_vid&000218d1_pid&9400#a`,
+		VendorID:  stadiaControllerVid,
+		ProductID: stadiaControllerPid,
+		UsagePage: usagePageGenericDesktop,
+		Usage:     usageGamepad,
+	}
+
+	c := newStadiaController(newOptions([]Option{
+		WithLogger(logger),
+		withEnumerator(func() ([]*DeviceInfo, error) { return []*DeviceInfo{bluetooth}, nil }),
+	}))
+	defer c.Close()
+
+	c.discover()
+
+	if device, err := c.state(); device != nil || err != nil {
+		t.Errorf("after discovering a Bluetooth controller, state is %v, %v, want no device and no error", device, err)
+	}
+	if len(logger.messages) != 1 || !strings.Contains(logger.messages[0], "USB cable") {
+		t.Errorf("logged %q, want a message asking to connect the controller over USB", logger.messages)
+	}
+}
+
+func TestGamepadInterfaces(t *testing.T) {
+	vendor := &DeviceInfo{Path: `\\?\hid#vid_18d1&pid_9400&mi_01#vendor`, UsagePage: 0xff00, Usage: 0x01, InputReportLength: 63}
+	consumer := &DeviceInfo{Path: `\\?\hid#vid_18d1&pid_9400&mi_02#consumer`, UsagePage: 0x0c, Usage: 0x01, InputReportLength: 2}
+	gamepad := &DeviceInfo{Path: `\\?\hid#vid_18d1&pid_9400&mi_00#gamepad`, UsagePage: usagePageGenericDesktop, Usage: usageGamepad, InputReportLength: 10}
+	shortGamepad := &DeviceInfo{Path: `\\?\hid#vid_18d1&pid_9400&mi_00#short`, UsagePage: usagePageGenericDesktop, Usage: usageGamepad, InputReportLength: 4}
+	joystick := &DeviceInfo{Path: `\\?\hid#vid_18d1&pid_9400&mi_00#joystick`, UsagePage: usagePageGenericDesktop, Usage: 0x04, InputReportLength: 10}
+	shortVendor := &DeviceInfo{Path: `\\?\hid#vid_18d1&pid_9400&mi_01#short`, UsagePage: 0xff00, Usage: 0x01, InputReportLength: 9}
+
+	for _, test := range []struct {
+		name    string
+		devices []*DeviceInfo
+		want    []*DeviceInfo
+	}{
+		{"vendor collection enumerated first", []*DeviceInfo{vendor, consumer, gamepad}, []*DeviceInfo{gamepad}},
+		{"gamepad usage wins over report length", []*DeviceInfo{shortGamepad, vendor}, []*DeviceInfo{shortGamepad}},
+		{"no gamepad usage", []*DeviceInfo{consumer, joystick, shortVendor}, []*DeviceInfo{joystick}},
+		{"boundary report length", []*DeviceInfo{shortVendor}, nil},
+		{"no collection", []*DeviceInfo{consumer}, nil},
+		{"no device", nil, nil},
+	} {
+		got, reason := gamepadInterfaces(test.devices)
+
+		if len(got) != len(test.want) {
+			t.Errorf("%s: gamepadInterfaces returned %d devices, want %d", test.name, len(got), len(test.want))
+			continue
+		}
+		for i := range got {
+			if got[i] != test.want[i] {
+				t.Errorf("%s: device %d is %s, want %s", test.name, i, got[i].Path, test.want[i].Path)
+			}
+		}
+		if reason == "" {
+			t.Errorf("%s: gamepadInterfaces gave no reason", test.name)
+		}
+	}
+}
+
+// withEnumerator makes the controller list devices with the given function
+// instead of Devices.
+func withEnumerator(enumerate func() ([]*DeviceInfo, error)) Option {
+	return func(o *options) {
+		o.enumerate = enumerate
+	}
+}
+
+func TestDiscoveryRetriesAfterErrors(t *testing.T) {
+	errEnumeration := errors.New("enumeration failed")
+	calls := 0
+
+	c := newStadiaController(newOptions([]Option{withEnumerator(func() ([]*DeviceInfo, error) {
+		calls++
+
+		if calls <= 2 {
+			return nil, errEnumeration
+		}
+		return nil, nil
+	})}))
+	defer c.Close()
+
+	for i := 1; i <= 3; i++ {
+		c.discover()
+
+		_, err := c.state()
+
+		if i <= 2 {
+			if !errors.Is(err, ErrDiscoveryFailed) || !errors.Is(err, errEnumeration) {
+				t.Errorf("after failure %d, err = %v, want ErrDiscoveryFailed wrapping the enumeration error", i, err)
+			}
+		} else if err != nil {
+			t.Errorf("after success, err = %v, want nil", err)
+		}
+	}
+
+	for i := 0; i < 2; i++ {
+		select {
+		case event := <-c.Events():
+			if event, ok := event.(ErrorEvent); !ok || !errors.Is(event.Err, ErrDiscoveryFailed) {
+				t.Errorf("event %d = %#v, want an ErrorEvent matching ErrDiscoveryFailed", i, event)
+			}
+		default:
+			t.Fatalf("got %d error events, want 2", i)
+		}
+	}
+}
+
+func TestDiscoveryBacksOffWhenPolling(t *testing.T) {
+	calls := 0
+
+	c := newStadiaController(newOptions([]Option{
+		WithLogger(nil),
+		WithBackoff(time.Hour, time.Hour),
+		withEnumerator(func() ([]*DeviceInfo, error) {
+			calls++
+			return nil, errors.New("enumeration failed")
+		}),
+	}))
+	defer c.Close()
+
+	// Poll, but never tick: scans are requested by calling discover.
+	c.ticker = time.NewTicker(time.Hour)
+
+	for i := 0; i < 5; i++ {
+		c.discover()
+	}
+
+	if calls != 1 {
+		t.Errorf("devices were enumerated %d times during the backoff delay, want 1", calls)
+	}
+
+	c.mu.Lock()
+	c.retryAt = time.Time{}
+	c.mu.Unlock()
+
+	c.discover()
+
+	if calls != 2 {
+		t.Errorf("devices were enumerated %d times after the backoff delay, want 2", calls)
+	}
+}
+
+// waitForGoroutines waits until at most the given number of goroutines run,
+// returning the final count.
+func waitForGoroutines(max int) int {
+	deadline := time.Now().Add(5 * time.Second)
+
+	for {
+		n := runtime.NumGoroutine()
+
+		if n <= max || time.Now().After(deadline) {
+			return n
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestCloseStopsGoroutines(t *testing.T) {
+	input := []byte{stadiaInputReportID, 8, 0, 0, 0x80, 0x80, 0x80, 0x80, 0, 0}
+
+	for _, test := range []struct {
+		name string
+		open func() *StadiaController
+	}{
+		{"no device found", func() *StadiaController {
+			return NewStadiaController(
+				WithLogger(nil),
+				WithPolling(),
+				WithPollInterval(time.Millisecond),
+				withEnumerator(func() ([]*DeviceInfo, error) { return nil, nil }),
+			)
+		}},
+		{"enumeration failing", func() *StadiaController {
+			return NewStadiaController(
+				WithLogger(nil),
+				WithPolling(),
+				WithPollInterval(time.Millisecond),
+				WithBackoff(time.Millisecond, time.Millisecond),
+				withEnumerator(func() ([]*DeviceInfo, error) { return nil, errors.New("enumeration failed") }),
+			)
+		}},
+		{"device reading", func() *StadiaController {
+			reports := make([][]byte, 1000)
+
+			for i := range reports {
+				reports[i] = input
+			}
+
+			c := NewStadiaControllerWithDevice(NewMockDevice(reports, time.Millisecond), WithLogger(nil))
+
+			// Wait for the device to be read from, and keep it reading.
+			for event := range c.Events() {
+				if _, ok := event.(ReportEvent); ok {
+					break
+				}
+			}
+
+			return c
+		}},
+	} {
+		before := runtime.NumGoroutine()
+
+		for i := 0; i < 10; i++ {
+			c := test.open()
+			c.Vibrate(0xff, 0xff)
+			time.Sleep(5 * time.Millisecond)
+
+			if err := c.Close(); err != nil {
+				t.Errorf("%s: Close() = %v", test.name, err)
+			}
+			if err := c.Close(); err != nil {
+				t.Errorf("%s: second Close() = %v", test.name, err)
+			}
+		}
+
+		if after := waitForGoroutines(before); after > before {
+			buf := make([]byte, 1<<16)
+			t.Errorf("%s: %d goroutines before, %d after Close:\n%s", test.name, before, after, buf[:runtime.Stack(buf, true)])
+		}
+	}
+}
+
+func TestVibrateDuringDisconnect(t *testing.T) {
+	input := []byte{stadiaInputReportID, 8, 0, 0, 0x80, 0x80, 0x80, 0x80, 0, 0}
+
+	for i := 0; i < 10; i++ {
+		reports := [][]byte{input, input, input, input, input}
+		c := NewStadiaControllerWithDevice(NewMockDevice(reports, time.Millisecond), WithLogger(nil))
+
+		// Vibrate and read the state from other goroutines while the device
+		// is lost and dropped, which must neither race nor crash.
+		stop := make(chan struct{})
+		done := make(chan struct{})
+
+		go func() {
+			defer close(done)
+
+			for strength := byte(0); ; strength++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+
+				c.Vibrate(strength, strength)
+				c.VibratePattern([]VibrationStep{{strength, 0, time.Millisecond}})
+				c.Stats()
+			}
+		}()
+
+		for {
+			if _, err := c.GetReport(); err != nil {
+				if !errors.Is(err, ErrDisconnected) {
+					t.Errorf("GetReport() = %v, want ErrDisconnected", err)
+				}
+				break
+			}
+		}
+
+		if err := c.Vibrate(0xff, 0xff); err != nil {
+			t.Errorf("Vibrate() after disconnect = %v, want nil", err)
+		}
+
+		close(stop)
+		<-done
+		c.Close()
+	}
+}
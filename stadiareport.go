@@ -0,0 +1,300 @@
+package stadiacontroller
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+)
+
+// ErrIgnoredReport is matched by the error returned by ParseStadiaReport for
+// reports which are not 0x03 input reports. These reports carry no input and
+// should simply be skipped.
+var ErrIgnoredReport = errors.New("ignored report")
+
+// A StadiaReport is the state of a Stadia controller, as decoded from one of
+// its input reports by ParseStadiaReport.
+type StadiaReport struct {
+	DpadUp    bool
+	DpadDown  bool
+	DpadLeft  bool
+	DpadRight bool
+
+	A bool
+	B bool
+	X bool
+	Y bool
+
+	L1 bool
+	R1 bool
+	L3 bool
+	R3 bool
+
+	Menu         bool
+	Options      bool
+	StadiaButton bool
+	Capture      bool
+	Assistant    bool
+
+	// Sticks are reported as sent by the controller, from 0x00 (left or up)
+	// to 0xff (right or down), with 0x80 at the center.
+	LeftX  byte
+	LeftY  byte
+	RightX byte
+	RightY byte
+
+	L2 byte
+	R2 byte
+}
+
+// stadiaInputReportID is the report number of the input report of Stadia
+// controllers, which use numbered reports.
+const stadiaInputReportID = 0x03
+
+// stadiaInputReportLength is the length of the payload of the input report,
+// without its report number.
+const stadiaInputReportLength = 9
+
+// ParseStadiaReport decodes an input report sent by a Stadia controller.
+//
+// Stadia controllers use numbered reports, so the first byte of data is the
+// report number, as returned by Device.ReadCh, and the payload follows it.
+//
+// Only the 0x03 input report sent over USB is supported; bytes following its
+// payload are ignored. The reports sent over Bluetooth have not been captured
+// yet, so they are not decoded.
+//
+// Reports with another ID are rejected with an error matching
+// ErrIgnoredReport. Malformed 0x03 reports are rejected with another error.
+// Both errors include the raw report, so that it can be recorded with
+// WithRecorder and supported later.
+func ParseStadiaReport(data []byte, report *StadiaReport) error {
+	if len(data) == 0 {
+		return errors.New("cannot parse empty report")
+	}
+
+	reportID, payload := data[0], data[1:]
+
+	switch reportID {
+	case stadiaInputReportID:
+		if len(payload) < stadiaInputReportLength {
+			return fmt.Errorf("unknown report format (id %#02x, %d bytes); raw report was %s", reportID, len(data), base64.StdEncoding.EncodeToString(data))
+		}
+
+		parseInputReport(payload, report)
+
+		return nil
+
+	default:
+		return fmt.Errorf("%w %#02x; raw report was %s", ErrIgnoredReport, reportID, base64.StdEncoding.EncodeToString(data))
+	}
+}
+
+// parseInputReport decodes the payload of an input report, without its
+// report number.
+func parseInputReport(payload []byte, report *StadiaReport) {
+	dpad := payload[0]
+	b := payload[1]
+	c := payload[2]
+
+	*report = StadiaReport{
+		// The dpad is reported as a direction from 0 (up) to 7 (up-left),
+		// clockwise, or 8 if released.
+		DpadUp:    dpad == 7 || dpad == 0 || dpad == 1,
+		DpadRight: dpad >= 1 && dpad <= 3,
+		DpadDown:  dpad >= 3 && dpad <= 5,
+		DpadLeft:  dpad >= 5 && dpad <= 7,
+
+		A:  (c & 0b0100_0000) != 0,
+		B:  (c & 0b0010_0000) != 0,
+		X:  (c & 0b0001_0000) != 0,
+		Y:  (c & 0b0000_1000) != 0,
+		L1: (c & 0b0000_0100) != 0,
+		R1: (c & 0b0000_0010) != 0,
+		L3: (c & 0b0000_0001) != 0,
+		R3: (b & 0b1000_0000) != 0,
+
+		Options:      (b & 0b0100_0000) != 0,
+		Menu:         (b & 0b0010_0000) != 0,
+		StadiaButton: (b & 0b0001_0000) != 0,
+		Assistant:    (b & 0b0000_0010) != 0,
+		Capture:      (b & 0b0000_0001) != 0,
+
+		LeftX:  payload[3],
+		LeftY:  payload[4],
+		RightX: payload[5],
+		RightY: payload[6],
+
+		L2: payload[7],
+		R2: payload[8],
+	}
+}
+
+// ToXbox360Report translates the given Stadia controller state to the state
+// of an Xbox 360 controller.
+func ToXbox360Report(r *StadiaReport) Xbox360ControllerReport {
+	return ToXbox360ReportWithConfig(r, nil)
+}
+
+// ToXbox360ReportWithConfig translates the given Stadia controller state like
+// ToXbox360Report, and then applies the given configuration to it. cfg may
+// be nil.
+//
+// Buttons held with the modifier of a layer of cfg use the mapping of that
+// layer. Since reports are translated independently, such buttons switch to
+// the base mapping as soon as the modifier is released; reports read by a
+// StadiaController keep the layer of each button until it is released.
+func ToXbox360ReportWithConfig(r *StadiaReport, cfg *ParseConfig) Xbox360ControllerReport {
+	return toXbox360Report(r, cfg, nil)
+}
+
+// toXbox360Report implements ToXbox360ReportWithConfig, tracking the layer
+// of held buttons with the given tracker if it is not nil.
+func toXbox360Report(r *StadiaReport, cfg *ParseConfig, layers layerTracker) Xbox360ControllerReport {
+	if cfg == nil {
+		cfg = &ParseConfig{}
+	}
+
+	report := NewXbox360ControllerReport()
+
+	maybeSetButton := func(button int, isSet bool) {
+		if !isSet {
+			layers.release(button)
+			return
+		}
+
+		for _, mapped := range cfg.buttonMap(layers.layerOf(r, cfg, button)).Map(button) {
+			report.SetButton(mapped)
+		}
+	}
+
+	// Update common buttons.
+	maybeSetButton(Xbox360ControllerButtonA, r.A)
+	maybeSetButton(Xbox360ControllerButtonB, r.B)
+	maybeSetButton(Xbox360ControllerButtonX, r.X)
+	maybeSetButton(Xbox360ControllerButtonY, r.Y)
+	maybeSetButton(Xbox360ControllerButtonLeftShoulder, r.L1)
+	maybeSetButton(Xbox360ControllerButtonRightShoulder, r.R1)
+	maybeSetButton(Xbox360ControllerButtonLeftThumb, r.L3)
+	maybeSetButton(Xbox360ControllerButtonRightThumb, r.R3)
+	maybeSetButton(Xbox360ControllerButtonBack, r.Options)
+	maybeSetButton(Xbox360ControllerButtonStart, r.Menu)
+	maybeSetButton(Xbox360ControllerButtonGuide, r.StadiaButton)
+
+	// Capture and Assistant have no Xbox 360 equivalent, so they are only
+	// set as buttons if they were explicitly mapped. The modifier of a
+	// layer only activates that layer.
+	if !cfg.isLayerModifier(ButtonAssistant) {
+		report.Assistant = r.Assistant
+
+		if _, ok := cfg.Buttons[ButtonAssistant]; ok {
+			maybeSetButton(ButtonAssistant, r.Assistant)
+		}
+	}
+	if !cfg.isLayerModifier(ButtonCapture) {
+		report.Capture = r.Capture
+
+		if _, ok := cfg.Buttons[ButtonCapture]; ok {
+			maybeSetButton(ButtonCapture, r.Capture)
+		}
+	}
+
+	// Update DPad buttons.
+	maybeSetButton(Xbox360ControllerButtonUp, r.DpadUp)
+	maybeSetButton(Xbox360ControllerButtonDown, r.DpadDown)
+	maybeSetButton(Xbox360ControllerButtonLeft, r.DpadLeft)
+	maybeSetButton(Xbox360ControllerButtonRight, r.DpadRight)
+
+	// Set axes values.
+	lThumbX, lThumbY := scaleAxisByte(r.LeftX), scaleInvertedAxisByte(r.LeftY)
+	rThumbX, rThumbY := scaleAxisByte(r.RightX), scaleInvertedAxisByte(r.RightY)
+
+	if cfg.LegacyAxisScaling {
+		lThumbX, lThumbY = legacyAxisValue(r.LeftX), legacyInvertedAxisValue(r.LeftY)
+		rThumbX, rThumbY = legacyAxisValue(r.RightX), legacyInvertedAxisValue(r.RightY)
+	}
+
+	lThumbX, lThumbY = subtractOffset(lThumbX, cfg.CenterOffset.LeftX), subtractOffset(lThumbY, cfg.CenterOffset.LeftY)
+	rThumbX, rThumbY = subtractOffset(rThumbX, cfg.CenterOffset.RightX), subtractOffset(rThumbY, cfg.CenterOffset.RightY)
+
+	if cfg.LeftCircular {
+		lThumbX, lThumbY = clampToCircle(lThumbX, lThumbY)
+	}
+	if cfg.RightCircular {
+		rThumbX, rThumbY = clampToCircle(rThumbX, rThumbY)
+	}
+
+	lThumbX, lThumbY = cfg.Deadzone.applyLeft(lThumbX, lThumbY)
+	rThumbX, rThumbY = cfg.Deadzone.applyRight(rThumbX, rThumbY)
+
+	lThumbX, lThumbY = cfg.LeftCurve.apply(lThumbX, lThumbY)
+	rThumbX, rThumbY = cfg.RightCurve.apply(rThumbX, rThumbY)
+
+	lThumbX, lThumbY = applyAntiDeadzone(lThumbX, lThumbY, cfg.LeftAntiDeadzone)
+	rThumbX, rThumbY = applyAntiDeadzone(rThumbX, rThumbY, cfg.RightAntiDeadzone)
+
+	lThumbX = maybeInvertAxisValue(lThumbX, cfg.InvertLeftX)
+	lThumbY = maybeInvertAxisValue(lThumbY, cfg.InvertLeftY)
+	rThumbX = maybeInvertAxisValue(rThumbX, cfg.InvertRightX)
+	rThumbY = maybeInvertAxisValue(rThumbY, cfg.InvertRightY)
+
+	report.SetLeftThumb(int16(lThumbX), int16(lThumbY))
+	report.SetRightThumb(int16(rThumbX), int16(rThumbY))
+
+	// Set triggers.
+	report.SetLeftTrigger(cfg.LeftTrigger.apply(r.L2))
+	report.SetRightTrigger(cfg.RightTrigger.apply(r.R2))
+
+	return report
+}
+
+// scaleAxisByte maps the byte value of an axis to the range of an Xbox 360
+// axis, with 0x00 mapped to -32768, 0x80 to 0 and 0xFF to 32767. Each half is
+// scaled linearly, so the mapping is strictly increasing.
+func scaleAxisByte(value byte) int32 {
+	offset := int32(value) - 0x80
+
+	if offset < 0 {
+		return offset * 0x8000 / 0x80
+	}
+
+	return offset * 0x7fff / 0x7f
+}
+
+// scaleInvertedAxisByte is like scaleAxisByte, but maps 0x00 to 32767 and
+// 0xFF to -32768, since the Y axes of Stadia and Xbox 360 controllers point
+// in opposite directions.
+func scaleInvertedAxisByte(value byte) int32 {
+	offset := int32(value) - 0x80
+
+	if offset < 0 {
+		return -offset * 0x7fff / 0x80
+	}
+
+	return -offset * 0x8000 / 0x7f
+}
+
+// legacyAxisValue is the mapping used before scaleAxisByte, kept for
+// ParseConfig.LegacyAxisScaling.
+func legacyAxisValue(value byte) int32 {
+	return convertAxisValue(normalizeAxisByte(value)) - 0x8000
+}
+
+// legacyInvertedAxisValue is the mapping used before
+// scaleInvertedAxisByte, kept for ParseConfig.LegacyAxisScaling.
+func legacyInvertedAxisValue(value byte) int32 {
+	if value := -convertAxisValue(normalizeAxisByte(value)) + 0x7fff; value != -1 {
+		return value
+	}
+
+	return 0
+}
+
+// normalizeAxisByte makes the lower half of the range of an axis as long as
+// its upper half. Port of https://github.com/MWisBest/StadiEm.
+func normalizeAxisByte(value byte) byte {
+	if value <= 0x7F && value > 0x00 {
+		return value - 1
+	}
+
+	return value
+}
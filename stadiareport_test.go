@@ -0,0 +1,301 @@
+package stadiacontroller
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// centeredReport returns a raw 0x03 report with no button pressed and both
+// sticks centered, to which the given changes are applied.
+func centeredReport(changes func(payload []byte)) []byte {
+	data := []byte{stadiaInputReportID, 8, 0, 0, 0x80, 0x80, 0x80, 0x80, 0, 0}
+
+	if changes != nil {
+		changes(data[1:])
+	}
+
+	return data
+}
+
+func TestParseStadiaReportButtons(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		index int
+		mask  byte
+		want  StadiaReport
+	}{
+		{"R3", 1, 0b1000_0000, StadiaReport{R3: true}},
+		{"Options", 1, 0b0100_0000, StadiaReport{Options: true}},
+		{"Menu", 1, 0b0010_0000, StadiaReport{Menu: true}},
+		{"StadiaButton", 1, 0b0001_0000, StadiaReport{StadiaButton: true}},
+		{"Assistant", 1, 0b0000_0010, StadiaReport{Assistant: true}},
+		{"Capture", 1, 0b0000_0001, StadiaReport{Capture: true}},
+		{"A", 2, 0b0100_0000, StadiaReport{A: true}},
+		{"B", 2, 0b0010_0000, StadiaReport{B: true}},
+		{"X", 2, 0b0001_0000, StadiaReport{X: true}},
+		{"Y", 2, 0b0000_1000, StadiaReport{Y: true}},
+		{"L1", 2, 0b0000_0100, StadiaReport{L1: true}},
+		{"R1", 2, 0b0000_0010, StadiaReport{R1: true}},
+		{"L3", 2, 0b0000_0001, StadiaReport{L3: true}},
+	} {
+		data := centeredReport(func(payload []byte) { payload[test.index] = test.mask })
+
+		want := test.want
+		want.LeftX, want.LeftY, want.RightX, want.RightY = 0x80, 0x80, 0x80, 0x80
+
+		var report StadiaReport
+
+		if err := ParseStadiaReport(data, &report); err != nil {
+			t.Errorf("%s: ParseStadiaReport(% x) failed: %v", test.name, data, err)
+		} else if report != want {
+			t.Errorf("%s: ParseStadiaReport(% x) = %+v, want %+v", test.name, data, report, want)
+		}
+	}
+}
+
+func TestParseStadiaReportDpad(t *testing.T) {
+	for _, test := range []struct {
+		hat                   byte
+		up, right, down, left bool
+	}{
+		{0, true, false, false, false},
+		{1, true, true, false, false},
+		{2, false, true, false, false},
+		{3, false, true, true, false},
+		{4, false, false, true, false},
+		{5, false, false, true, true},
+		{6, false, false, false, true},
+		{7, true, false, false, true},
+		{8, false, false, false, false},
+		{0x0f, false, false, false, false},
+	} {
+		data := centeredReport(func(payload []byte) { payload[0] = test.hat })
+
+		var report StadiaReport
+
+		if err := ParseStadiaReport(data, &report); err != nil {
+			t.Fatalf("ParseStadiaReport(% x) failed: %v", data, err)
+		}
+
+		got := [4]bool{report.DpadUp, report.DpadRight, report.DpadDown, report.DpadLeft}
+		want := [4]bool{test.up, test.right, test.down, test.left}
+
+		if got != want {
+			t.Errorf("dpad %d: (up, right, down, left) = %v, want %v", test.hat, got, want)
+		}
+	}
+}
+
+func TestParseStadiaReportAxes(t *testing.T) {
+	for _, value := range []byte{0x00, 0x01, 0x7f, 0x80, 0x81, 0xfe, 0xff} {
+		data := centeredReport(func(payload []byte) {
+			for i := 3; i < 9; i++ {
+				payload[i] = value
+			}
+		})
+
+		var report StadiaReport
+
+		if err := ParseStadiaReport(data, &report); err != nil {
+			t.Fatalf("ParseStadiaReport(% x) failed: %v", data, err)
+		}
+
+		want := StadiaReport{LeftX: value, LeftY: value, RightX: value, RightY: value, L2: value, R2: value}
+
+		if report != want {
+			t.Errorf("axes %#02x: ParseStadiaReport(% x) = %+v, want %+v", value, data, report, want)
+		}
+	}
+
+	// The axes must be read from their own byte.
+	data := []byte{stadiaInputReportID, 8, 0, 0, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0xff}
+	want := StadiaReport{LeftX: 0x01, LeftY: 0x02, RightX: 0x03, RightY: 0x04, L2: 0x05, R2: 0x06}
+
+	var report StadiaReport
+
+	if err := ParseStadiaReport(data, &report); err != nil || report != want {
+		t.Errorf("ParseStadiaReport(% x) = %+v, %v, want %+v", data, report, err, want)
+	}
+}
+
+func TestParseStadiaReportErrors(t *testing.T) {
+	for _, test := range []struct {
+		data    []byte
+		ignored bool
+	}{
+		{nil, false},
+		{[]byte{stadiaInputReportID}, false},
+		{[]byte{stadiaInputReportID, 8, 0, 0, 0x80, 0x80, 0x80, 0x80, 0}, false},
+		{[]byte{0x01, 8, 0, 0, 0x80, 0x80, 0x80, 0x80, 0, 0}, true},
+	} {
+		var report StadiaReport
+
+		err := ParseStadiaReport(test.data, &report)
+
+		if err == nil {
+			t.Errorf("ParseStadiaReport(% x) succeeded", test.data)
+		} else if errors.Is(err, ErrIgnoredReport) != test.ignored {
+			t.Errorf("ParseStadiaReport(% x) = %v, ignored %v", test.data, err, !test.ignored)
+		}
+	}
+}
+
+var update = flag.Bool("update", false, "update the golden files in testdata")
+
+// TestScaleAxisByteGolden checks the mapping of every byte value of an axis
+// against testdata/scale_axis.golden, which holds one line per byte with the
+// results of scaleAxisByte and scaleInvertedAxisByte. Run with -update after
+// an intended change of the mapping.
+func TestScaleAxisByteGolden(t *testing.T) {
+	var got bytes.Buffer
+
+	for i := 0; i < 256; i++ {
+		value := byte(i)
+
+		fmt.Fprintf(&got, "%#02x %d %d\n", value, scaleAxisByte(value), scaleInvertedAxisByte(value))
+	}
+
+	path := filepath.Join("testdata", "scale_axis.golden")
+
+	if *update {
+		if err := ioutil.WriteFile(path, got.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want, err := ioutil.ReadFile(path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gotLines, wantLines := bytes.Split(got.Bytes(), []byte("\n")), bytes.Split(want, []byte("\n"))
+
+	if len(gotLines) != len(wantLines) {
+		t.Fatalf("got %d lines, want %d", len(gotLines), len(wantLines))
+	}
+
+	for i := range gotLines {
+		if !bytes.Equal(gotLines[i], wantLines[i]) {
+			t.Errorf("got %q, want %q", gotLines[i], wantLines[i])
+		}
+	}
+}
+
+func TestScaleAxisByteProperties(t *testing.T) {
+	for _, test := range []struct {
+		value          byte
+		want, inverted int32
+	}{
+		{0x00, -32768, 32767},
+		{0x80, 0, 0},
+		{0xff, 32767, -32768},
+	} {
+		if got := scaleAxisByte(test.value); got != test.want {
+			t.Errorf("scaleAxisByte(%#02x) = %d, want %d", test.value, got, test.want)
+		}
+		if got := scaleInvertedAxisByte(test.value); got != test.inverted {
+			t.Errorf("scaleInvertedAxisByte(%#02x) = %d, want %d", test.value, got, test.inverted)
+		}
+	}
+
+	for i := 1; i < 256; i++ {
+		prev, value := byte(i-1), byte(i)
+
+		if scaleAxisByte(value) <= scaleAxisByte(prev) {
+			t.Errorf("scaleAxisByte(%#02x) = %d is not greater than scaleAxisByte(%#02x) = %d", value, scaleAxisByte(value), prev, scaleAxisByte(prev))
+		}
+		if scaleInvertedAxisByte(value) >= scaleInvertedAxisByte(prev) {
+			t.Errorf("scaleInvertedAxisByte(%#02x) = %d is not less than scaleInvertedAxisByte(%#02x) = %d", value, scaleInvertedAxisByte(value), prev, scaleInvertedAxisByte(prev))
+		}
+	}
+}
+
+func TestToXbox360Report(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		press func(r *StadiaReport)
+		want  int
+	}{
+		{"A", func(r *StadiaReport) { r.A = true }, Xbox360ControllerButtonA},
+		{"B", func(r *StadiaReport) { r.B = true }, Xbox360ControllerButtonB},
+		{"X", func(r *StadiaReport) { r.X = true }, Xbox360ControllerButtonX},
+		{"Y", func(r *StadiaReport) { r.Y = true }, Xbox360ControllerButtonY},
+		{"L1", func(r *StadiaReport) { r.L1 = true }, Xbox360ControllerButtonLeftShoulder},
+		{"R1", func(r *StadiaReport) { r.R1 = true }, Xbox360ControllerButtonRightShoulder},
+		{"L3", func(r *StadiaReport) { r.L3 = true }, Xbox360ControllerButtonLeftThumb},
+		{"R3", func(r *StadiaReport) { r.R3 = true }, Xbox360ControllerButtonRightThumb},
+		{"Menu", func(r *StadiaReport) { r.Menu = true }, Xbox360ControllerButtonStart},
+		{"Options", func(r *StadiaReport) { r.Options = true }, Xbox360ControllerButtonBack},
+		{"Stadia", func(r *StadiaReport) { r.StadiaButton = true }, Xbox360ControllerButtonGuide},
+		{"DpadUp", func(r *StadiaReport) { r.DpadUp = true }, Xbox360ControllerButtonUp},
+		{"DpadDown", func(r *StadiaReport) { r.DpadDown = true }, Xbox360ControllerButtonDown},
+		{"DpadLeft", func(r *StadiaReport) { r.DpadLeft = true }, Xbox360ControllerButtonLeft},
+		{"DpadRight", func(r *StadiaReport) { r.DpadRight = true }, Xbox360ControllerButtonRight},
+	} {
+		stadiaReport := StadiaReport{LeftX: 0x80, LeftY: 0x80, RightX: 0x80, RightY: 0x80}
+		test.press(&stadiaReport)
+
+		report := ToXbox360Report(&stadiaReport)
+
+		if got, want := report.GetButtons(), uint16(1)<<test.want; got != want {
+			t.Errorf("%s: buttons are %#04x, want %#04x", test.name, got, want)
+		}
+	}
+
+	// Capture and Assistant have no Xbox 360 button of their own.
+	report := ToXbox360Report(&StadiaReport{Capture: true, Assistant: true, LeftX: 0x80, LeftY: 0x80, RightX: 0x80, RightY: 0x80})
+
+	if report.GetButtons() != 0 || !report.Capture || !report.Assistant {
+		t.Errorf("with Capture and Assistant pressed, buttons are %#04x, Capture %v and Assistant %v, want no button", report.GetButtons(), report.Capture, report.Assistant)
+	}
+
+	// Axes and triggers are scaled, with the Y axes pointing up.
+	report = ToXbox360Report(&StadiaReport{LeftX: 0x00, LeftY: 0x00, RightX: 0xff, RightY: 0xff, L2: 0x12, R2: 0xff})
+
+	if x, y := report.GetLeftThumb(); x != -32768 || y != 32767 {
+		t.Errorf("left stick is at (%d, %d), want (-32768, 32767)", x, y)
+	}
+	if x, y := report.GetRightThumb(); x != 32767 || y != -32768 {
+		t.Errorf("right stick is at (%d, %d), want (32767, -32768)", x, y)
+	}
+	if l, r := report.GetLeftTrigger(), report.GetRightTrigger(); l != 0x12 || r != 0xff {
+		t.Errorf("triggers are %#02x and %#02x, want 0x12 and 0xff", l, r)
+	}
+}
+
+// TestParseReport checks that the compatibility wrapper ParseReport matches
+// ParseStadiaReport followed by ToXbox360Report.
+func TestParseReport(t *testing.T) {
+	for _, data := range [][]byte{
+		centeredReport(nil),
+		centeredReport(func(payload []byte) { payload[0] = 0x00 }),
+		centeredReport(func(payload []byte) { payload[1], payload[2] = 0xff, 0xff }),
+		{stadiaInputReportID, 8, 0x40, 0x01, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06},
+	} {
+		var stadiaReport StadiaReport
+		var report Xbox360ControllerReport
+
+		if err := ParseStadiaReport(data, &stadiaReport); err != nil {
+			t.Fatalf("ParseStadiaReport(% x) failed: %v", data, err)
+		}
+		if err := ParseReport(data, &report); err != nil {
+			t.Fatalf("ParseReport(% x) failed: %v", data, err)
+		}
+
+		if want := ToXbox360Report(&stadiaReport); !report.Equal(&want) {
+			t.Errorf("ParseReport(% x) = %v, want %v", data, &report, &want)
+		}
+	}
+
+	var report Xbox360ControllerReport
+
+	if err := ParseReport([]byte{stadiaInputReportID, 8}, &report); err == nil {
+		t.Error("ParseReport of a truncated report succeeded")
+	}
+}
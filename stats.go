@@ -0,0 +1,152 @@
+package stadiacontroller
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Stats is a snapshot of the counters of a StadiaController, returned by
+// Stats.
+type Stats struct {
+	// Reports is the number of input reports read from the controller,
+	// whether they were received through GetReport or Events.
+	Reports uint64
+
+	// ReportsPerSecond is the average number of reports read per second during
+	// the last ReportRateWindow, or since the controller was created if it is
+	// more recent.
+	ReportsPerSecond float64
+
+	// DroppedReports is the number of input reports discarded because they
+	// were not read in time.
+	DroppedReports uint64
+
+	// ParseErrors is the number of input reports which could not be parsed.
+	ParseErrors uint64
+
+	// Reconnects is the number of times a device was opened after the first
+	// one.
+	Reconnects uint64
+}
+
+// counters are the counters reported by Stats. They are accessed atomically,
+// and allocated separately so that they are 64-bit aligned.
+type counters struct {
+	reports        uint64
+	droppedReports uint64
+	parseErrors    uint64
+	connects       uint64
+}
+
+// Stats returns the current counters of the controller. It may be called
+// from any goroutine.
+func (c *StadiaController) Stats() Stats {
+	stats := Stats{
+		Reports:        atomic.LoadUint64(&c.counters.reports),
+		DroppedReports: atomic.LoadUint64(&c.counters.droppedReports),
+		ParseErrors:    atomic.LoadUint64(&c.counters.parseErrors),
+	}
+
+	if connects := atomic.LoadUint64(&c.counters.connects); connects > 1 {
+		stats.Reconnects = connects - 1
+	}
+
+	// Reports dropped by the current device are only added to the total
+	// when it is dropped.
+	if device, _ := c.state(); device != nil {
+		if counter, ok := device.(DropCounter); ok {
+			stats.DroppedReports += counter.DroppedReports()
+		}
+	}
+
+	stats.ReportsPerSecond = c.rate.perSecond(time.Now())
+
+	return stats
+}
+
+// ReportRateWindow is the duration over which Stats.ReportsPerSecond is
+// averaged.
+const ReportRateWindow = time.Second
+
+// reportRateBuckets is the number of buckets of reportRate, over which
+// ReportRateWindow is divided.
+const reportRateBuckets = 10
+
+const reportRateBucket = ReportRateWindow / reportRateBuckets
+
+// reportRate counts the reports read during the last ReportRateWindow, in
+// buckets of reportRateBucket, so that the rate does not depend on when or
+// how often it is requested.
+type reportRate struct {
+	mu     sync.Mutex
+	start  time.Time
+	counts [reportRateBuckets]uint64
+
+	// last is the number of the most recent bucket since the Unix epoch.
+	last int64
+}
+
+func newReportRate(now time.Time) *reportRate {
+	return &reportRate{start: now, last: bucketOf(now)}
+}
+
+func bucketOf(t time.Time) int64 {
+	return t.UnixNano() / int64(reportRateBucket)
+}
+
+// advance clears the buckets which ended before the given bucket, which
+// becomes the most recent one.
+func (r *reportRate) advance(bucket int64) {
+	if bucket <= r.last {
+		return
+	}
+	if bucket-r.last >= reportRateBuckets {
+		r.counts = [reportRateBuckets]uint64{}
+	} else {
+		for b := r.last + 1; b <= bucket; b++ {
+			r.counts[b%reportRateBuckets] = 0
+		}
+	}
+
+	r.last = bucket
+}
+
+// add counts a report read at the given time.
+func (r *reportRate) add(now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	bucket := bucketOf(now)
+	r.advance(bucket)
+	r.counts[bucket%reportRateBuckets]++
+}
+
+// perSecond returns the average number of reports read per second during the
+// ReportRateWindow before the given time.
+func (r *reportRate) perSecond(now time.Time) float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	bucket := bucketOf(now)
+	r.advance(bucket)
+
+	var reports uint64
+
+	for _, count := range r.counts {
+		reports += count
+	}
+
+	// The buckets span the current, partial bucket, and the full ones
+	// before it.
+	since := time.Unix(0, (bucket-reportRateBuckets+1)*int64(reportRateBucket))
+
+	if since.Before(r.start) {
+		since = r.start
+	}
+	if elapsed := now.Sub(since); elapsed > 0 {
+		return float64(reports) / elapsed.Seconds()
+	}
+
+	return 0
+}
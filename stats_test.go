@@ -0,0 +1,81 @@
+package stadiacontroller
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestStatsCountsReportsReadThroughEvents(t *testing.T) {
+	input := []byte{stadiaInputReportID, 8, 0, 0, 0x80, 0x80, 0x80, 0x80, 0, 0}
+	reports := [][]byte{input, {0x07}, input, {stadiaInputReportID, 8}, input}
+
+	c := NewStadiaControllerWithDevice(NewMockDevice(reports, 0), WithLogger(nil))
+	defer c.Close()
+
+	// Read the events directly, without GetReport.
+	for event := range c.Events() {
+		if _, ok := event.(DisconnectedEvent); ok {
+			break
+		}
+	}
+
+	stats := c.Stats()
+
+	if stats.Reports != 3 {
+		t.Errorf("Stats().Reports = %d, want 3", stats.Reports)
+	}
+	if stats.ParseErrors != 1 {
+		t.Errorf("Stats().ParseErrors = %d, want 1", stats.ParseErrors)
+	}
+	if stats.Reconnects != 0 {
+		t.Errorf("Stats().Reconnects = %d, want 0", stats.Reconnects)
+	}
+}
+
+func TestReportRate(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	rate := newReportRate(start)
+
+	if got := rate.perSecond(start); got != 0 {
+		t.Errorf("rate at creation is %v, want 0", got)
+	}
+
+	// 250 reports per second, for two seconds.
+	now := start
+
+	for i := 0; i < 500; i++ {
+		now = now.Add(4 * time.Millisecond)
+		rate.add(now)
+	}
+
+	for _, test := range []struct {
+		name  string
+		after time.Duration
+		want  float64
+	}{
+		{"while reading", 0, 250},
+		// The rate does not depend on how often it is requested.
+		{"requested again", 0, 250},
+		{"half a window later", ReportRateWindow / 2, 125},
+		{"a window later", ReportRateWindow, 0},
+	} {
+		now = now.Add(test.after)
+
+		if got := rate.perSecond(now); math.Abs(got-test.want) > test.want/10+1 {
+			t.Errorf("%s: rate is %v, want about %v", test.name, got, test.want)
+		}
+	}
+
+	// A controller read for less than a window is averaged since it was
+	// created.
+	rate = newReportRate(start)
+
+	for i := 1; i <= 50; i++ {
+		rate.add(start.Add(time.Duration(i) * 2 * time.Millisecond))
+	}
+
+	if got := rate.perSecond(start.Add(100 * time.Millisecond)); math.Abs(got-500) > 50 {
+		t.Errorf("rate after 100ms is %v, want about 500", got)
+	}
+}
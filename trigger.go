@@ -0,0 +1,167 @@
+package stadiacontroller
+
+import (
+	"errors"
+	"fmt"
+)
+
+// TriggerCalibration rescales the values of a trigger which does not cover
+// its full range, e.g. because it never reaches 255 when fully pressed. Its
+// zero value leaves the trigger unchanged.
+type TriggerCalibration struct {
+	// Min and Max are the raw values mapped to 0 and 255; values outside of
+	// this range are clamped. A zero Max stands for 255.
+	Min byte
+	Max byte
+
+	// DigitalThreshold, if not zero, makes the trigger behave like a button:
+	// it reports 255 once its calibrated value reaches the threshold, and 0
+	// otherwise.
+	DigitalThreshold byte
+}
+
+func (c TriggerCalibration) apply(value byte) byte {
+	max := c.Max
+
+	if max == 0 {
+		max = 255
+	}
+
+	if max > c.Min {
+		switch {
+		case value <= c.Min:
+			value = 0
+		case value >= max:
+			value = 255
+		default:
+			value = byte((int(value-c.Min)*255 + int(max-c.Min)/2) / int(max-c.Min))
+		}
+	}
+
+	if c.DigitalThreshold > 0 {
+		if value >= c.DigitalThreshold {
+			return 255
+		}
+		return 0
+	}
+
+	return value
+}
+
+// A TriggerConfig is the calibration of a trigger in a config file. Omitted
+// fields keep their default value.
+type TriggerConfig struct {
+	// Deadzone is the raw value up to which the trigger is released.
+	Deadzone *int `json:"deadzone"`
+
+	// Saturation is the raw value from which the trigger is fully pressed.
+	Saturation *int `json:"saturation"`
+
+	// Digital, if not zero, is the value from which the trigger is reported
+	// as fully pressed, and released below it.
+	Digital *int `json:"digital"`
+
+	// Mode is "analog", "buttons" or "combined" (see TriggerMode), and
+	// Buttons and Threshold configure the "buttons" mode.
+	Mode      *string       `json:"mode"`
+	Buttons   ButtonTargets `json:"buttons"`
+	Threshold *int          `json:"threshold"`
+}
+
+func (t TriggerConfig) validate() error {
+	for _, value := range []struct {
+		key   string
+		value *int
+	}{
+		{"deadzone", t.Deadzone},
+		{"saturation", t.Saturation},
+		{"digital", t.Digital},
+		{"threshold", t.Threshold},
+	} {
+		if value.value != nil && (*value.value < 0 || *value.value > 255) {
+			return fmt.Errorf("%s: must be between 0 and 255", value.key)
+		}
+	}
+
+	if t.Deadzone != nil && t.Saturation != nil && *t.Deadzone >= *t.Saturation {
+		return errors.New("deadzone must be lower than saturation")
+	}
+
+	_, err := t.mapping()
+
+	return err
+}
+
+// mapping returns the TriggerMapping described by t.
+func (t TriggerConfig) mapping() (TriggerMapping, error) {
+	mapping := TriggerMapping{}
+
+	if t.Mode != nil {
+		mode, err := ParseTriggerMode(*t.Mode)
+
+		if err != nil {
+			return mapping, fmt.Errorf("mode: %w", err)
+		}
+
+		mapping.Mode = mode
+	}
+
+	for _, name := range t.Buttons {
+		button, err := ParseXbox360ControllerButton(name)
+
+		if err != nil {
+			return mapping, fmt.Errorf("buttons: %w", err)
+		}
+
+		mapping.Buttons = append(mapping.Buttons, button)
+	}
+
+	if t.Threshold != nil {
+		mapping.Threshold = byte(*t.Threshold)
+	}
+
+	return mapping, nil
+}
+
+// override returns t with the fields which are set in o, if any, replaced.
+func (t TriggerConfig) override(o *TriggerConfig) TriggerConfig {
+	if o == nil {
+		return t
+	}
+
+	for _, field := range []struct {
+		value *int
+		out   **int
+	}{
+		{o.Deadzone, &t.Deadzone},
+		{o.Saturation, &t.Saturation},
+		{o.Digital, &t.Digital},
+		{o.Threshold, &t.Threshold},
+	} {
+		if field.value != nil {
+			*field.out = field.value
+		}
+	}
+
+	if o.Mode != nil {
+		t.Mode = o.Mode
+	}
+	if o.Buttons != nil {
+		t.Buttons = o.Buttons
+	}
+
+	return t
+}
+
+// apply sets the fields of the given calibration which are set in t.
+func (t TriggerConfig) apply(c *TriggerCalibration) {
+	if t.Deadzone != nil {
+		c.Min = byte(*t.Deadzone)
+	}
+	if t.Saturation != nil {
+		c.Max = byte(*t.Saturation)
+	}
+	if t.Digital != nil {
+		c.DigitalThreshold = byte(*t.Digital)
+	}
+}
@@ -0,0 +1,106 @@
+package stadiacontroller
+
+import "testing"
+
+func TestTriggerCalibrationIdentity(t *testing.T) {
+	for value := 0; value <= 0xff; value++ {
+		if got := (TriggerCalibration{}).apply(byte(value)); got != byte(value) {
+			t.Errorf("zero calibration maps %d to %d", value, got)
+		}
+	}
+}
+
+func TestTriggerCalibration(t *testing.T) {
+	for _, test := range []struct {
+		name        string
+		calibration TriggerCalibration
+		values      map[byte]byte
+	}{
+		{
+			"deadzone and saturation",
+			TriggerCalibration{Min: 10, Max: 200},
+			map[byte]byte{0: 0, 9: 0, 10: 0, 11: 1, 105: 128, 199: 254, 200: 255, 254: 255, 255: 255},
+		},
+		{
+			"deadzone only",
+			TriggerCalibration{Min: 10},
+			map[byte]byte{0: 0, 9: 0, 10: 0, 11: 1, 254: 254, 255: 255},
+		},
+		{
+			"saturation only",
+			TriggerCalibration{Max: 200},
+			map[byte]byte{0: 0, 1: 1, 199: 254, 200: 255, 254: 255, 255: 255},
+		},
+		{
+			"digital",
+			TriggerCalibration{DigitalThreshold: 128},
+			map[byte]byte{0: 0, 127: 0, 128: 255, 254: 255, 255: 255},
+		},
+		{
+			"digital after calibration",
+			TriggerCalibration{Min: 10, Max: 200, DigitalThreshold: 128},
+			map[byte]byte{0: 0, 10: 0, 104: 0, 105: 255, 200: 255, 254: 255, 255: 255},
+		},
+		{
+			"digital at 255",
+			TriggerCalibration{DigitalThreshold: 255},
+			map[byte]byte{0: 0, 254: 0, 255: 255},
+		},
+	} {
+		for value, want := range test.values {
+			if got := test.calibration.apply(value); got != want {
+				t.Errorf("%s: apply(%d) = %d, want %d", test.name, value, got, want)
+			}
+		}
+
+		previous := byte(0)
+
+		for value := 0; value <= 0xff; value++ {
+			got := test.calibration.apply(byte(value))
+
+			if got < previous {
+				t.Errorf("%s: apply(%d) = %d, lower than %d for a smaller value", test.name, value, got, previous)
+				break
+			}
+
+			previous = got
+		}
+	}
+}
+
+func TestTriggerConfigValidate(t *testing.T) {
+	value := func(v int) *int { return &v }
+	mode := func(m string) *string { return &m }
+
+	for _, test := range []struct {
+		name    string
+		config  TriggerConfig
+		wantErr bool
+	}{
+		{"empty", TriggerConfig{}, false},
+		{"full range", TriggerConfig{Deadzone: value(0), Saturation: value(255), Digital: value(255), Threshold: value(0)}, false},
+		{"negative deadzone", TriggerConfig{Deadzone: value(-1)}, true},
+		{"saturation above 255", TriggerConfig{Saturation: value(256)}, true},
+		{"digital above 255", TriggerConfig{Digital: value(256)}, true},
+		{"deadzone at saturation", TriggerConfig{Deadzone: value(100), Saturation: value(100)}, true},
+		{"deadzone below saturation", TriggerConfig{Deadzone: value(99), Saturation: value(100)}, false},
+		{"buttons mode", TriggerConfig{Mode: mode("buttons"), Buttons: ButtonTargets{"LeftShoulder"}, Threshold: value(100)}, false},
+		{"unknown mode", TriggerConfig{Mode: mode("sideways")}, true},
+		{"unknown button", TriggerConfig{Mode: mode("buttons"), Buttons: ButtonTargets{"Turbo"}}, true},
+	} {
+		if err := test.config.validate(); (err != nil) != test.wantErr {
+			t.Errorf("%s: validate() = %v, want error %v", test.name, err, test.wantErr)
+		}
+	}
+}
+
+func TestTriggerConfigApply(t *testing.T) {
+	value := func(v int) *int { return &v }
+	calibration := TriggerCalibration{Min: 5, Max: 250, DigitalThreshold: 100}
+
+	TriggerConfig{Saturation: value(200)}.apply(&calibration)
+
+	if want := (TriggerCalibration{Min: 5, Max: 200, DigitalThreshold: 100}); calibration != want {
+		t.Errorf("calibration is %+v, want %+v", calibration, want)
+	}
+}
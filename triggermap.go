@@ -0,0 +1,162 @@
+package stadiacontroller
+
+import (
+	"fmt"
+	"strings"
+)
+
+// A TriggerMode selects how a trigger is sent to the emulated controller.
+type TriggerMode int
+
+const (
+	// TriggerAnalog sends the trigger as-is.
+	TriggerAnalog TriggerMode = iota
+
+	// TriggerButtons presses buttons instead while the trigger is pulled past
+	// a threshold, and releases the trigger.
+	TriggerButtons
+
+	// TriggerCombined sends both triggers as a single axis on the right
+	// trigger, centered at 0x80, and releases the left trigger, like legacy
+	// DirectInput games expect.
+	TriggerCombined
+)
+
+// ParseTriggerMode parses "analog", "buttons" or "combined".
+func ParseTriggerMode(s string) (TriggerMode, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "", "analog":
+		return TriggerAnalog, nil
+	case "buttons":
+		return TriggerButtons, nil
+	case "combined":
+		return TriggerCombined, nil
+	default:
+		return 0, fmt.Errorf("unknown trigger mode '%s'", s)
+	}
+}
+
+// DefaultTriggerHysteresis is how far below its threshold a trigger must be
+// released before the buttons it presses in TriggerButtons mode are
+// released, so that a trigger held at the threshold does not chatter.
+const DefaultTriggerHysteresis = 0x10
+
+// A TriggerMapping configures how a trigger is sent by a TriggerMapper.
+type TriggerMapping struct {
+	Mode TriggerMode
+
+	// Buttons are the buttons pressed in TriggerButtons mode. If empty, the
+	// shoulder button on the same side is pressed.
+	Buttons []int
+
+	// Threshold is the value from which the buttons are pressed in
+	// TriggerButtons mode. If zero, DefaultTriggerThreshold is used.
+	Threshold byte
+}
+
+// A TriggerMapper maps the triggers of reports according to their
+// TriggerMapping. Its Apply method is a ReportMutator. Since it remembers
+// whether the trigger buttons are pressed, a TriggerMapper must only be used
+// for a single stream of reports.
+type TriggerMapper struct {
+	Left, Right TriggerMapping
+	Hysteresis  byte
+
+	leftPressed, rightPressed bool
+}
+
+// NewTriggerMapper returns a mapper with the given mappings and
+// DefaultTriggerHysteresis.
+func NewTriggerMapper(left, right TriggerMapping) *TriggerMapper {
+	return &TriggerMapper{Left: left, Right: right, Hysteresis: DefaultTriggerHysteresis}
+}
+
+// Apply maps the triggers of the given report.
+func (m *TriggerMapper) Apply(report *Xbox360ControllerReport) {
+	left, right := report.GetLeftTrigger(), report.GetRightTrigger()
+
+	if m.Left.Mode == TriggerCombined || m.Right.Mode == TriggerCombined {
+		report.SetLeftTrigger(0)
+		report.SetRightTrigger(byte(0x80 + (int(right)-int(left))/2))
+
+		return
+	}
+
+	if m.Left.Mode == TriggerButtons {
+		m.leftPressed = m.pressed(m.leftPressed, left, m.Left.Threshold)
+		m.press(report, m.leftPressed, m.Left.Buttons, Xbox360ControllerButtonLeftShoulder)
+		report.SetLeftTrigger(0)
+	}
+	if m.Right.Mode == TriggerButtons {
+		m.rightPressed = m.pressed(m.rightPressed, right, m.Right.Threshold)
+		m.press(report, m.rightPressed, m.Right.Buttons, Xbox360ControllerButtonRightShoulder)
+		report.SetRightTrigger(0)
+	}
+}
+
+// pressed returns whether a trigger with the given value is pressed, given
+// whether it was pressed before.
+func (m *TriggerMapper) pressed(wasPressed bool, value, threshold byte) bool {
+	if threshold == 0 {
+		threshold = DefaultTriggerThreshold
+	}
+
+	if wasPressed {
+		return int(value) >= int(threshold)-int(m.Hysteresis)
+	}
+
+	return value >= threshold
+}
+
+func (m *TriggerMapper) press(report *Xbox360ControllerReport, pressed bool, buttons []int, defaultButton int) {
+	if !pressed {
+		return
+	}
+
+	if len(buttons) == 0 {
+		report.SetButton(defaultButton)
+	}
+
+	for _, button := range buttons {
+		report.SetButton(button)
+	}
+}
+
+// LoadTriggerMapper reads the trigger modes of a JSON config file, e.g.
+//
+//	{"triggers": {"left": {"mode": "buttons", "buttons": "LeftShoulder", "threshold": 100}}}
+//
+// The trigger settings given by profile, if not nil, override the ones of the
+// config. It returns nil if both triggers are analog.
+func LoadTriggerMapper(path string, profile *Profile) (*TriggerMapper, error) {
+	config, err := readValidConfig(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	leftConfig, rightConfig := config.Triggers.Left, config.Triggers.Right
+
+	if profile != nil {
+		leftConfig = leftConfig.override(profile.LeftTrigger)
+		rightConfig = rightConfig.override(profile.RightTrigger)
+	}
+
+	left, err := leftConfig.mapping()
+
+	if err != nil {
+		return nil, fmt.Errorf("invalid config %s: triggers.left: %w", path, err)
+	}
+
+	right, err := rightConfig.mapping()
+
+	if err != nil {
+		return nil, fmt.Errorf("invalid config %s: triggers.right: %w", path, err)
+	}
+
+	if left.Mode == TriggerAnalog && right.Mode == TriggerAnalog {
+		return nil, nil
+	}
+
+	return NewTriggerMapper(left, right), nil
+}
@@ -0,0 +1,214 @@
+package stadiacontroller
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultTurboFrequency is the frequency, in Hz, of turbo buttons given
+// without a frequency.
+const DefaultTurboFrequency = 15
+
+// Turbo keys of the triggers in a TurboConfig, which follow the bits of the
+// Xbox 360 controller buttons.
+const (
+	TurboLeftTrigger  = 16
+	TurboRightTrigger = 17
+)
+
+// TurboConfig maps Xbox 360 controller buttons, or TurboLeftTrigger and
+// TurboRightTrigger, to the frequency, in Hz, at which they are toggled
+// while they are held.
+type TurboConfig map[int]float64
+
+// parseTurboButton parses the name of a button, or "LT" or "RT" for the
+// triggers.
+func parseTurboButton(name string) (int, error) {
+	switch strings.ToLower(name) {
+	case "lt", "lefttrigger":
+		return TurboLeftTrigger, nil
+	case "rt", "righttrigger":
+		return TurboRightTrigger, nil
+	}
+
+	return ParseXbox360ControllerButton(name)
+}
+
+// ParseTurboConfig parses a comma-separated list of buttons and optional
+// frequencies, e.g. "A:15,B:10,RT". Buttons given without a frequency use
+// DefaultTurboFrequency, and the triggers are named LT and RT.
+func ParseTurboConfig(s string) (TurboConfig, error) {
+	config := TurboConfig{}
+
+	if s == "" {
+		return config, nil
+	}
+
+	for _, entry := range strings.Split(s, ",") {
+		parts := strings.SplitN(entry, ":", 2)
+		frequency := float64(DefaultTurboFrequency)
+
+		if len(parts) == 2 {
+			var err error
+
+			frequency, err = strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+
+			if err != nil || frequency <= 0 {
+				return nil, fmt.Errorf("invalid turbo entry '%s': frequency must be a positive number", entry)
+			}
+		}
+
+		button, err := parseTurboButton(strings.TrimSpace(parts[0]))
+
+		if err != nil {
+			return nil, fmt.Errorf("invalid turbo entry '%s': %w", entry, err)
+		}
+
+		config[button] = frequency
+	}
+
+	return config, nil
+}
+
+// TurboEntries are the turbo buttons of a JSON config file, mapped to their
+// frequency in Hz, or 0 for DefaultTurboFrequency. In JSON, it is either a
+// list of button names, e.g. ["A", "RT"], or an object mapping button names
+// to frequencies, e.g. {"A": 15, "RT": 10}.
+type TurboEntries map[string]float64
+
+func (t *TurboEntries) UnmarshalJSON(data []byte) error {
+	var names []string
+
+	if err := json.Unmarshal(data, &names); err == nil {
+		*t = TurboEntries{}
+
+		for _, name := range names {
+			(*t)[name] = 0
+		}
+
+		return nil
+	}
+
+	var frequencies map[string]float64
+
+	if err := json.Unmarshal(data, &frequencies); err != nil {
+		return errors.New("expected a list of button names or an object mapping button names to frequencies")
+	}
+
+	*t = frequencies
+
+	return nil
+}
+
+// config returns the TurboConfig described by t.
+func (t TurboEntries) config() (TurboConfig, error) {
+	config := TurboConfig{}
+	names := make([]string, 0, len(t))
+
+	for name := range t {
+		names = append(names, name)
+	}
+
+	// Report the first invalid entry in a stable order.
+	sort.Strings(names)
+
+	for _, name := range names {
+		frequency := t[name]
+		button, err := parseTurboButton(name)
+
+		if err != nil {
+			return nil, err
+		}
+		if frequency < 0 {
+			return nil, fmt.Errorf("%s: frequency must be a positive number", name)
+		}
+		if frequency == 0 {
+			frequency = DefaultTurboFrequency
+		}
+
+		config[button] = frequency
+	}
+
+	return config, nil
+}
+
+// LoadTurboConfig reads the "turbo" key of a JSON config file. It returns
+// nil if it is not set.
+func LoadTurboConfig(path string) (TurboConfig, error) {
+	config, err := readValidConfig(path)
+
+	if err != nil || config.Turbo == nil {
+		return nil, err
+	}
+
+	return config.Turbo.config()
+}
+
+// Turbo applies a TurboConfig to successive reports.
+type Turbo struct {
+	config TurboConfig
+	heldAt map[int]time.Time
+}
+
+func NewTurbo(config TurboConfig) *Turbo {
+	return &Turbo{config, map[int]time.Time{}}
+}
+
+// Apply toggles the turbo buttons held in the given report depending on how
+// long they have been held at the given time. A button is pressed for the
+// first half of each period, starting with the moment it is held. Triggers
+// are held from DefaultTriggerThreshold, and released to 0.
+func (t *Turbo) Apply(report *Xbox360ControllerReport, now time.Time) {
+	buttons := uint32(report.GetButtons())
+
+	if report.GetLeftTrigger() >= DefaultTriggerThreshold {
+		buttons |= 1 << TurboLeftTrigger
+	}
+	if report.GetRightTrigger() >= DefaultTriggerThreshold {
+		buttons |= 1 << TurboRightTrigger
+	}
+
+	for button, frequency := range t.config {
+		if buttons&(1<<button) == 0 {
+			delete(t.heldAt, button)
+			continue
+		}
+
+		heldAt, ok := t.heldAt[button]
+
+		if !ok {
+			t.heldAt[button] = now
+			continue
+		}
+
+		if int64(now.Sub(heldAt).Seconds()*frequency*2)%2 == 0 {
+			continue
+		}
+
+		switch button {
+		case TurboLeftTrigger:
+			report.SetLeftTrigger(0)
+		case TurboRightTrigger:
+			report.SetRightTrigger(0)
+		default:
+			report.ClearButton(button)
+		}
+	}
+}
+
+// Reset forgets the buttons which are held, e.g. after the controller was
+// reconnected, so that they start pressed the next time they are held.
+func (t *Turbo) Reset() {
+	t.heldAt = map[int]time.Time{}
+}
+
+// Active returns whether a turbo button is currently held, in which case
+// reports should be sent periodically for the button to be toggled.
+func (t *Turbo) Active() bool {
+	return len(t.heldAt) > 0
+}
@@ -0,0 +1,324 @@
+package stadiacontroller
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// vibrationFailureThreshold is the number of consecutive failed vibration
+// writes after which an ErrorEvent is sent.
+const vibrationFailureThreshold = 3
+
+// WithRumbleScale scales the strength of the vibrations given to Vibrate by
+// the given factor, e.g. 0.5 to halve it or 0 to disable vibrations. Scaled
+// strengths are clamped to 255.
+func WithRumbleScale(scale float64) Option {
+	return func(o *options) {
+		o.rumbleScale = scale
+	}
+}
+
+// A RumbleTransform adjusts the vibrations requested by games before they
+// are given to Vibrate.
+type RumbleTransform struct {
+	// LargeScale and SmallScale scale the strength of each motor.
+	LargeScale float64
+	SmallScale float64
+
+	// SwapMotors swaps the large and small motors, after scaling them.
+	SwapMotors bool
+
+	// Off disables vibrations entirely.
+	Off bool
+}
+
+// DefaultRumbleTransform leaves vibrations unchanged.
+var DefaultRumbleTransform = RumbleTransform{LargeScale: 1, SmallScale: 1}
+
+// Apply returns the given vibration, transformed. Scaled strengths are
+// rounded and clamped to 255.
+func (t RumbleTransform) Apply(vibration Vibration) Vibration {
+	if t.Off {
+		return Vibration{LedNumber: vibration.LedNumber}
+	}
+
+	// Trigger motors are played by the small motor (see VibrateWith), so
+	// they are scaled like it.
+	vibration = Vibration{
+		LargeMotor:   scaleMotor(vibration.LargeMotor, t.LargeScale),
+		SmallMotor:   scaleMotor(vibration.SmallMotor, t.SmallScale),
+		LedNumber:    vibration.LedNumber,
+		LeftTrigger:  scaleMotor(vibration.LeftTrigger, t.SmallScale),
+		RightTrigger: scaleMotor(vibration.RightTrigger, t.SmallScale),
+	}
+
+	if t.SwapMotors {
+		vibration.LargeMotor, vibration.SmallMotor = vibration.SmallMotor, vibration.LargeMotor
+	}
+
+	return vibration
+}
+
+// scaleMotor scales the given motor strength, clamping it to 255.
+func scaleMotor(strength byte, scale float64) byte {
+	return byte(math.Max(0, math.Min(0xff, math.Round(float64(strength)*scale))))
+}
+
+// DefaultVibrationTimeout is the duration after which an unchanged non-zero
+// vibration is stopped, so that a game which exits without stopping it does
+// not leave the controller vibrating.
+const DefaultVibrationTimeout = 5 * time.Second
+
+// WithVibrationTimeout sets the duration after which an unchanged non-zero
+// vibration given to Vibrate is stopped, or disables this safety if it is 0.
+// It defaults to DefaultVibrationTimeout.
+func WithVibrationTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.vibrationTimeout = timeout
+	}
+}
+
+// A VibrationStep is a vibration played for some duration by VibratePattern.
+type VibrationStep struct {
+	LargeMotor byte
+	SmallMotor byte
+	Duration   time.Duration
+}
+
+// Vibrate makes the controller vibrate until another vibration is given, or
+// until the timeout set by WithVibrationTimeout expires. The vibration is
+// written to the device by a dedicated goroutine, so Vibrate never blocks; if
+// a previous vibration was not written yet, it is replaced by the new one.
+//
+// Vibrate returns an error if device discovery is failing. Errors writing to
+// the device are reported as an ErrorEvent once they persist. While the
+// controller is paused by SetPaused, Vibrate does nothing.
+//
+// The vibration is written as the 0x05 output report of the USB interface;
+// no Bluetooth-specific output report is known.
+func (c *StadiaController) Vibrate(largeMotor, smallMotor byte) error {
+	if c.Paused() {
+		return nil
+	}
+
+	if largeMotor == 0 && smallMotor == 0 || c.vibrationTimeout <= 0 {
+		return c.playVibration(nil, Vibration{LargeMotor: largeMotor, SmallMotor: smallMotor})
+	}
+
+	return c.VibrateFor(largeMotor, smallMotor, c.vibrationTimeout)
+}
+
+// VibrateWith makes the controller vibrate like Vibrate. The Stadia
+// controller has no trigger motors, so trigger vibrations are approximated by
+// the small motor, which vibrates at least as strongly as the strongest of
+// them.
+func (c *StadiaController) VibrateWith(vibration Vibration) error {
+	smallMotor := vibration.SmallMotor
+
+	for _, trigger := range []byte{vibration.LeftTrigger, vibration.RightTrigger} {
+		if trigger > smallMotor {
+			smallMotor = trigger
+		}
+	}
+
+	return c.Vibrate(vibration.LargeMotor, smallMotor)
+}
+
+// VibrateFor makes the controller vibrate for the given duration, and then
+// stops it unless another vibration was given in the meantime.
+func (c *StadiaController) VibrateFor(largeMotor, smallMotor byte, duration time.Duration) error {
+	return c.VibratePattern([]VibrationStep{{largeMotor, smallMotor, duration}})
+}
+
+// VibratePattern plays the given vibrations in order, and then stops the
+// controller. Any vibration given afterwards interrupts the pattern.
+func (c *StadiaController) VibratePattern(steps []VibrationStep) error {
+	return c.playVibration(steps, Vibration{})
+}
+
+// playVibration cancels any vibration being played, and then plays the
+// given steps before settling on the given final vibration.
+func (c *StadiaController) playVibration(steps []VibrationStep, final Vibration) error {
+	device, err := c.state()
+
+	if device == nil {
+		return err
+	}
+
+	c.vibrationMu.Lock()
+	defer c.vibrationMu.Unlock()
+
+	c.vibrationGen++
+	c.stopVibrationTimer()
+	c.playVibrationStep(c.vibrationGen, steps, final)
+
+	return nil
+}
+
+// playVibrationStep plays the first of the given steps, scheduling the
+// following ones. c.vibrationMu must be held.
+func (c *StadiaController) playVibrationStep(gen uint64, steps []VibrationStep, final Vibration) {
+	if len(steps) == 0 {
+		c.enqueueVibration(final)
+		return
+	}
+
+	step := steps[0]
+	c.enqueueVibration(Vibration{LargeMotor: step.LargeMotor, SmallMotor: step.SmallMotor})
+
+	c.vibrationTimer = time.AfterFunc(step.Duration, func() {
+		c.vibrationMu.Lock()
+		defer c.vibrationMu.Unlock()
+
+		// Another vibration was given in the meantime.
+		if c.vibrationGen != gen {
+			return
+		}
+
+		c.playVibrationStep(gen, steps[1:], final)
+	})
+}
+
+// stopVibrationTimer stops the timer scheduling the next vibration step, if
+// any. c.vibrationMu must be held.
+func (c *StadiaController) stopVibrationTimer() {
+	if c.vibrationTimer != nil {
+		c.vibrationTimer.Stop()
+		c.vibrationTimer = nil
+	}
+}
+
+// enqueueVibration scales the given vibration and hands it to
+// writeVibrations, replacing any pending vibration.
+func (c *StadiaController) enqueueVibration(vibration Vibration) {
+	vibration = Vibration{
+		LargeMotor: scaleMotor(vibration.LargeMotor, c.rumbleScale),
+		SmallMotor: scaleMotor(vibration.SmallMotor, c.rumbleScale),
+	}
+
+	for {
+		select {
+		case c.vibrations <- vibration:
+			return
+		default:
+		}
+
+		// Drop the pending vibration, which is now stale.
+		select {
+		case <-c.vibrations:
+			c.countVibration(false)
+		default:
+		}
+	}
+}
+
+// writeVibrations writes the vibrations given to Vibrate to the device until
+// the controller is closed, at most once per minVibrationInterval. Vibrations
+// given in the meantime are coalesced, and vibrations identical to the last
+// one written are skipped.
+func (c *StadiaController) writeVibrations() {
+	failures := 0
+
+	var (
+		lastDevice    Device
+		lastVibration Vibration
+		lastWrite     time.Time
+	)
+
+	for {
+		var vibration Vibration
+
+		select {
+		case vibration = <-c.vibrations:
+		case <-c.done:
+			return
+		}
+
+		// Wait until another write is allowed, keeping the latest vibration.
+		if wait := c.minVibrationInterval - time.Since(lastWrite); wait > 0 {
+			timer := time.NewTimer(wait)
+
+		coalesce:
+			for {
+				select {
+				case vibration = <-c.vibrations:
+					c.countVibration(false)
+				case <-timer.C:
+					break coalesce
+				case <-c.done:
+					timer.Stop()
+					return
+				}
+			}
+		}
+
+		device, _ := c.state()
+
+		if device == nil {
+			continue
+		}
+		if device == lastDevice && vibration == lastVibration {
+			c.countVibration(false)
+			continue
+		}
+
+		lastWrite = time.Now()
+		err := device.Write([]byte{0x05, vibration.LargeMotor, vibration.LargeMotor, vibration.SmallMotor, vibration.SmallMotor})
+
+		if err == nil {
+			failures = 0
+			lastDevice, lastVibration = device, vibration
+			c.countVibration(true)
+			continue
+		}
+
+		if failures++; failures == vibrationFailureThreshold {
+			c.sendFromDevice(device, ErrorEvent{fmt.Errorf("unable to write vibration: %w", err)})
+		}
+	}
+}
+
+// DefaultMaxVibrationRate is the default maximum number of vibrations
+// written to the device per second.
+const DefaultMaxVibrationRate = 60
+
+// WithMaxVibrationRate sets the maximum number of vibrations written to the
+// device per second; vibrations given faster are coalesced. It defaults to
+// DefaultMaxVibrationRate, and 0 removes the limit.
+func WithMaxVibrationRate(rate float64) Option {
+	return func(o *options) {
+		o.maxVibrationRate = rate
+	}
+}
+
+// VibrationStats counts the vibrations given to a StadiaController.
+type VibrationStats struct {
+	// Written is the number of vibrations written to the device.
+	Written uint64
+
+	// Suppressed is the number of vibrations which were not written, either
+	// because a newer vibration replaced them or because they did not change
+	// the vibration of the device.
+	Suppressed uint64
+}
+
+// VibrationStats returns the number of vibrations written and suppressed so
+// far, for debugging.
+func (c *StadiaController) VibrationStats() VibrationStats {
+	c.statsMu.Lock()
+	defer c.statsMu.Unlock()
+
+	return c.vibrationStats
+}
+
+func (c *StadiaController) countVibration(written bool) {
+	c.statsMu.Lock()
+	defer c.statsMu.Unlock()
+
+	if written {
+		c.vibrationStats.Written++
+	} else {
+		c.vibrationStats.Suppressed++
+	}
+}
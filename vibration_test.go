@@ -0,0 +1,133 @@
+package stadiacontroller
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+// stuckDevice is a MockDevice whose writes block until unblock is closed,
+// like a device which stopped responding.
+type stuckDevice struct {
+	*MockDevice
+
+	unblock chan struct{}
+}
+
+func (d *stuckDevice) Write(data []byte) error {
+	select {
+	case <-d.unblock:
+	case <-d.closed:
+		return ErrClosed
+	}
+
+	return d.MockDevice.Write(data)
+}
+
+func TestVibrateDoesNotBlockOnWrites(t *testing.T) {
+	device := &stuckDevice{NewMockDevice([][]byte{nil}, time.Hour), make(chan struct{})}
+	c := NewStadiaControllerWithDevice(device, WithLogger(nil), WithVibrationTimeout(0), WithMaxVibrationRate(0))
+	defer c.Close()
+
+	returned := make(chan struct{})
+
+	go func() {
+		defer close(returned)
+
+		for strength := 1; strength <= 100; strength++ {
+			if err := c.Vibrate(byte(strength), 0); err != nil {
+				t.Errorf("Vibrate(%d) = %v", strength, err)
+			}
+		}
+	}()
+
+	select {
+	case <-returned:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Vibrate blocked on a stuck write")
+	}
+
+	close(device.unblock)
+
+	// The write which was stuck is followed by the latest vibration only.
+	want := []byte{0x05, 100, 100, 0, 0}
+	deadline := time.Now().Add(5 * time.Second)
+
+	for {
+		writes, stats := device.Writes(), c.VibrationStats()
+
+		if len(writes) > 0 && bytes.Equal(writes[len(writes)-1], want) && stats.Written+stats.Suppressed == 100 {
+			if len(writes) > 2 {
+				t.Errorf("got %d writes, want at most 2: %v", len(writes), writes)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("latest vibration was not written: got writes %v and %+v", writes, stats)
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestRumbleTransform(t *testing.T) {
+	vibration := Vibration{LargeMotor: 200, SmallMotor: 3, LedNumber: 2, LeftTrigger: 5, RightTrigger: 255}
+
+	for _, test := range []struct {
+		name      string
+		transform RumbleTransform
+		want      Vibration
+	}{
+		{"default", DefaultRumbleTransform, vibration},
+		{
+			"halved, rounding half away from zero",
+			RumbleTransform{LargeScale: 0.5, SmallScale: 0.5},
+			Vibration{LargeMotor: 100, SmallMotor: 2, LedNumber: 2, LeftTrigger: 3, RightTrigger: 128},
+		},
+		{
+			"doubled, clamping to 255",
+			RumbleTransform{LargeScale: 2, SmallScale: 2},
+			Vibration{LargeMotor: 255, SmallMotor: 6, LedNumber: 2, LeftTrigger: 10, RightTrigger: 255},
+		},
+		{
+			"triggers scaled like the small motor",
+			RumbleTransform{LargeScale: 1, SmallScale: 0},
+			Vibration{LargeMotor: 200, LedNumber: 2},
+		},
+		{
+			"swapped after scaling",
+			RumbleTransform{LargeScale: 0.5, SmallScale: 1, SwapMotors: true},
+			Vibration{LargeMotor: 3, SmallMotor: 100, LedNumber: 2, LeftTrigger: 5, RightTrigger: 255},
+		},
+		{
+			"off",
+			RumbleTransform{LargeScale: 2, SmallScale: 2, SwapMotors: true, Off: true},
+			Vibration{LedNumber: 2},
+		},
+	} {
+		if got := test.transform.Apply(vibration); got != test.want {
+			t.Errorf("%s: Apply(%+v) = %+v, want %+v", test.name, vibration, got, test.want)
+		}
+	}
+}
+
+func TestScaleMotorRounding(t *testing.T) {
+	for _, test := range []struct {
+		strength byte
+		scale    float64
+		want     byte
+	}{
+		{0, 2, 0},
+		{1, 0.49, 0},
+		{1, 0.5, 1},
+		{255, 1, 255},
+		{254, 0.5, 127},
+		{255, 0.5, 128},
+		{128, 1.99, 255},
+		{255, 0, 0},
+	} {
+		if got := scaleMotor(test.strength, test.scale); got != test.want {
+			t.Errorf("scaleMotor(%d, %v) = %d, want %d", test.strength, test.scale, got, test.want)
+		}
+	}
+}
@@ -1,23 +1,9 @@
 package stadiacontroller
 
-/*
-#include <stdint.h>
-
-typedef struct
-{
-	uint16_t wButtons;
-	uint8_t bLeftTrigger;
-	uint8_t bRightTrigger;
-	int16_t sThumbLX;
-	int16_t sThumbLY;
-	int16_t sThumbRX;
-	int16_t sThumbRY;
-} xusb_report;
-*/
-import "C"
-
 import (
 	"errors"
+	"fmt"
+	"sync/atomic"
 	"unsafe"
 
 	"golang.org/x/sys/windows"
@@ -43,26 +29,154 @@ const (
 	VIGEM_ERROR_MAX = VIGEM_ERROR_XUSB_USERINDEX_OUT_OF_RANGE + 1
 )
 
+// The procedures of ViGEmClient.dll, which are set by useVigemClient.
 var (
-	client = windows.NewLazyDLL("ViGEmClient.dll")
-
-	procAlloc                            = client.NewProc("vigem_alloc")
-	procFree                             = client.NewProc("vigem_free")
-	procConnect                          = client.NewProc("vigem_connect")
-	procDisconnect                       = client.NewProc("vigem_disconnect")
-	procTargetAdd                        = client.NewProc("vigem_target_add")
-	procTargetFree                       = client.NewProc("vigem_target_free")
-	procTargetRemove                     = client.NewProc("vigem_target_remove")
-	procTargetX360Alloc                  = client.NewProc("vigem_target_x360_alloc")
-	procTargetX360RegisterNotification   = client.NewProc("vigem_target_x360_register_notification")
+	client *windows.LazyDLL
+
+	procAlloc                            *windows.LazyProc
+	procFree                             *windows.LazyProc
+	procConnect                          *windows.LazyProc
+	procDisconnect                       *windows.LazyProc
+	procTargetAdd                        *windows.LazyProc
+	procTargetFree                       *windows.LazyProc
+	procTargetRemove                     *windows.LazyProc
+	procTargetGetIndex                   *windows.LazyProc
+	procTargetX360Alloc                  *windows.LazyProc
+	procTargetX360RegisterNotification   *windows.LazyProc
+	procTargetX360UnregisterNotification *windows.LazyProc
+	procTargetX360Update                 *windows.LazyProc
+	procTargetX360GetUserIndex           *windows.LazyProc
+	procTargetDS4Alloc                   *windows.LazyProc
+	procTargetDS4RegisterNotification    *windows.LazyProc
+	procTargetDS4UnregisterNotification  *windows.LazyProc
+	procTargetDS4Update                  *windows.LazyProc
+
+	// vigemProcs are all the procedures above, which are checked by
+	// CheckRequirements.
+	vigemProcs []*windows.LazyProc
+)
+
+func init() {
+	useVigemClient(windows.NewLazyDLL("ViGEmClient.dll"))
+}
+
+// SetVigemClientPath makes the emulator load ViGEmClient.dll from the given
+// path, instead of looking for it in the DLL search path. It must be called
+// before any emulator is created.
+func SetVigemClientPath(path string) {
+	useVigemClient(windows.NewLazyDLL(path))
+}
+
+func useVigemClient(dll *windows.LazyDLL) {
+	client = dll
+
+	procAlloc = client.NewProc("vigem_alloc")
+	procFree = client.NewProc("vigem_free")
+	procConnect = client.NewProc("vigem_connect")
+	procDisconnect = client.NewProc("vigem_disconnect")
+	procTargetAdd = client.NewProc("vigem_target_add")
+	procTargetFree = client.NewProc("vigem_target_free")
+	procTargetRemove = client.NewProc("vigem_target_remove")
+	procTargetGetIndex = client.NewProc("vigem_target_get_index")
+	procTargetX360Alloc = client.NewProc("vigem_target_x360_alloc")
+	procTargetX360RegisterNotification = client.NewProc("vigem_target_x360_register_notification")
 	procTargetX360UnregisterNotification = client.NewProc("vigem_target_x360_unregister_notification")
-	procTargetX360Update                 = client.NewProc("vigem_target_x360_update")
+	procTargetX360Update = client.NewProc("vigem_target_x360_update")
+	procTargetX360GetUserIndex = client.NewProc("vigem_target_x360_get_user_index")
+	procTargetDS4Alloc = client.NewProc("vigem_target_ds4_alloc")
+	procTargetDS4RegisterNotification = client.NewProc("vigem_target_ds4_register_notification")
+	procTargetDS4UnregisterNotification = client.NewProc("vigem_target_ds4_unregister_notification")
+	procTargetDS4Update = client.NewProc("vigem_target_ds4_update")
+
+	vigemProcs = []*windows.LazyProc{
+		procAlloc, procFree, procConnect, procDisconnect,
+		procTargetAdd, procTargetFree, procTargetRemove, procTargetGetIndex,
+		procTargetX360Alloc, procTargetX360RegisterNotification, procTargetX360UnregisterNotification, procTargetX360Update, procTargetX360GetUserIndex,
+		procTargetDS4Alloc, procTargetDS4RegisterNotification, procTargetDS4UnregisterNotification, procTargetDS4Update,
+	}
+}
+
+var (
+	// ErrVigemClientNotFound is returned by CheckRequirements and
+	// NewEmulator if ViGEmClient.dll cannot be loaded.
+	ErrVigemClientNotFound = errors.New("ViGEmClient.dll not found")
+
+	// ErrVigemClientOutdated is returned by CheckRequirements and
+	// NewEmulator if ViGEmClient.dll lacks a procedure we need.
+	ErrVigemClientOutdated = errors.New("ViGEmClient.dll is outdated")
 )
 
+// CheckRequirements checks that ViGEmClient.dll can be loaded and that
+// ViGEmBus is installed, by connecting to it.
+//
+// It returns an error wrapping ErrVigemClientNotFound or
+// ErrVigemClientOutdated if the DLL cannot be used, and ErrBusNotFound if
+// ViGEmBus is not installed.
+func CheckRequirements() error {
+	if err := loadVigemClient(); err != nil {
+		return err
+	}
+
+	handle, _, err := procAlloc.Call()
+
+	if !errors.Is(err, windows.ERROR_SUCCESS) {
+		return err
+	}
+
+	defer procFree.Call(handle)
+
+	libErr, _, err := procConnect.Call(handle)
+
+	if !errors.Is(err, windows.ERROR_SUCCESS) {
+		return err
+	}
+	if err := NewVigemError(libErr); err != nil {
+		return err
+	}
+
+	procDisconnect.Call(handle)
+
+	return nil
+}
+
+// loadVigemClient loads ViGEmClient.dll and all the procedures we need from
+// it, since calling a procedure which cannot be loaded panics.
+func loadVigemClient() error {
+	if err := client.Load(); err != nil {
+		return fmt.Errorf("%w: %v", ErrVigemClientNotFound, err)
+	}
+
+	for _, proc := range vigemProcs {
+		if err := proc.Find(); err != nil {
+			return fmt.Errorf("%w: %v", ErrVigemClientOutdated, err)
+		}
+	}
+
+	return nil
+}
+
 type VigemError struct {
 	code uint
 }
 
+// Errors returned by ViGEm, which can be matched with errors.Is.
+var (
+	ErrBusNotFound               = &VigemError{VIGEM_ERROR_BUS_NOT_FOUND}
+	ErrNoFreeSlot                = &VigemError{VIGEM_ERROR_NO_FREE_SLOT}
+	ErrInvalidTarget             = &VigemError{VIGEM_ERROR_INVALID_TARGET}
+	ErrRemovalFailed             = &VigemError{VIGEM_ERROR_REMOVAL_FAILED}
+	ErrAlreadyConnected          = &VigemError{VIGEM_ERROR_ALREADY_CONNECTED}
+	ErrTargetUninitialized       = &VigemError{VIGEM_ERROR_TARGET_UNINITIALIZED}
+	ErrTargetNotPluggedIn        = &VigemError{VIGEM_ERROR_TARGET_NOT_PLUGGED_IN}
+	ErrBusVersionMismatch        = &VigemError{VIGEM_ERROR_BUS_VERSION_MISMATCH}
+	ErrBusAccessFailed           = &VigemError{VIGEM_ERROR_BUS_ACCESS_FAILED}
+	ErrCallbackAlreadyRegistered = &VigemError{VIGEM_ERROR_CALLBACK_ALREADY_REGISTERED}
+	ErrCallbackNotFound          = &VigemError{VIGEM_ERROR_CALLBACK_NOT_FOUND}
+	ErrBusAlreadyConnected       = &VigemError{VIGEM_ERROR_BUS_ALREADY_CONNECTED}
+	ErrBusInvalidHandle          = &VigemError{VIGEM_ERROR_BUS_INVALID_HANDLE}
+	ErrUserIndexOutOfRange       = &VigemError{VIGEM_ERROR_XUSB_USERINDEX_OUT_OF_RANGE}
+)
+
 func NewVigemError(rawCode uintptr) *VigemError {
 	code := uint(rawCode)
 
@@ -73,6 +187,19 @@ func NewVigemError(rawCode uintptr) *VigemError {
 	return &VigemError{code}
 }
 
+// Code returns the VIGEM_ERROR_* code of the error.
+func (err *VigemError) Code() uint {
+	return err.code
+}
+
+// Is reports whether target is a VigemError with the same code, so that
+// errors.Is(err, ErrNoFreeSlot) matches any "no free slot" error.
+func (err *VigemError) Is(target error) bool {
+	t, ok := target.(*VigemError)
+
+	return ok && t != nil && t.code == err.code
+}
+
 func (err *VigemError) Error() string {
 	switch err.code {
 	case VIGEM_ERROR_BUS_NOT_FOUND:
@@ -116,9 +243,27 @@ type Emulator struct {
 type Vibration struct {
 	LargeMotor byte
 	SmallMotor byte
+
+	// LedNumber is the player LED assigned to an emulated Xbox 360 controller
+	// by Windows, from 0 (player 1) to 3 (player 4). It is always zero for
+	// DualShock 4 controllers, and ignored by StadiaController.Vibrate.
+	LedNumber byte
+
+	// LeftTrigger and RightTrigger are the strengths of the trigger motors
+	// of Xbox One controllers. The Xbox 360 and DualShock 4 controllers
+	// emulated by ViGEm have no such motors, so they are always zero for now.
+	LeftTrigger  byte
+	RightTrigger byte
 }
 
+// NewEmulator connects to ViGEmBus. It returns an error wrapping
+// ErrVigemClientNotFound or ErrVigemClientOutdated if ViGEmClient.dll cannot
+// be used, and ErrBusNotFound if ViGEmBus is not installed.
 func NewEmulator(onVibration func(vibration Vibration)) (*Emulator, error) {
+	if err := loadVigemClient(); err != nil {
+		return nil, fmt.Errorf("%w (ViGEmBus must be installed, and ViGEmClient.dll must be next to the executable or on the PATH)", err)
+	}
+
 	handle, _, err := procAlloc.Call()
 
 	if !errors.Is(err, windows.ERROR_SUCCESS) {
@@ -144,21 +289,54 @@ func (e *Emulator) Close() error {
 	return err
 }
 
-func (e *Emulator) CreateXbox360Controller() (*Xbox360Controller, error) {
+// An Xbox360Option configures a controller created by
+// CreateXbox360Controller.
+type Xbox360Option func(*Xbox360Controller)
+
+// OnXbox360Connected registers a function called when Windows has bound the
+// emulated controller, i.e. when the first notification is received after
+// Connect. It is called from a ViGEm thread, and must not block.
+func OnXbox360Connected(onConnected func()) Xbox360Option {
+	return func(c *Xbox360Controller) {
+		c.onConnected = onConnected
+	}
+}
+
+// OnXbox360Disconnected registers a function called once the emulated
+// controller is removed by Disconnect.
+func OnXbox360Disconnected(onDisconnected func()) Xbox360Option {
+	return func(c *Xbox360Controller) {
+		c.onDisconnected = onDisconnected
+	}
+}
+
+func (e *Emulator) CreateXbox360Controller(opts ...Xbox360Option) (*Xbox360Controller, error) {
 	handle, _, err := procTargetX360Alloc.Call()
 
 	if !errors.Is(err, windows.ERROR_SUCCESS) {
 		return nil, err
 	}
 
+	controller := &Xbox360Controller{emulator: e, handle: handle}
+
+	for _, opt := range opts {
+		opt(controller)
+	}
+
 	notificationHandler := func(client, target uintptr, largeMotor, smallMotor, ledNumber byte) uintptr {
-		e.onVibration(Vibration{largeMotor, smallMotor})
+		atomic.StoreUint32(&controller.ledNumber, uint32(ledNumber)+1)
+
+		if atomic.CompareAndSwapUint32(&controller.bound, 0, 1) && controller.onConnected != nil {
+			controller.onConnected()
+		}
+
+		e.onVibration(Vibration{LargeMotor: largeMotor, SmallMotor: smallMotor, LedNumber: ledNumber})
 
 		return 0
 	}
-	callback := windows.NewCallback(notificationHandler)
+	controller.notificationHandler = windows.NewCallback(notificationHandler)
 
-	return &Xbox360Controller{e, handle, false, callback}, nil
+	return controller, nil
 }
 
 type x360NotificationHandler func(client, target uintptr, largeMotor, smallMotor, ledNumber byte) uintptr
@@ -168,6 +346,34 @@ type Xbox360Controller struct {
 	handle              uintptr
 	connected           bool
 	notificationHandler uintptr
+
+	// lastSent is the last report sent successfully, if hasSent is true.
+	lastSent [XUSBReportSize]byte
+	hasSent  bool
+
+	// ledNumber is the last LED number received in a notification plus one,
+	// or 0 if no notification was received. It is accessed atomically.
+	ledNumber uint32
+
+	// bound is 1 once a notification was received since Connect. It is
+	// accessed atomically.
+	bound          uint32
+	onConnected    func()
+	onDisconnected func()
+}
+
+// LEDNumber returns the LED number last assigned to the controller by
+// Windows, from 0 (player 1) to 3 (player 4). ok is false if no LED number
+// was received yet, which happens until a game or Windows first sends a
+// notification to the controller.
+func (c *Xbox360Controller) LEDNumber() (ledNumber byte, ok bool) {
+	value := atomic.LoadUint32(&c.ledNumber)
+
+	if value == 0 {
+		return 0, false
+	}
+
+	return byte(value - 1), true
 }
 
 func (c *Xbox360Controller) Close() error {
@@ -177,6 +383,10 @@ func (c *Xbox360Controller) Close() error {
 }
 
 func (c *Xbox360Controller) Connect() error {
+	// Reset before registering for notifications, which may arrive right
+	// away.
+	atomic.StoreUint32(&c.bound, 0)
+
 	libErr, _, err := procTargetAdd.Call(c.emulator.handle, c.handle)
 
 	if !errors.Is(err, windows.ERROR_SUCCESS) {
@@ -196,6 +406,7 @@ func (c *Xbox360Controller) Connect() error {
 	}
 
 	c.connected = true
+	c.hasSent = false
 
 	return nil
 }
@@ -221,99 +432,74 @@ func (c *Xbox360Controller) Disconnect() error {
 
 	c.connected = false
 
-	return nil
-}
-
-func (c *Xbox360Controller) Send(report *Xbox360ControllerReport) error {
-	libErr, _, err := procTargetX360Update.Call(c.emulator.handle, c.handle, uintptr(unsafe.Pointer(&report.native)))
-
-	if !errors.Is(err, windows.ERROR_SUCCESS) {
-		return err
-	}
-	if err := NewVigemError(libErr); err != nil {
-		return err
+	if c.onDisconnected != nil {
+		c.onDisconnected()
 	}
 
 	return nil
 }
 
-type Xbox360ControllerReport struct {
-	native    C.xusb_report
-	Capture   bool
-	Assistant bool
-}
-
-// Bits that correspond to the Xbox 360 controller buttons.
-const (
-	Xbox360ControllerButtonUp            = 0
-	Xbox360ControllerButtonDown          = 1
-	Xbox360ControllerButtonLeft          = 2
-	Xbox360ControllerButtonRight         = 3
-	Xbox360ControllerButtonStart         = 4
-	Xbox360ControllerButtonBack          = 5
-	Xbox360ControllerButtonLeftThumb     = 6
-	Xbox360ControllerButtonRightThumb    = 7
-	Xbox360ControllerButtonLeftShoulder  = 8
-	Xbox360ControllerButtonRightShoulder = 9
-	Xbox360ControllerButtonGuide         = 10
-	Xbox360ControllerButtonA             = 12
-	Xbox360ControllerButtonB             = 13
-	Xbox360ControllerButtonX             = 14
-	Xbox360ControllerButtonY             = 15
-)
+// Index returns the index assigned to the controller by ViGEm when it was
+// connected.
+func (c *Xbox360Controller) Index() (uint, error) {
+	if !c.connected {
+		return 0, NewVigemError(VIGEM_ERROR_TARGET_NOT_PLUGGED_IN)
+	}
 
-func NewXbox360ControllerReport() Xbox360ControllerReport {
-	return Xbox360ControllerReport{}
-}
+	index, _, err := procTargetGetIndex.Call(c.handle)
 
-func (r *Xbox360ControllerReport) GetButtons() uint16 {
-	return uint16(r.native.wButtons)
-}
+	if !errors.Is(err, windows.ERROR_SUCCESS) {
+		return 0, err
+	}
 
-func (r *Xbox360ControllerReport) SetButtons(buttons uint16) {
-	r.native.wButtons = C.uint16_t(buttons)
+	return uint(index), nil
 }
 
-func (r *Xbox360ControllerReport) MaybeSetButton(shiftBy int, isSet bool) {
-	if isSet {
-		r.SetButton(shiftBy)
+// UserIndex returns the XInput user index assigned to the controller by
+// Windows, from 0 (player 1) to 3 (player 4).
+func (c *Xbox360Controller) UserIndex() (int, error) {
+	if !c.connected {
+		return 0, NewVigemError(VIGEM_ERROR_TARGET_NOT_PLUGGED_IN)
 	}
-}
 
-func (r *Xbox360ControllerReport) SetButton(shiftBy int) {
-	r.native.wButtons |= 1 << shiftBy
-}
+	var index uint32
+	libErr, _, err := procTargetX360GetUserIndex.Call(c.emulator.handle, c.handle, uintptr(unsafe.Pointer(&index)))
 
-func (r *Xbox360ControllerReport) GetLeftTrigger() byte {
-	return byte(r.native.bLeftTrigger)
-}
+	if !errors.Is(err, windows.ERROR_SUCCESS) {
+		return 0, err
+	}
+	if err := NewVigemError(libErr); err != nil {
+		return 0, err
+	}
 
-func (r *Xbox360ControllerReport) SetLeftTrigger(value byte) {
-	r.native.bLeftTrigger = C.uint8_t(value)
+	return int(index), nil
 }
 
-func (r *Xbox360ControllerReport) GetRightTrigger() byte {
-	return byte(r.native.bRightTrigger)
+// Send updates the state of the emulated controller. Reports identical to the
+// last report sent are skipped; Capture and Assistant, which are not sent to
+// the emulated controller, are ignored in this comparison.
+func (c *Xbox360Controller) Send(report *Xbox360ControllerReport) error {
+	return c.SendRaw(report.native.Bytes())
 }
 
-func (r *Xbox360ControllerReport) SetRightTrigger(value byte) {
-	r.native.bRightTrigger = C.uint8_t(value)
-}
+// SendRaw updates the state of the emulated controller with the given
+// little-endian XUSB_REPORT, as returned by XUSBReport.Bytes. Like Send, it
+// skips reports identical to the last report sent.
+func (c *Xbox360Controller) SendRaw(buf [XUSBReportSize]byte) error {
+	if c.hasSent && buf == c.lastSent {
+		return nil
+	}
 
-func (r *Xbox360ControllerReport) GetLeftThumb() (x, y int16) {
-	return int16(r.native.sThumbLX), int16(r.native.sThumbLY)
-}
+	libErr, _, err := procTargetX360Update.Call(c.emulator.handle, c.handle, uintptr(unsafe.Pointer(&buf[0])))
 
-func (r *Xbox360ControllerReport) SetLeftThumb(x, y int16) {
-	r.native.sThumbLX = C.int16_t(x)
-	r.native.sThumbLY = C.int16_t(y)
-}
+	if !errors.Is(err, windows.ERROR_SUCCESS) {
+		return err
+	}
+	if err := NewVigemError(libErr); err != nil {
+		return err
+	}
 
-func (r *Xbox360ControllerReport) GetRightThumb() (x, y int16) {
-	return int16(r.native.sThumbRX), int16(r.native.sThumbRY)
-}
+	c.lastSent, c.hasSent = buf, true
 
-func (r *Xbox360ControllerReport) SetRightThumb(x, y int16) {
-	r.native.sThumbRX = C.int16_t(x)
-	r.native.sThumbRY = C.int16_t(y)
+	return nil
 }
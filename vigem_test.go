@@ -0,0 +1,74 @@
+package stadiacontroller
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestVigemErrors(t *testing.T) {
+	sentinels := []struct {
+		code     uint
+		sentinel error
+		message  string
+	}{
+		{VIGEM_ERROR_BUS_NOT_FOUND, ErrBusNotFound, "bus not found"},
+		{VIGEM_ERROR_NO_FREE_SLOT, ErrNoFreeSlot, "no free slot"},
+		{VIGEM_ERROR_INVALID_TARGET, ErrInvalidTarget, "invalid target"},
+		{VIGEM_ERROR_REMOVAL_FAILED, ErrRemovalFailed, "removal failed"},
+		{VIGEM_ERROR_ALREADY_CONNECTED, ErrAlreadyConnected, "already connected"},
+		{VIGEM_ERROR_TARGET_UNINITIALIZED, ErrTargetUninitialized, "target uninitialized"},
+		{VIGEM_ERROR_TARGET_NOT_PLUGGED_IN, ErrTargetNotPluggedIn, "target not plugged in"},
+		{VIGEM_ERROR_BUS_VERSION_MISMATCH, ErrBusVersionMismatch, "bus version mismatch"},
+		{VIGEM_ERROR_BUS_ACCESS_FAILED, ErrBusAccessFailed, "bus access failed"},
+		{VIGEM_ERROR_CALLBACK_ALREADY_REGISTERED, ErrCallbackAlreadyRegistered, "callback already registered"},
+		{VIGEM_ERROR_CALLBACK_NOT_FOUND, ErrCallbackNotFound, "callback not found"},
+		{VIGEM_ERROR_BUS_ALREADY_CONNECTED, ErrBusAlreadyConnected, "bus already connected"},
+		{VIGEM_ERROR_BUS_INVALID_HANDLE, ErrBusInvalidHandle, "bus invalid handle"},
+		{VIGEM_ERROR_XUSB_USERINDEX_OUT_OF_RANGE, ErrUserIndexOutOfRange, "xusb userindex out of range"},
+	}
+
+	for _, test := range sentinels {
+		err := NewVigemError(uintptr(test.code))
+
+		if err == nil {
+			t.Errorf("NewVigemError(%#x) = nil", test.code)
+			continue
+		}
+		if code := err.Code(); code != test.code {
+			t.Errorf("NewVigemError(%#x).Code() = %#x", test.code, code)
+		}
+		if message := err.Error(); message != test.message {
+			t.Errorf("NewVigemError(%#x).Error() = %q, want %q", test.code, message, test.message)
+		}
+
+		wrapped := fmt.Errorf("cannot connect: %w", err)
+
+		for _, other := range sentinels {
+			if got, want := errors.Is(wrapped, other.sentinel), other.code == test.code; got != want {
+				t.Errorf("errors.Is(%#x, %v) = %v, want %v", test.code, other.sentinel, got, want)
+			}
+		}
+
+		var vigemErr *VigemError
+
+		if !errors.As(wrapped, &vigemErr) || vigemErr.Code() != test.code {
+			t.Errorf("errors.As(%#x) did not return the VigemError", test.code)
+		}
+	}
+
+	if err := NewVigemError(VIGEM_ERROR_NONE); err != nil {
+		t.Errorf("NewVigemError(VIGEM_ERROR_NONE) = %v, want nil", err)
+	}
+
+	unknown := NewVigemError(VIGEM_ERROR_MAX)
+
+	if message := unknown.Error(); message != "invalid code returned by ViGEm" {
+		t.Errorf("NewVigemError(VIGEM_ERROR_MAX).Error() = %q", message)
+	}
+	for _, sentinel := range sentinels {
+		if errors.Is(unknown, sentinel.sentinel) {
+			t.Errorf("errors.Is(VIGEM_ERROR_MAX, %v) = true", sentinel.sentinel)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package stadiacontroller
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// embeddedVigemClient is the content of ViGEmClient.dll when it is embedded
+// in the binary by building with the embed_vigem tag, and nil otherwise.
+var embeddedVigemClient []byte
+
+// UseEmbeddedVigemClient extracts the ViGEmClient.dll embedded in the binary
+// to the local application data directory, and makes the emulator load it
+// from there. It does nothing if no DLL was embedded, in which case the DLL
+// is looked for in the DLL search path.
+//
+// The extracted DLL is named after its hash, so it is only written once per
+// version, and is written to a temporary file renamed into place so that
+// concurrent launches do not load a partially written DLL.
+func UseEmbeddedVigemClient() error {
+	if embeddedVigemClient == nil {
+		return nil
+	}
+
+	cacheDir, err := os.UserCacheDir()
+
+	if err != nil {
+		return fmt.Errorf("cannot extract ViGEmClient.dll: %w", err)
+	}
+
+	hash := sha256.Sum256(embeddedVigemClient)
+	dir := filepath.Join(cacheDir, "stadiacontroller")
+	path := filepath.Join(dir, "ViGEmClient-"+hex.EncodeToString(hash[:8])+".dll")
+
+	if _, err := os.Stat(path); err != nil {
+		if err := extractVigemClient(dir, path); err != nil {
+			return fmt.Errorf("cannot extract ViGEmClient.dll: %w", err)
+		}
+	}
+
+	SetVigemClientPath(path)
+
+	return nil
+}
+
+func extractVigemClient(dir, path string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	file, err := ioutil.TempFile(dir, "ViGEmClient-*.tmp")
+
+	if err != nil {
+		return err
+	}
+
+	_, err = file.Write(embeddedVigemClient)
+
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), path)
+	}
+	if err != nil {
+		os.Remove(file.Name())
+
+		// Another instance may have extracted the DLL in the meantime.
+		if _, statErr := os.Stat(path); statErr == nil {
+			return nil
+		}
+	}
+
+	return err
+}
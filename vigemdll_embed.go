@@ -0,0 +1,16 @@
+//go:build embed_vigem
+// +build embed_vigem
+
+package stadiacontroller
+
+import _ "embed"
+
+// ViGEmClient.dll must be copied next to this file before building with the
+// embed_vigem tag.
+//
+//go:embed ViGEmClient.dll
+var vigemClientDLL []byte
+
+func init() {
+	embeddedVigemClient = vigemClientDLL
+}